@@ -1,6 +1,7 @@
 package agency
 
 import (
+	"fmt"
 	"github.com/d0rc/agent-os/cmds"
 	"github.com/d0rc/agent-os/engines"
 	"github.com/d0rc/agent-os/stdlib/message-store"
@@ -33,9 +34,12 @@ func keys(to map[string]struct{}) []string {
 }
 func writeMessagesTrace(agentState *GeneralAgentInfo, message *engines.Message) {
 	if ShouldWriteMessageTrace {
-		agentState.Server.RunRequest(&cmds.ClientRequest{
+		_, err := agentState.Server.RunRequest(&cmds.ClientRequest{
 			ProcessName:        agentState.SystemName,
 			WriteMessagesTrace: []*engines.Message{message},
 		}, 120*time.Second, os_client.REP_IO)
+		if err != nil {
+			fmt.Printf("error writing messages trace: %v\n", err)
+		}
 	}
 }
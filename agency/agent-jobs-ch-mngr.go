@@ -1,6 +1,9 @@
 package agency
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/d0rc/agent-os/cmds"
 	"sync/atomic"
 )
@@ -21,9 +24,67 @@ func (agentState *GeneralAgentInfo) jobsChannelManager() {
 					<-maxJobThreads
 					atomic.AddUint64(&agentState.jobsFinished, 1)
 				}()
-				resp := agentState.Server.RunRequest(job, JobsManagerInferenceTimeout, JobsManagerExecutionPool)
+				jobs := agentState.trackJob(job.CorrelationId)
+				defer agentState.untrackJob(job.CorrelationId, jobs)
+
+				resp, err := agentState.Server.RunRequestContext(jobs.ctx, job, JobsManagerInferenceTimeout, JobsManagerExecutionPool)
+				if errors.Is(err, context.Canceled) {
+					return
+				}
+				if err != nil {
+					fmt.Printf("error running agent job: %v\n", err)
+				}
 				agentState.resultsChannel <- resp
 			}(job)
 		}
 	}
 }
+
+// inflightJobs - jobs sent for the same correlation id share the context,
+// so they can be cancelled all at once
+type inflightJobs struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	count  int
+}
+
+func (agentState *GeneralAgentInfo) trackJob(correlationId string) *inflightJobs {
+	agentState.inflightJobsLock.Lock()
+	defer agentState.inflightJobsLock.Unlock()
+
+	jobs, exists := agentState.inflightJobs[correlationId]
+	if !exists {
+		ctx, cancel := context.WithCancel(context.Background())
+		jobs = &inflightJobs{ctx: ctx, cancel: cancel}
+		agentState.inflightJobs[correlationId] = jobs
+	}
+	jobs.count++
+
+	return jobs
+}
+
+// untrackJob - jobs are the ones returned by trackJob, once they were cancelled,
+// jobs tracked under the same correlation id later on are not touched
+func (agentState *GeneralAgentInfo) untrackJob(correlationId string, jobs *inflightJobs) {
+	agentState.inflightJobsLock.Lock()
+	defer agentState.inflightJobsLock.Unlock()
+
+	jobs.count--
+	if jobs.count <= 0 {
+		jobs.cancel()
+		if agentState.inflightJobs[correlationId] == jobs {
+			delete(agentState.inflightJobs, correlationId)
+		}
+	}
+}
+
+// cancelJobs - cancels all in-flight jobs sent for the correlation id
+func (agentState *GeneralAgentInfo) cancelJobs(correlationId string) {
+	agentState.inflightJobsLock.Lock()
+	defer agentState.inflightJobsLock.Unlock()
+
+	if jobs, exists := agentState.inflightJobs[correlationId]; exists {
+		jobs.cancel()
+		delete(agentState.inflightJobs, correlationId)
+	}
+}
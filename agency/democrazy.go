@@ -74,7 +74,7 @@ Respond in the JSON format:
 
 	minResults := VoterMinResults
 retryVoting:
-	serverResponse := agentState.Server.RunRequest(&cmds.ClientRequest{
+	serverResponse, err := agentState.Server.RunRequest(&cmds.ClientRequest{
 		ProcessName: "action-voter",
 		Priority:    borrow_engine.PRIO_User,
 		GetCompletionRequests: tools.Replicate(
@@ -83,6 +83,9 @@ retryVoting:
 				MinResults: minResults,
 			}, minResults),
 	}, 120*time.Second, os_client.REP_Default)
+	if err != nil {
+		return 0, err
+	}
 
 	if serverResponse.GetCompletionResponse == nil || len(serverResponse.GetCompletionResponse) == 0 {
 		return 0, fmt.Errorf("no completions returned")
@@ -47,6 +47,9 @@ type GeneralAgentInfo struct {
 	waitingResponseTo map[string]int
 
 	space *message_store.SemanticSpace
+
+	inflightJobsLock sync.Mutex
+	inflightJobs     map[string]*inflightJobs
 }
 
 func (agentState *GeneralAgentInfo) ParseResponse(response string) ([]*ResponseParserResult, string, string, error) {
@@ -78,6 +81,7 @@ func NewGeneralAgentState(client *os_client.AgentOSClient, systemName string, co
 		historyUpdated:         make(chan struct{}, 1),
 		//quitIoProcessing:         make(chan struct{}, 1),
 		quitHistoryAppender: make(chan struct{}, 1),
+		inflightJobs:        make(map[string]*inflightJobs),
 		systemWriterChannel: make(chan *engines.Message, 100),
 
 		terminalsVisitsMap: make(map[string]int),
@@ -119,6 +123,9 @@ func (agentState *GeneralAgentInfo) agentStateResultsReceiver() {
 			if serverResult != nil && serverResult.GetCompletionResponse != nil &&
 				len(serverResult.GetCompletionResponse) > 0 {
 				for _, jobResult := range serverResult.GetCompletionResponse {
+					if jobResult == nil {
+						continue
+					}
 					for _, choice := range jobResult.Choices {
 						thisMessageId := engines.GenerateMessageId(choice)
 						resultMessage := &engines.Message{
@@ -42,9 +42,8 @@ func (agentState *GeneralAgentInfo) ioRequestsProcessing() {
 				}
 				ioResponses, err := agentState.Server.RunRequests(ioRequests, 600*time.Second)
 				if err != nil {
+					// some requests failed, still going to use the ones which succeeded
 					fmt.Printf("error running IO request: %v\n", err)
-					// we have to retry running request, it's easy, just send message ourselves again
-					return
 				}
 
 				// fmt.Printf("Got responses: %v\n", res)
@@ -91,6 +90,13 @@ func GenerateObservationFromServerResults(request *cmds.ClientRequest, response
 
 	if response.GoogleSearchResponse != nil && len(response.GoogleSearchResponse) > 0 {
 		for _, searchResponse := range response.GoogleSearchResponse {
+			if searchResponse == nil {
+				continue
+			}
+			if searchResponse.Error != nil {
+				observation += fmt.Sprintf("Search failed: %s\n\n", searchResponse.Error.Message)
+				continue
+			}
 			//observation += fmt.Sprintf("Search results for \"%s\":\n", searchResponse.Keywords)
 			for _, searchResult := range searchResponse.URLSearchInfos {
 				observation += fmt.Sprintf("%s\n%s\n%s\n\n", searchResult.Title, searchResult.URL, searchResult.Snippet)
@@ -104,6 +110,11 @@ func GenerateObservationFromServerResults(request *cmds.ClientRequest, response
 
 	if len(response.GetPageResponse) > 0 {
 		for _, pageResponse := range response.GetPageResponse {
+			if pageResponse != nil && pageResponse.Error != nil {
+				observations = append(observations, fmt.Sprintf("Failed to load page \"%s\": %s\n",
+					pageResponse.Url, pageResponse.Error.Message))
+				continue
+			}
 			if pageResponse != nil && pageResponse.Markdown != "" {
 				observation += fmt.Sprintf("Page content for \"%s\":\n", pageResponse.Url)
 				observation += fmt.Sprintf("```\n%s\n```\n", pageResponse.Markdown)
@@ -10,6 +10,10 @@ import (
 func (agentState *GeneralAgentInfo) SoTPipeline(growthFactor, maxRequests, maxPendingRequests int) {
 	semanticSpace := message_store.NewSemanticSpace(growthFactor)
 	agentState.space = semanticSpace
+	// once the space stops waiting for a trajectory, compute still running for it is wasted
+	semanticSpace.OnTrajectoryDone(func(trajectoryID message_store.TrajectoryID) {
+		agentState.cancelJobs(string(trajectoryID))
+	})
 	systemMessage, err := agentState.GetSystemMessage()
 	if err != nil {
 		return
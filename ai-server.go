@@ -1,19 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/d0rc/agent-os/cmds"
+	"github.com/d0rc/agent-os/engines"
 	process_embeddings "github.com/d0rc/agent-os/process-embeddings"
 	"github.com/d0rc/agent-os/stdlib/metrics"
 	"github.com/d0rc/agent-os/syslib/server"
 	trx_cache "github.com/d0rc/agent-os/syslib/trx-cache"
 	"github.com/d0rc/agent-os/syslib/utils"
+	"github.com/rs/zerolog"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -48,15 +57,22 @@ func main() {
 		ctx.LaunchWorker("background{embeddings}", process_embeddings.BackgroundEmbeddingsWorker)
 	})
 
+	// compute nodes are re-read from config on SIGHUP or admin request
+	go reloadComputeOnSignal(lg, ctx)
+	// compute usage accounted since the last snapshot is saved before exiting
+	go saveComputeUsageOnExit(lg, ctx)
+
 	cache := trx_cache.NewTrxCache()
 
 	// start a http server on port 9000
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	handleInstrumented("/", func(w http.ResponseWriter, r *http.Request) {
 		metrics.Tick("http.requests", 1)
 		// read the request
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			lg.Error().Err(err).Msg("failed to read request")
+			writeServerError(lg, w, cmds.NewResponseError(cmds.ErrCodeBadRequest, true,
+				"failed to read request: %v", err))
 			return
 		}
 		_ = r.Body.Close()
@@ -65,11 +81,23 @@ func main() {
 		err = json.Unmarshal(body, clientRequest)
 		if err != nil {
 			lg.Error().Err(err).Msg("error parsing client request")
+			writeServerError(lg, w, cmds.NewResponseError(cmds.ErrCodeBadRequest, false,
+				"error parsing client request: %v", err))
+			return
+		}
+
+		if clientRequest.Stream {
+			// streams are not replayable, so they bypass transactions cache
+			events := newEventStream(w)
+			resp := processRequestOrError(lg, clientRequest, ctx, func(chunk *cmds.StreamChunk) {
+				events.send("chunk", chunk)
+			})
+			events.send("response", resp)
 			return
 		}
 
 		respBytes := cache.GetValue(clientRequest.Trx, func() []byte {
-			resp, err := processRequest(clientRequest, ctx)
+			resp := processRequestOrError(lg, clientRequest, ctx, nil)
 
 			respBytes, err := json.Marshal(resp)
 			if err != nil {
@@ -80,12 +108,74 @@ func main() {
 			return respBytes
 		})
 
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(responseStatusCode(respBytes))
 		_, err = w.Write(respBytes)
 		if err != nil {
 			lg.Error().Err(err).Msg("error sending server response")
 		}
 	})
 
+	// OpenAI compatible end-points
+	handleInstrumented("/v1/chat/completions", openAIHandler(lg, func(reqCtx context.Context, body []byte, w http.ResponseWriter) (interface{}, error) {
+		request := &engines.ChatCompletionRequest{}
+		if err := json.Unmarshal(body, request); err != nil {
+			return nil, err
+		}
+		if request.Stream {
+			events := newEventStream(w)
+			_, err := cmds.ProcessOpenAIChatCompletion(reqCtx, request, ctx, func(chunk interface{}) {
+				events.send("", chunk)
+			})
+			return events.done(err)
+		}
+		return cmds.ProcessOpenAIChatCompletion(reqCtx, request, ctx, nil)
+	}))
+	handleInstrumented("/v1/completions", openAIHandler(lg, func(reqCtx context.Context, body []byte, w http.ResponseWriter) (interface{}, error) {
+		request := &cmds.OpenAICompletionRequest{}
+		if err := json.Unmarshal(body, request); err != nil {
+			return nil, err
+		}
+		if request.Stream {
+			events := newEventStream(w)
+			_, err := cmds.ProcessOpenAICompletion(reqCtx, request, ctx, func(chunk interface{}) {
+				events.send("", chunk)
+			})
+			return events.done(err)
+		}
+		return cmds.ProcessOpenAICompletion(reqCtx, request, ctx, nil)
+	}))
+	handleInstrumented("/v1/embeddings", openAIHandler(lg, func(reqCtx context.Context, body []byte, w http.ResponseWriter) (interface{}, error) {
+		request := &cmds.OpenAIEmbeddingsRequest{}
+		if err := json.Unmarshal(body, request); err != nil {
+			return nil, err
+		}
+		return cmds.ProcessOpenAIEmbeddings(reqCtx, request, ctx)
+	}))
+	handleInstrumented("/v1/models", openAIHandler(lg, func(reqCtx context.Context, body []byte, w http.ResponseWriter) (interface{}, error) {
+		return cmds.ProcessOpenAIListModels(ctx), nil
+	}))
+
+	// admin end-points, server listens on all interfaces, so they only accept local requests
+	handleInstrumented("/admin/reload", adminHandler(lg, func(r *http.Request) (interface{}, error) {
+		return ctx.ReloadCompute()
+	}))
+	handleInstrumented("/admin/benchmark", adminHandler(lg, func(r *http.Request) (interface{}, error) {
+		// can take minutes, as every node is swept with growing batches
+		return ctx.BenchmarkCompute(r.URL.Query().Get("endpoint"))
+	}))
+
+	// scraped by Prometheus, so it's not restricted to local requests and not instrumented itself
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		pw := metrics.NewWriter(w)
+		ctx.ComputeRouter.WriteMetrics(pw)
+		pw.WriteCountersAndHistograms()
+		if pw.Err() != nil {
+			lg.Error().Err(pw.Err()).Msg("error sending metrics")
+		}
+	})
+
 	workingHost := fmt.Sprintf("%s:%d", *host, *port)
 	lg.Info().Msgf("starting on: %s", workingHost)
 	err = http.ListenAndServe(workingHost, nil)
@@ -94,55 +184,380 @@ func main() {
 	}
 }
 
-func processRequest(request *cmds.ClientRequest, ctx *server.Context) (*cmds.ServerResponse, error) {
-	var result *cmds.ServerResponse = &cmds.ServerResponse{}
-	var err error
+// handleInstrumented - same as http.HandleFunc, but accounts latency of the requests
+func handleInstrumented(pattern string, handler http.HandlerFunc) {
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		ts := time.Now()
+		handler(w, r)
+		metrics.ObserveDuration("http.request.duration.seconds", time.Since(ts), "route", pattern)
+	})
+}
+
+func reloadComputeOnSignal(lg zerolog.Logger, ctx *server.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		result, err := ctx.ReloadCompute()
+		if err != nil {
+			lg.Error().Err(err).Msg("error reloading compute nodes, keeping the running ones")
+			continue
+		}
+		lg.Info().Msgf("compute nodes reloaded, added: %v, removed: %v, updated: %v",
+			result.Added, result.Removed, result.Updated)
+	}
+}
+
+func saveComputeUsageOnExit(lg zerolog.Logger, ctx *server.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	lg.Info().Msgf("got %s, saving compute usage before exiting", sig)
+	if err := ctx.SaveComputeUsage(); err != nil {
+		lg.Error().Err(err).Msg("error saving compute usage on exit")
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func adminHandler(lg zerolog.Logger, f func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopbackRequest(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp, err := f(r)
+		if err != nil {
+			lg.Error().Err(err).Msgf("error processing %s", r.URL.Path)
+			writeServerError(lg, w, cmds.NewResponseError(cmds.ErrCodeBadRequest, false,
+				"error processing %s: %v", r.URL.Path, err))
+			return
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			lg.Error().Err(err).Msg("error serializing admin response")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(respBytes)
+		if err != nil {
+			lg.Error().Err(err).Msg("error sending admin response")
+		}
+	}
+}
 
-	if request.GetPageRequests != nil && len(request.GetPageRequests) > 0 {
-		// got some page requests...!
-		ctx.ComputeRouter.AccountProcessRequest(request.ProcessName)
-		result, err = cmds.ProcessPageRequests(request.GetPageRequests, ctx)
+func isLoopbackRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
 	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// openAIHandler - f either returns response object to be sent as JSON,
+// or writes the event stream itself and returns errEventStreamSent
+func openAIHandler(lg zerolog.Logger, f func(reqCtx context.Context, body []byte, w http.ResponseWriter) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.Tick("http.requests", 1)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read request")
+			writeOpenAIError(lg, w, cmds.NewResponseError(cmds.ErrCodeBadRequest, true,
+				"failed to read request: %v", err))
+			return
+		}
+		_ = r.Body.Close()
+
+		// OpenAI end-points have no transactions, so client going away cancels the request
+		resp, err := f(r.Context(), body, w)
+		if err == errEventStreamSent {
+			return
+		}
+		if err != nil {
+			lg.Error().Err(err).Msgf("error processing %s", r.URL.Path)
+			writeOpenAIError(lg, w, err)
+			return
+		}
 
-	if request.GoogleSearchRequests != nil && len(request.GoogleSearchRequests) > 0 {
-		ctx.ComputeRouter.AccountProcessRequest(request.ProcessName)
-		result, err = cmds.ProcessGoogleSearches(request.GoogleSearchRequests, ctx)
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			lg.Error().Err(err).Msg("error serializing server response")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(respBytes)
+		if err != nil {
+			lg.Error().Err(err).Msg("error sending server response")
+		}
 	}
+}
 
-	if request.GetCompletionRequests != nil && len(request.GetCompletionRequests) > 0 {
-		ctx.ComputeRouter.AccountProcessRequest(request.ProcessName)
-		result, err = cmds.ProcessGetCompletions(request.GetCompletionRequests, ctx, request.ProcessName, request.Priority)
+// writeOpenAIError - errors of the server are sent with their HTTP status,
+// any other error is taken for a bad request
+func writeOpenAIError(lg zerolog.Logger, w http.ResponseWriter, err error) {
+	status, errType := http.StatusBadRequest, "invalid_request_error"
+	var responseError *cmds.ResponseError
+	if errors.As(err, &responseError) && responseError.Code != cmds.ErrCodeBadRequest {
+		status, errType = responseError.HTTPStatus(), "server_error"
 	}
 
-	if request.GetEmbeddingsRequests != nil && len(request.GetEmbeddingsRequests) > 0 {
-		ctx.ComputeRouter.AccountProcessRequest(request.ProcessName)
-		result, err = cmds.ProcessGetEmbeddings(request.GetEmbeddingsRequests, ctx, request.ProcessName, request.Priority)
+	respBytes, err := json.Marshal(cmds.NewOpenAIError(errType, err))
+	if err != nil {
+		lg.Error().Err(err).Msg("error serializing server response")
+		return
 	}
 
-	if request.GetCacheRecords != nil && len(request.GetCacheRecords) > 0 {
-		// ctx.ComputeRouter.AccountProcessRequest(request.ProcessName)
-		result, err = cmds.ProcessGetCacheRecords(request.GetCacheRecords, ctx, request.ProcessName)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(respBytes)
+	if err != nil {
+		lg.Error().Err(err).Msg("error sending server response")
 	}
+}
+
+var errEventStreamSent = errors.New("event stream sent")
+
+// eventStream - server-sent events writer, safe to use from several goroutines
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	lock    sync.Mutex
+}
 
-	if request.SetCacheRecords != nil && len(request.SetCacheRecords) > 0 {
-		// ctx.ComputeRouter.AccountProcessRequest(request.ProcessName)
-		result, err = cmds.ProcessSetCacheRecords(request.SetCacheRecords, ctx, request.ProcessName)
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	return &eventStream{
+		w:       w,
+		flusher: flusher,
 	}
+}
 
-	if request.WriteMessagesTrace != nil && len(request.WriteMessagesTrace) > 0 {
-		result, err = cmds.ProcessWriteMessagesTrace(request.ProcessName, request.WriteMessagesTrace, ctx, request.ProcessName)
+func (es *eventStream) send(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
 	}
 
-	if request.UIRequest != nil {
-		result, err = cmds.ProcessUIRequest(request.UIRequest, ctx)
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	if event != "" {
+		_, _ = fmt.Fprintf(es.w, "event: %s\n", event)
+	}
+	_, _ = fmt.Fprintf(es.w, "data: %s\n\n", data)
+	if es.flusher != nil {
+		es.flusher.Flush()
+	}
+}
+
+// done - finishes OpenAI style stream, reporting err in-band, as headers are already sent
+func (es *eventStream) done(err error) (interface{}, error) {
+	if err != nil {
+		es.send("", cmds.NewOpenAIError("server_error", err))
 	}
 
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	_, _ = fmt.Fprintf(es.w, "data: [DONE]\n\n")
+	if es.flusher != nil {
+		es.flusher.Flush()
+	}
+
+	return nil, errEventStreamSent
+}
+
+// processRequestOrError - turns request failure into a response with the error report
+func processRequestOrError(lg zerolog.Logger, request *cmds.ClientRequest, ctx *server.Context, onChunk func(chunk *cmds.StreamChunk)) *cmds.ServerResponse {
+	resp, err := processRequest(request, ctx, onChunk)
 	if err != nil {
-		return nil, err
+		lg.Error().Err(err).Msgf("error processing request, trx: %s", request.Trx)
+		if resp == nil {
+			resp = &cmds.ServerResponse{
+				Trx:                 request.Trx,
+				CorrelationId:       request.CorrelationId,
+				SpecialCaseResponse: request.SpecialCaseResponse,
+			}
+		}
+		resp.Error = cmds.ToResponseError(err)
+	}
+
+	return resp
+}
+
+// responseStatusCode - status is derived from the serialized response,
+// so responses replayed from transactions cache get the same status
+func responseStatusCode(respBytes []byte) int {
+	var resp struct {
+		Error *cmds.ResponseError `json:"error"`
+	}
+	if err := json.Unmarshal(respBytes, &resp); err != nil || resp.Error == nil {
+		return http.StatusOK
+	}
+
+	return resp.Error.HTTPStatus()
+}
+
+func writeServerError(lg zerolog.Logger, w http.ResponseWriter, responseError *cmds.ResponseError) {
+	respBytes, err := json.Marshal(&cmds.ServerResponse{Error: responseError})
+	if err != nil {
+		lg.Error().Err(err).Msg("error serializing server response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(responseError.HTTPStatus())
+	_, err = w.Write(respBytes)
+	if err != nil {
+		lg.Error().Err(err).Msg("error sending server response")
+	}
+}
+
+// processRequest - runs all populated sections of the request concurrently,
+// merging their results into a single response
+func processRequest(request *cmds.ClientRequest, ctx *server.Context, onChunk func(chunk *cmds.StreamChunk)) (*cmds.ServerResponse, error) {
+	// not bound to http request, as other requests with the same trx can wait for the result
+	reqCtx, cancel := cmds.NewRequestContext(request)
+	defer cancel()
+
+	sections := make([]func() (*cmds.ServerResponse, error), 0)
+	addSection := func(accounted bool, f func() (*cmds.ServerResponse, error)) {
+		if accounted {
+			ctx.ComputeRouter.AccountProcessRequest(request.ProcessName)
+		}
+		sections = append(sections, f)
+	}
+
+	if len(request.GetPageRequests) > 0 {
+		addSection(true, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessPageRequests(request.GetPageRequests, ctx)
+		})
+	}
+
+	if len(request.GoogleSearchRequests) > 0 {
+		addSection(true, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessGoogleSearches(request.GoogleSearchRequests, ctx)
+		})
+	}
+
+	if len(request.GetCompletionRequests) > 0 {
+		addSection(true, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessGetCompletionsStream(reqCtx, request.GetCompletionRequests, ctx, request.ProcessName, request.Priority, onChunk)
+		})
+	}
+
+	if len(request.GetEmbeddingsRequests) > 0 {
+		addSection(true, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessGetEmbeddings(reqCtx, request.GetEmbeddingsRequests, ctx, request.ProcessName, request.Priority)
+		})
+	}
+
+	if len(request.GetCacheRecords) > 0 {
+		addSection(false, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessGetCacheRecords(request.GetCacheRecords, ctx, request.ProcessName)
+		})
+	}
+
+	if len(request.SetCacheRecords) > 0 {
+		addSection(false, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessSetCacheRecords(request.SetCacheRecords, ctx, request.ProcessName)
+		})
+	}
+
+	if len(request.WriteMessagesTrace) > 0 {
+		addSection(false, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessWriteMessagesTrace(request.ProcessName, request.WriteMessagesTrace, ctx, request.ProcessName)
+		})
+	}
+
+	if len(request.CancelTrx) > 0 {
+		addSection(false, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessCancelRequests(request.CancelTrx, ctx)
+		})
+	}
+
+	if len(request.GetComputeUsage) > 0 {
+		addSection(false, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessGetComputeUsage(request.GetComputeUsage, ctx)
+		})
+	}
+
+	if request.UIRequest != nil {
+		addSection(false, func() (*cmds.ServerResponse, error) {
+			return cmds.ProcessUIRequestStream(reqCtx, request.UIRequest, ctx, onChunk)
+		})
+	}
+
+	results := make([]*cmds.ServerResponse, len(sections))
+	errs := make([]error, len(sections))
+	wg := sync.WaitGroup{}
+	for idx, section := range sections {
+		wg.Add(1)
+		go func(idx int, section func() (*cmds.ServerResponse, error)) {
+			defer wg.Done()
+			results[idx], errs[idx] = section()
+		}(idx, section)
+	}
+	wg.Wait()
+
+	result := &cmds.ServerResponse{}
+	for _, sectionResult := range results {
+		mergeServerResponse(result, sectionResult)
 	}
 
 	result.CorrelationId = request.CorrelationId
 	result.SpecialCaseResponse = request.SpecialCaseResponse
 
-	return result, nil
+	// results of the sections which succeeded are kept along with the error
+	return result, errors.Join(errs...)
+}
+
+// mergeServerResponse - copies sections of src into dst, each section
+// is produced by exactly one command, so nothing is overwritten
+func mergeServerResponse(dst, src *cmds.ServerResponse) {
+	if src == nil {
+		return
+	}
+
+	if src.GoogleSearchResponse != nil {
+		dst.GoogleSearchResponse = src.GoogleSearchResponse
+	}
+	if src.GetPageResponse != nil {
+		dst.GetPageResponse = src.GetPageResponse
+	}
+	if src.GetCompletionResponse != nil {
+		dst.GetCompletionResponse = src.GetCompletionResponse
+	}
+	if src.GetEmbeddingsResponse != nil {
+		dst.GetEmbeddingsResponse = src.GetEmbeddingsResponse
+	}
+	if src.GetCacheRecords != nil {
+		dst.GetCacheRecords = src.GetCacheRecords
+	}
+	if src.SetCacheRecords != nil {
+		dst.SetCacheRecords = src.SetCacheRecords
+	}
+	if src.CancelledTrx != nil {
+		dst.CancelledTrx = src.CancelledTrx
+	}
+	if src.GetComputeUsage != nil {
+		dst.GetComputeUsage = src.GetComputeUsage
+	}
+	if src.UIResponse != nil {
+		dst.UIResponse = src.UIResponse
+	}
+	if src.Error != nil && dst.Error == nil {
+		dst.Error = src.Error
+	}
 }
@@ -0,0 +1,118 @@
+package cmds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/syslib/borrow-engine"
+	"net"
+	"net/http"
+)
+
+type ErrorCode string
+
+const (
+	ErrCodeTimeout       ErrorCode = "timeout"
+	ErrCodeNotFound      ErrorCode = "not-found"
+	ErrCodeQuotaExceeded ErrorCode = "quota-exceeded"
+	ErrCodeUpstream      ErrorCode = "upstream-error"
+	ErrCodeBadRequest    ErrorCode = "bad-request"
+	ErrCodeNoCompute     ErrorCode = "no-compute"
+	ErrCodeInternal      ErrorCode = "internal-error"
+)
+
+// ResponseError - error report attached to a response item, or to the whole response
+type ResponseError struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Retryable bool      `json:"retryable"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *ResponseError) HTTPStatus() int {
+	switch e.Code {
+	case ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	case ErrCodeNotFound:
+		return http.StatusNotFound
+	case ErrCodeQuotaExceeded:
+		return http.StatusTooManyRequests
+	case ErrCodeUpstream:
+		return http.StatusBadGateway
+	case ErrCodeBadRequest:
+		return http.StatusBadRequest
+	case ErrCodeNoCompute:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func NewResponseError(code ErrorCode, retryable bool, format string, args ...interface{}) *ResponseError {
+	return &ResponseError{
+		Code:      code,
+		Message:   fmt.Sprintf(format, args...),
+		Retryable: retryable,
+	}
+}
+
+// ToResponseError - classifies arbitrary error, keeping ResponseError as is
+func ToResponseError(err error) *ResponseError {
+	if err == nil {
+		return nil
+	}
+
+	var responseError *ResponseError
+	if errors.As(err, &responseError) {
+		return responseError
+	}
+
+	if errors.Is(err, borrow_engine.ErrNoNodeForModel) {
+		return &ResponseError{Code: ErrCodeNoCompute, Message: err.Error(), Retryable: false}
+	}
+
+	if errors.Is(err, borrow_engine.ErrQuotaExceeded) {
+		return &ResponseError{Code: ErrCodeQuotaExceeded, Message: err.Error(), Retryable: true}
+	}
+
+	if errors.Is(err, borrow_engine.ErrStreamInterrupted) {
+		// client got part of the text, so it's up to the client to run the request again
+		return &ResponseError{Code: ErrCodeUpstream, Message: err.Error(), Retryable: true}
+	}
+
+	if errors.Is(err, borrow_engine.ErrJobRetriesExhausted) {
+		// the job itself is likely the problem, running it again won't help
+		return &ResponseError{Code: ErrCodeUpstream, Message: err.Error(), Retryable: false}
+	}
+
+	var netError net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netError) && netError.Timeout()) {
+		return &ResponseError{Code: ErrCodeTimeout, Message: err.Error(), Retryable: true}
+	}
+
+	return &ResponseError{Code: ErrCodeInternal, Message: err.Error(), Retryable: true}
+}
+
+// httpStatusError - maps upstream provider's http status to response error,
+// returns nil for successful statuses
+func httpStatusError(provider string, statusCode int) *ResponseError {
+	switch {
+	case statusCode < 400:
+		return nil
+	case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+		return NewResponseError(ErrCodeNotFound, false, "%s returned http %d", provider, statusCode)
+	case statusCode == http.StatusTooManyRequests:
+		return NewResponseError(ErrCodeQuotaExceeded, true, "%s returned http %d", provider, statusCode)
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusPaymentRequired:
+		return NewResponseError(ErrCodeQuotaExceeded, false, "%s returned http %d", provider, statusCode)
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return NewResponseError(ErrCodeTimeout, true, "%s returned http %d", provider, statusCode)
+	case statusCode >= 500:
+		return NewResponseError(ErrCodeUpstream, true, "%s returned http %d", provider, statusCode)
+	default:
+		return NewResponseError(ErrCodeUpstream, false, "%s returned http %d", provider, statusCode)
+	}
+}
@@ -1,6 +1,7 @@
 package cmds
 
 import (
+	"context"
 	"github.com/d0rc/agent-os/engines"
 	"github.com/d0rc/agent-os/syslib/borrow-engine"
 	"github.com/d0rc/agent-os/syslib/server"
@@ -8,14 +9,24 @@ import (
 	"github.com/google/uuid"
 )
 
-func SendComputeRequest(ctx *server.Context,
+const streamChannelSize = 1024
+
+// SendComputeRequest - queues compute job, the job is dropped from
+// the queues if reqCtx is done before the job is scheduled
+func SendComputeRequest(reqCtx context.Context,
+	ctx *server.Context,
 	process string,
 	jobType borrow_engine.JobType,
 	jobPriority borrow_engine.JobPriority,
+	modelMask string,
 	req *engines.GenerationSettings) *borrow_engine.ComputeResult {
 	computeResult := &borrow_engine.ComputeResult{
 		CompletionChannel: make(chan *engines.Message, 1),
 		EmbeddingChannel:  make(chan *vectors.Vector, 1),
+		ErrorChannel:      make(chan error, 1),
+	}
+	if req.Stream {
+		computeResult.StreamChannel = make(chan string, streamChannelSize)
 	}
 
 	// ctx.Log.Info().Msgf("Sending compute request for process %s, job type %s, job priority %s",
@@ -25,6 +36,9 @@ func SendComputeRequest(ctx *server.Context,
 		JobType:            jobType,
 		Priority:           jobPriority,
 		Process:            process,
+		ModelMask:          modelMask,
+		Tags:               borrow_engine.TagsFromContext(reqCtx),
+		Ctx:                reqCtx,
 		GenerationSettings: req,
 		ComputeResult:      computeResult,
 	})
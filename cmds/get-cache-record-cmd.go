@@ -15,6 +15,7 @@ func ProcessGetCacheRecords(requests []GetCacheRecord, ctx *server.Context, proc
 				Key:       request.Key,
 				Namespace: request.Namespace,
 				Value:     nil,
+				Error:     ToResponseError(err),
 			})
 			continue
 		}
@@ -1,7 +1,9 @@
 package cmds
 
 import (
+	"context"
 	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/stdlib/metrics"
 	"github.com/d0rc/agent-os/syslib/batcher"
 	"github.com/d0rc/agent-os/syslib/borrow-engine"
 	"github.com/d0rc/agent-os/syslib/server"
@@ -10,21 +12,41 @@ import (
 )
 
 func ProcessGetCompletions(request []GetCompletionRequest, ctx *server.Context, process string, priority borrow_engine.JobPriority) (response *ServerResponse, err error) {
+	return ProcessGetCompletionsStream(context.Background(), request, ctx, process, priority, nil)
+}
+
+// ProcessGetCompletionsStream - same as ProcessGetCompletions, but generated text is also
+// sent to onChunk as it arrives, onChunk can be called from several goroutines at once
+func ProcessGetCompletionsStream(reqCtx context.Context, request []GetCompletionRequest, ctx *server.Context, process string, priority borrow_engine.JobPriority, onChunk func(chunk *StreamChunk)) (response *ServerResponse, err error) {
 	// I've found no evidence that vllm supports batching for real
 	// so we can just launch parallel processing now
 	// later comment: and it's not the right place to make automatic batching...:)
 	results := make([]chan *GetCompletionResponse, len(request))
 	for idx, pr := range request {
 		results[idx] = make(chan *GetCompletionResponse, 1)
-		go func(cr GetCompletionRequest, ch chan *GetCompletionResponse) {
-			completionResponse, err := processGetCompletion(cr, ctx, process, priority)
+		go func(cr GetCompletionRequest, ch chan *GetCompletionResponse, idx int) {
+			var onDelta func(choice int, delta string)
+			if onChunk != nil {
+				onDelta = func(choice int, delta string) {
+					onChunk(&StreamChunk{
+						Section: StreamSectionCompletions,
+						Index:   idx,
+						Choice:  choice,
+						Delta:   delta,
+					})
+				}
+			}
+			completionResponse, err := processGetCompletion(reqCtx, cr, ctx, process, priority, onDelta)
 			if err != nil {
 				ctx.Log.Error().Err(err).
 					Msgf("Error processing completion request: ```%s```", aurora.Cyan(cr.RawPrompt))
+				completionResponse = &GetCompletionResponse{
+					Error: ToResponseError(err),
+				}
 			}
 
 			ch <- completionResponse
-		}(pr, results[idx])
+		}(pr, results[idx], idx)
 	}
 
 	finalResults := make([]*GetCompletionResponse, len(request))
@@ -48,15 +70,26 @@ type CompletionCacheRecord struct {
 	GenerationResult             string    `db:"generation_result"`
 }
 
-func processGetCompletion(cr GetCompletionRequest, ctx *server.Context, process string, priority borrow_engine.JobPriority) (*GetCompletionResponse, error) {
-	cachedResponse := make([]CompletionCacheRecord, 0, 1)
-	err := ctx.Storage.Db.GetStructsSlice("query-llm-cache", &cachedResponse,
-		len(cr.RawPrompt), cr.RawPrompt)
-
+// processGetCompletion - runs single completion request, if onDelta is not nil,
+// generated text is streamed to it, choice is the index in response choices
+func processGetCompletion(reqCtx context.Context, cr GetCompletionRequest, ctx *server.Context, process string, priority borrow_engine.JobPriority, onDelta func(choice int, delta string)) (*GetCompletionResponse, error) {
+	if err := validateCachePolicy(&cr); err != nil {
+		return nil, err
+	}
+	cacheKey, serializedSettings, err := llmCacheKey(&cr)
 	if err != nil {
-		ctx.Log.Error().Err(err).
-			Msgf("Failed to get cached response for prompt %s", cr.RawPrompt)
-		// just continue...
+		return nil, err
+	}
+
+	cachedResponse := make([]CompletionCacheRecord, 0, 1)
+	if readsCache(&cr) {
+		err = ctx.Storage.Db.GetStructsSlice("query-llm-cache", &cachedResponse, cacheKey)
+		if err != nil {
+			ctx.Log.Error().Err(err).
+				Msgf("Failed to get cached response for prompt %s", cr.RawPrompt)
+			// just continue...
+		}
+		cachedResponse = usableCacheRecords(&cr, cachedResponse, time.Now())
 	}
 
 	response := &GetCompletionResponse{
@@ -80,43 +113,88 @@ func processGetCompletion(cr GetCompletionRequest, ctx *server.Context, process
 		}
 
 		if len(response.Choices) >= cr.MinResults {
+			metrics.Tick("cache.llm.hits", 1)
+			if onDelta != nil {
+				for idx, choice := range response.Choices {
+					onDelta(idx, choice)
+				}
+			}
 			return response, nil
 		}
 	}
 
-	results := SendComputeRequest(ctx,
+	metrics.Tick("cache.llm.misses", 1)
+	// engines report usage before the final message is sent, so it's set once the completion is received
+	usage := &CompletionUsage{}
+	// choices of a single job can't be told apart in the stream, so they're sent once generated
+	stream := onDelta != nil && cr.N <= 1
+	generationSettings := &engines.GenerationSettings{
+		Messages:        convertTypes(cr.Messages),
+		AfterJoinPrefix: "",
+		RawPrompt:       cr.RawPrompt,
+		NoCache:         false,
+		Temperature:     cr.Temperature,
+		StopTokens:      cr.StopTokens,
+		BestOf:          cr.BestOf,
+		StatisticsCallback: func(info *engines.StatisticsInfo) {
+			usage.PromptTokens = info.PromptTokens
+			usage.GeneratedTokens = info.TokensGenerated
+			usage.Estimated = info.Estimated
+		},
+		MaxRetries:       1,
+		Stream:           stream,
+		MaxTokens:        cr.MaxTokens,
+		TopP:             cr.TopP,
+		TopK:             cr.TopK,
+		PresencePenalty:  cr.PresencePenalty,
+		FrequencyPenalty: cr.FrequencyPenalty,
+		Seed:             cr.Seed,
+		LogProbs:         cr.LogProbs,
+		N:                cr.N,
+		JSONSchema:       cr.JSONSchema,
+		Grammar:          cr.Grammar,
+	}
+	results := SendComputeRequest(reqCtx,
+		ctx,
 		process,
 		borrow_engine.JT_Completion,
 		priority,
-		&engines.GenerationSettings{
-			Messages:        convertTypes(cr.Messages),
-			AfterJoinPrefix: "",
-			RawPrompt:       cr.RawPrompt,
-			NoCache:         false,
-			Temperature:     cr.Temperature,
-			StopTokens:      cr.StopTokens,
-			BestOf:          cr.BestOf,
-			StatisticsCallback: func(info *engines.StatisticsInfo) {
-
-			},
-			MaxRetries: 1,
-		})
-	message := <-results.CompletionChannel
-
-	_, err = ctx.Storage.Db.Exec("insert-llm-cache-record",
 		cr.Model,
-		cr.RawPrompt,
-		len(cr.RawPrompt),
-		time.Now(),
-		"",
-		0,
-		message.Content)
+		generationSettings)
+	message, err := waitForCompletion(reqCtx, results, func(delta string) {
+		onDelta(len(response.Choices), delta)
+	})
 	if err != nil {
-		ctx.Log.Error().Err(err).
-			Msgf("error creating new llm cache record: %v", err)
+		return nil, err
 	}
 
-	response.Choices = append(response.Choices, message.Content)
+	for _, choice := range append([]*engines.Message{message}, message.Alternatives...) {
+		if writesCache(&cr) {
+			// compute router sets the model which was actually used
+			_, err = ctx.Storage.Db.Exec("insert-llm-cache-record",
+				generationSettings.Model,
+				cr.RawPrompt,
+				len(cr.RawPrompt),
+				time.Now().UTC(),
+				serializedSettings,
+				0,
+				choice.Content,
+				cacheKey)
+			if err != nil {
+				ctx.Log.Error().Err(err).
+					Msgf("error creating new llm cache record: %v", err)
+			}
+		}
+
+		if onDelta != nil && !stream {
+			onDelta(len(response.Choices), choice.Content)
+		}
+		response.Choices = append(response.Choices, choice.Content)
+		if cr.LogProbs > 0 {
+			response.LogProbs = append(response.LogProbs, choice.LogProbs)
+		}
+	}
+	response.Usage = usage
 
 	return response, nil
 }
@@ -133,3 +211,43 @@ func convertTypes(messages []*engines.Message) []engines.Message {
 
 	return result
 }
+
+// waitForCompletion - waits for the final message of the completion job,
+// passing streamed text to onDelta meanwhile
+func waitForCompletion(reqCtx context.Context, computeResult *borrow_engine.ComputeResult, onDelta func(delta string)) (*engines.Message, error) {
+	for {
+		select {
+		case delta := <-computeResult.StreamChannel:
+			onDelta(delta)
+		case err := <-computeResult.ErrorChannel:
+			return nil, err
+		case <-reqCtx.Done():
+			// queued job is dropped by the compute router, running one is let to finish,
+			// engines block on the stream if nobody reads it, so it has to be drained
+			go drainComputeResult(computeResult)
+			return nil, reqCtx.Err()
+		case message := <-computeResult.CompletionChannel:
+			// engines send all the deltas before the final message
+			for {
+				select {
+				case delta := <-computeResult.StreamChannel:
+					onDelta(delta)
+				default:
+					return message, nil
+				}
+			}
+		}
+	}
+}
+
+func drainComputeResult(computeResult *borrow_engine.ComputeResult) {
+	for {
+		select {
+		case <-computeResult.StreamChannel:
+		case <-computeResult.ErrorChannel:
+			return
+		case <-computeResult.CompletionChannel:
+			return
+		}
+	}
+}
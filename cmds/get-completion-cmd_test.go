@@ -1,16 +1,19 @@
 package cmds
 
 import (
+	"context"
+	"encoding/json"
+	"github.com/d0rc/agent-os/engines"
+	borrow_engine "github.com/d0rc/agent-os/syslib/borrow-engine"
 	zlog "github.com/rs/zerolog/log"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGetCompletionsCmd(t *testing.T) {
 	lg := zlog.Logger
-	storage, err := NewStorage(lg)
-	if err != nil {
-		lg.Fatal().Err(err).Msg("init storage failed")
-	}
+	ctx := newTestContext(t)
 
 	resp, err := ProcessGetCompletions([]GetCompletionRequest{
 		{
@@ -24,7 +27,105 @@ You are Default Mode Agent of AI Bot.
 			MinResults:  10,
 			MaxResults:  1,
 		},
-	}, storage)
+	}, ctx, "test", borrow_engine.PRIO_User)
 
 	lg.Info().Err(err).Interface("resp", resp).Msg("get completions")
 }
+
+func TestLLMCacheKey(t *testing.T) {
+	cacheKey := func(cr GetCompletionRequest) string {
+		key, _, err := llmCacheKey(&cr)
+		if err != nil {
+			t.Fatalf("error making llm cache key: %v", err)
+		}
+		return key
+	}
+
+	base := GetCompletionRequest{RawPrompt: "hello", Temperature: 0, StopTokens: []string{"a", "b"}}
+	key := cacheKey(base)
+	if len(key) != 64 {
+		t.Fatalf("expected sha256 hex key, got %q", key)
+	}
+
+	same := base
+	same.StopTokens = []string{"b", "a"}
+	same.N, same.LogProbs, same.BestOf = 3, 2, 1
+	if cacheKey(same) != key {
+		t.Fatalf("order of stop tokens, number of choices or log probabilities changed the key")
+	}
+
+	hot := base
+	hot.Temperature = 0.9
+	chat := base
+	chat.Messages = []*engines.Message{{Role: engines.ChatRoleUser, Content: "hello"}}
+	if cacheKey(hot) == key || cacheKey(chat) == key {
+		t.Fatalf("temperature or messages didn't change the key")
+	}
+
+	seed := 1
+	compact := base
+	compact.Seed = &seed
+	compact.JSONSchema = json.RawMessage(`{"type":"object"}`)
+	indented := compact
+	indented.JSONSchema = json.RawMessage("{\n  \"type\": \"object\"\n}")
+	if cacheKey(compact) == key || cacheKey(indented) != cacheKey(compact) {
+		t.Fatalf("json schema is not a part of the key or its formatting changed the key")
+	}
+
+	if _, _, err := llmCacheKey(&GetCompletionRequest{JSONSchema: json.RawMessage(`{"type":`)}); err == nil {
+		t.Fatalf("invalid json schema was accepted")
+	}
+}
+
+func TestUsableCacheRecords(t *testing.T) {
+	now := time.Now()
+	records := []CompletionCacheRecord{
+		{Id: 1, Model: "mistral-7b", CreatedAt: now.Add(-time.Hour)},
+		{Id: 2, Model: "mistral-7b", CreatedAt: now.Add(-time.Minute)},
+		{Id: 3, Model: "llama-70b", CreatedAt: now},
+	}
+
+	usable := usableCacheRecords(&GetCompletionRequest{Model: "mistral-*"}, records, now)
+	if len(usable) != 2 || usable[0].Id != 1 || usable[1].Id != 2 {
+		t.Fatalf("records of other models are used: %+v", usable)
+	}
+
+	usable = usableCacheRecords(&GetCompletionRequest{Model: "*", CachePolicy: CachePolicyFresherThan, MaxAge: 600}, records, now)
+	if len(usable) != 2 || usable[0].Id != 2 || usable[1].Id != 3 {
+		t.Fatalf("stale records are used: %+v", usable)
+	}
+
+	if err := validateCachePolicy(&GetCompletionRequest{CachePolicy: CachePolicyFresherThan}); err == nil {
+		t.Fatalf("fresher-than policy without max-age was accepted")
+	}
+	if readsCache(&GetCompletionRequest{CachePolicy: CachePolicyWriteOnly}) || writesCache(&GetCompletionRequest{CachePolicy: CachePolicyBypass}) {
+		t.Fatalf("cache policy is ignored")
+	}
+}
+
+func TestInterruptedStreamIsNotRetried(t *testing.T) {
+	mock := &engines.MockEngine{Responses: []*engines.MockResponse{
+		{Pattern: `interrupted`, Content: "half of the answer", Error: "engine overloaded", Times: 1},
+		{Pattern: `interrupted`, Content: "the whole answer"},
+	}}
+	ctx := newMockTestContext(t, mock)
+
+	lock := sync.Mutex{}
+	streamed := ""
+	resp, err := ProcessGetCompletionsStream(context.Background(),
+		[]GetCompletionRequest{{RawPrompt: "interrupted", MinResults: 1}},
+		ctx, "test", borrow_engine.PRIO_User, func(chunk *StreamChunk) {
+			lock.Lock()
+			streamed += chunk.Delta
+			lock.Unlock()
+		})
+	if err != nil || resp.GetCompletionResponse[0].Error == nil || !resp.GetCompletionResponse[0].Error.Retryable {
+		t.Fatalf("expected interrupted stream to fail the request, got %+v, %v", resp.GetCompletionResponse[0], err)
+	}
+	if streamed != "half of the answer" {
+		t.Fatalf("expected text streamed once, got %q", streamed)
+	}
+	if len(mock.Prompts()) != 1 {
+		t.Fatalf("expected streamed job not to be retried, got prompts %v", mock.Prompts())
+	}
+}
@@ -0,0 +1,148 @@
+package cmds
+
+import (
+	"fmt"
+	borrow_engine "github.com/d0rc/agent-os/syslib/borrow-engine"
+	"github.com/d0rc/agent-os/syslib/server"
+	"sort"
+	"strings"
+	"time"
+)
+
+func ProcessGetComputeUsage(requests []GetComputeUsage, ctx *server.Context) (*ServerResponse, error) {
+	// usage since the last snapshot is saved first, so reports are up to date
+	if err := ctx.SaveComputeUsage(); err != nil {
+		ctx.Log.Error().Err(err).Msg("error saving compute usage, report misses the latest usage")
+	}
+
+	results := make([]*GetComputeUsageResponse, 0, len(requests))
+	for _, request := range requests {
+		result, err := processGetComputeUsage(request, ctx)
+		if err != nil {
+			ctx.Log.Error().Err(err).Msg("error getting compute usage")
+			result = &GetComputeUsageResponse{
+				From:  request.From,
+				To:    request.To,
+				Error: ToResponseError(err),
+			}
+		}
+		results = append(results, result)
+	}
+
+	return &ServerResponse{
+		GetComputeUsage: results,
+	}, nil
+}
+
+func processGetComputeUsage(request GetComputeUsage, ctx *server.Context) (*GetComputeUsageResponse, error) {
+	groupBy := request.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = []string{UsageByProcess}
+	}
+	for _, group := range groupBy {
+		switch group {
+		case UsageByProcess, UsageByTag, UsageByNode, UsageByModel:
+		default:
+			return nil, NewResponseError(ErrCodeBadRequest, false,
+				"unknown group-by `%s`, expected any of: process, tag, node, model", group)
+		}
+	}
+
+	to := time.Now()
+	if request.To > 0 {
+		to = time.Unix(request.To, 0)
+	}
+	from := time.Unix(request.From, 0)
+	if !from.Before(to) {
+		return nil, NewResponseError(ErrCodeBadRequest, false, "empty time window from %d to %d", request.From, request.To)
+	}
+
+	records, err := ctx.Storage.GetComputeUsage(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error running query-compute-usage: %v", err)
+	}
+
+	groups := make(map[ComputeUsage]*ComputeUsage)
+	for _, record := range records {
+		if request.Process != "" && !borrow_engine.MatchModelMask(request.Process, record.Process) {
+			continue
+		}
+
+		for _, tag := range matchingTags(record.Tags, request.Tag, contains(groupBy, UsageByTag)) {
+			key := ComputeUsage{}
+			for _, group := range groupBy {
+				switch group {
+				case UsageByProcess:
+					key.Process = record.Process
+				case UsageByTag:
+					key.Tag = tag
+				case UsageByNode:
+					key.Node = record.Endpoint
+				case UsageByModel:
+					key.Model = record.Model
+				}
+			}
+
+			usage, exists := groups[key]
+			if !exists {
+				usage = &ComputeUsage{Process: key.Process, Tag: key.Tag, Node: key.Node, Model: key.Model}
+				groups[key] = usage
+			}
+			usage.Requests += record.Requests
+			usage.Jobs += record.Jobs
+			usage.PromptTokens += record.PromptTokens
+			usage.GeneratedTokens += record.GeneratedTokens
+			usage.GPUSeconds += record.GPUSeconds
+			usage.Cost += record.Cost
+		}
+	}
+
+	result := &GetComputeUsageResponse{
+		From:  from.Unix(),
+		To:    to.Unix(),
+		Usage: make([]*ComputeUsage, 0, len(groups)),
+	}
+	for _, usage := range groups {
+		result.Usage = append(result.Usage, usage)
+	}
+	// the most expensive groups go first
+	sort.Slice(result.Usage, func(i, j int) bool {
+		if result.Usage[i].GPUSeconds != result.Usage[j].GPUSeconds {
+			return result.Usage[i].GPUSeconds > result.Usage[j].GPUSeconds
+		}
+		return fmt.Sprint(*result.Usage[i]) < fmt.Sprint(*result.Usage[j])
+	})
+
+	return result, nil
+}
+
+// matchingTags - tags of the record, usage is accounted for each of them if it's grouped by tag,
+// otherwise record is accounted once, if any of its tags matches
+func matchingTags(tags string, mask string, byTag bool) []string {
+	recordTags := []string{""}
+	if tags != "" {
+		recordTags = strings.Split(tags, ",")
+	}
+
+	matching := make([]string, 0, len(recordTags))
+	for _, tag := range recordTags {
+		if mask == "" || (tag != "" && borrow_engine.MatchModelMask(mask, tag)) {
+			matching = append(matching, tag)
+		}
+	}
+	if !byTag && len(matching) > 0 {
+		return matching[:1]
+	}
+
+	return matching
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
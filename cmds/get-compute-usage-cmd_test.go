@@ -0,0 +1,46 @@
+package cmds
+
+import (
+	"github.com/d0rc/agent-os/stdlib/storage"
+	"testing"
+	"time"
+)
+
+func TestProcessGetComputeUsage(t *testing.T) {
+	ctx := newTestContext(t)
+
+	now := time.Now()
+	for _, record := range []*storage.ComputeUsageRecord{
+		{Process: "agent", Tags: "crawler,team-a", Endpoint: "node-1", Model: "mistral", Jobs: 10, PromptTokens: 1000, GPUSeconds: 5},
+		{Process: "agent", Tags: "team-b", Endpoint: "node-2", Model: "mistral", Jobs: 4, PromptTokens: 400, GPUSeconds: 2},
+		{Process: "embeddings", Endpoint: "node-2", Model: "bge", Jobs: 100, GPUSeconds: 1},
+	} {
+		record.PeriodStart, record.PeriodEnd = now.Add(-2*time.Minute), now.Add(-time.Minute)
+		if err := ctx.Storage.SaveComputeUsage(record); err != nil {
+			t.Fatalf("error saving compute usage: %v", err)
+		}
+	}
+
+	resp, err := ProcessGetComputeUsage([]GetComputeUsage{
+		{From: now.Add(-time.Hour).Unix()},
+		{From: now.Add(-time.Hour).Unix(), GroupBy: []string{UsageByTag}, Tag: "team-*"},
+		{From: now.Add(-time.Hour).Unix(), To: now.Add(-30 * time.Minute).Unix()},
+	}, ctx)
+	if err != nil {
+		t.Fatalf("error getting compute usage: %v", err)
+	}
+
+	byProcess := resp.GetComputeUsage[0].Usage
+	if len(byProcess) != 2 || byProcess[0].Process != "agent" || byProcess[0].Jobs != 14 || byProcess[0].GPUSeconds != 7 {
+		t.Fatalf("unexpected usage by process: %+v", byProcess)
+	}
+
+	byTag := resp.GetComputeUsage[1].Usage
+	if len(byTag) != 2 || byTag[0].Tag != "team-a" || byTag[0].PromptTokens != 1000 || byTag[1].Tag != "team-b" {
+		t.Fatalf("unexpected usage by tag: %+v", byTag)
+	}
+
+	if len(resp.GetComputeUsage[2].Usage) != 0 {
+		t.Fatalf("usage outside of the time window reported: %+v", resp.GetComputeUsage[2].Usage)
+	}
+}
@@ -1,8 +1,10 @@
 package cmds
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/stdlib/metrics"
 	"github.com/d0rc/agent-os/stdlib/storage"
 	be "github.com/d0rc/agent-os/syslib/borrow-engine"
 	"github.com/d0rc/agent-os/syslib/server"
@@ -10,7 +12,7 @@ import (
 	"time"
 )
 
-func ProcessGetEmbeddings(request []GetEmbeddingsRequest, ctx *server.Context, process string, priority be.JobPriority) (response *ServerResponse, err error) {
+func ProcessGetEmbeddings(reqCtx context.Context, request []GetEmbeddingsRequest, ctx *server.Context, process string, priority be.JobPriority) (response *ServerResponse, err error) {
 	// I've found no evidence that vLLM supports batching for real
 	// so, we can just launch parallel processing now
 	// later comment: and it's not the right place to make automatic batching...:)
@@ -19,11 +21,16 @@ func ProcessGetEmbeddings(request []GetEmbeddingsRequest, ctx *server.Context, p
 		results[idx] = make(chan *GetEmbeddingsResponse, 1)
 		go func(cr GetEmbeddingsRequest, ch chan *GetEmbeddingsResponse, idx int) {
 			//ts := time.Now()
-			embeddingsResponse, err := processGetEmbeddings(cr, ctx, process, priority)
+			embeddingsResponse, err := processGetEmbeddings(reqCtx, cr, ctx, process, priority)
 			//ctx.Log.Info().Msgf("done processing embeddings in %s", time.Since(ts))
 			if err != nil {
 				ctx.Log.Error().Err(err).
 					Msgf("Error processing embeddings request: ```%s```", cr.RawPrompt)
+				embeddingsResponse = &GetEmbeddingsResponse{
+					Model: cr.Model,
+					Text:  cr.RawPrompt,
+					Error: ToResponseError(err),
+				}
 			}
 
 			// ctx.Log.Info().Msgf("Got embeddings for prompt %d", idx)
@@ -50,7 +57,7 @@ type EmbeddingsCacheRecord struct {
 	Embedding   []byte `db:"embedding"`
 }
 
-func processGetEmbeddings(cr GetEmbeddingsRequest, ctx *server.Context, process string, priority be.JobPriority) (*GetEmbeddingsResponse, error) {
+func processGetEmbeddings(reqCtx context.Context, cr GetEmbeddingsRequest, ctx *server.Context, process string, priority be.JobPriority) (*GetEmbeddingsResponse, error) {
 	cachedResponse := make([]EmbeddingsCacheRecord, 0, 1)
 	textHash := storage.GetHash(cr.RawPrompt)
 	retryCounter := 0
@@ -83,6 +90,7 @@ retryLoop:
 			response.TextHash = textHash
 			response.Text = cr.RawPrompt
 			response.Model = cr.Model
+			metrics.Tick("cache.embeddings.hits", 1)
 			/*_, err := ctx.Storage.Db.Exec("make-embeddings-cache-hit", cachedResponse[0].Id)
 			if err != nil {
 				ctx.Log.Error().Err(err).
@@ -96,14 +104,24 @@ retryLoop:
 
 	// once we're here, there were no embeddings in the cache
 	// let's try to generate them
-	computeResult := SendComputeRequest(ctx,
+	metrics.Tick("cache.embeddings.misses", 1)
+	computeResult := SendComputeRequest(reqCtx,
+		ctx,
 		process,
 		be.JT_Embeddings,
 		priority,
+		cr.Model,
 		&engines.GenerationSettings{
 			RawPrompt: cr.RawPrompt,
 		})
-	embeddings := <-computeResult.EmbeddingChannel
+	var embeddings *vectors.Vector
+	select {
+	case embeddings = <-computeResult.EmbeddingChannel:
+	case err := <-computeResult.ErrorChannel:
+		return nil, err
+	case <-reqCtx.Done():
+		return nil, reqCtx.Err()
+	}
 	// ctx.Log.Info().Msgf("Got embeddings for prompt %d", len(cr.RawPrompt))
 
 	// and now, need to save the result into the cache
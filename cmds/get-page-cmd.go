@@ -4,6 +4,7 @@ import (
 	"fmt"
 	md "github.com/JohannesKaufmann/html-to-markdown"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/d0rc/agent-os/stdlib/metrics"
 	"github.com/d0rc/agent-os/syslib/server"
 	"io"
 	"net/http"
@@ -31,6 +32,11 @@ func ProcessPageRequests(request []GetPageRequest, ctx *server.Context) (*Server
 				// need to return error to the one asking...
 				ctx.Log.Error().Err(err).
 					Msgf("Error processing page request: %s", pr.Url)
+				pageResponse = &GetPageResponse{
+					Url:              pr.Url,
+					OriginalQuestion: pr.Question,
+					Error:            ToResponseError(err),
+				}
 			}
 			ch <- pageResponse
 		}(pr, results[idx])
@@ -72,6 +78,7 @@ func processPageRequest(pr GetPageRequest, ctx *server.Context) (*GetPageRespons
 
 		if time.Since(cachedPage[0].CreatedAt).Seconds() < float64(pr.MaxAge) {
 			// it's a cache hit, let's mark it and exit
+			metrics.Tick("cache.page.hits", 1)
 			_, err = ctx.Storage.Db.Exec("make-page-cache-hit", cachedPage[0].Id)
 			if err != nil {
 				ctx.Log.Error().Err(err).Msgf("error marking page cache hit: %v", cachedPage[0].Id)
@@ -86,6 +93,7 @@ func processPageRequest(pr GetPageRequest, ctx *server.Context) (*GetPageRespons
 
 	// if we've got here, page in cache either not exists
 	// or too old, so let's fetch a new one
+	metrics.Tick("cache.page.misses", 1)
 	if pr.MaxRetries == 0 {
 		pr.MaxRetries = 10
 	}
@@ -103,13 +111,17 @@ func processPageRequest(pr GetPageRequest, ctx *server.Context) (*GetPageRespons
 			break
 		}
 
+		if responseError := ToResponseError(err); !responseError.Retryable {
+			return nil, responseError
+		}
+
 		time.Sleep(1 * time.Second)
 	}
 
 	if pageCacheRecord == nil {
 		ctx.Log.Error().Err(err).
 			Msgf("[MAX-ATTEMPT-REACHED] error loading page from url: %s", pr.Url)
-		return nil, fmt.Errorf("error loading page from url: %s", pr.Url)
+		return nil, fmt.Errorf("error loading page from url: %s: %w", pr.Url, err)
 	}
 
 	// saving cache record to database
@@ -144,6 +156,7 @@ func translateCacheRecordToClientResponse(cachedPage *PageCacheRecord, ctx *serv
 		PageAge:          int(time.Since(cachedPage.CreatedAt).Seconds()),
 		Url:              cachedPage.Url,
 		OriginalQuestion: question,
+		Error:            httpStatusError("page", int(cachedPage.StatusCode)),
 	}
 	return pageResponse
 }
@@ -152,18 +165,8 @@ func downloadPage(pr GetPageRequest, ctx *server.Context) (*PageCacheRecord, err
 	// somewhere here, we need to make sure we're the only
 	// process which downloads the URL in the way requested
 	// and if there's someone doing the same, just wait for his result
-	client := http.Client{Timeout: time.Duration(pr.TimeOut) * time.Second}
-
-	escapedUrl := url.QueryEscape(pr.Url)
-	finalUrl := fmt.Sprintf("https://api.crawlbase.com/?token=%s&url=", ctx.Config.Tools.ProxyCrawl.Token) + escapedUrl
-
 	ts := time.Now()
-	result, err := client.Get(finalUrl)
-	if err != nil {
-		return nil, err
-	}
-	defer result.Body.Close()
-	body, err := io.ReadAll(result.Body)
+	statusCode, body, err := pageProvider(ctx).GetPage(pr.Url, time.Duration(pr.TimeOut)*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -176,10 +179,48 @@ func downloadPage(pr GetPageRequest, ctx *server.Context) (*PageCacheRecord, err
 		RawContent: body,
 		CreatedAt:  time.Now(),
 		CacheHits:  0,
-		StatusCode: uint(result.StatusCode),
+		StatusCode: uint(statusCode),
 	}, nil
 }
 
+func pageProvider(ctx *server.Context) server.PageProvider {
+	if ctx.Pages != nil {
+		return ctx.Pages
+	}
+
+	return &crawlbasePages{token: ctx.Config.Tools.ProxyCrawl.Token}
+}
+
+// crawlbasePages - downloads pages through crawlbase
+type crawlbasePages struct {
+	token string
+}
+
+func (p *crawlbasePages) GetPage(pageUrl string, timeout time.Duration) (int, []byte, error) {
+	client := http.Client{Timeout: timeout}
+
+	escapedUrl := url.QueryEscape(pageUrl)
+	finalUrl := fmt.Sprintf("https://api.crawlbase.com/?token=%s&url=", p.token) + escapedUrl
+
+	result, err := client.Get(finalUrl)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer result.Body.Close()
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	switch result.StatusCode {
+	case http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusPaymentRequired:
+		// crawler's own limits, not the page status - must not be cached
+		return 0, nil, httpStatusError("crawlbase", result.StatusCode)
+	}
+
+	return result.StatusCode, body, nil
+}
+
 func noLongerThen(u string, i int) string {
 	if len(u) > i {
 		return u[:i] + "..."
@@ -1,22 +1,28 @@
 package cmds
 
 import (
+	"github.com/d0rc/agent-os/syslib/server"
 	zlog "github.com/rs/zerolog/log"
+	"strings"
 	"testing"
 )
 
 func TestGetPageCmd(t *testing.T) {
 	lg := zlog.Logger
-	storage, err := NewStorage(lg)
-	if err != nil {
-		lg.Fatal().Err(err).Msg("init storage failed")
-	}
+	ctx := newTestContext(t)
+	ctx.Pages = &server.StaticPages{Pages: map[string]string{
+		"https://github.com/fschmid56/efficientat": "<html><body><h1>EfficientAT</h1></body></html>",
+	}}
 
 	resp, err := ProcessPageRequests([]GetPageRequest{
 		{
-			Url: "https://github.com/fschmid56/efficientat",
+			Url:        "https://github.com/fschmid56/efficientat",
+			MaxRetries: 1,
 		},
-	}, storage)
+	}, ctx)
 
 	lg.Info().Err(err).Interface("resp", resp).Msg("get page cmd")
+	if err != nil || len(resp.GetPageResponse) != 1 || !strings.Contains(resp.GetPageResponse[0].Markdown, "EfficientAT") {
+		t.Fatalf("unexpected page response: %+v, %v", resp, err)
+	}
 }
@@ -2,10 +2,14 @@ package cmds
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/d0rc/agent-os/stdlib/metrics"
 	"github.com/d0rc/agent-os/syslib/batcher"
 	"github.com/d0rc/agent-os/syslib/server"
 	g "github.com/serpapi/google-search-results-golang"
+	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,6 +23,10 @@ func ProcessGoogleSearches(request []GoogleSearchRequest, ctx *server.Context) (
 			if err != nil {
 				ctx.Log.Error().Err(err).
 					Msgf("Error executing google search request: %v", gsr)
+				searchResponse = &GoogleSearchResponse{
+					DownloadedAt: -1,
+					Error:        ToResponseError(err),
+				}
 			}
 
 			ch <- searchResponse
@@ -62,6 +70,7 @@ func processGoogleSearch(gsr *GoogleSearchRequest, ctx *server.Context) (*Google
 				Msgf("falling back to new search - error parsing cache data for keywords: %s", gsr.Keywords)
 		} else {
 			// mark cache hit...!
+			metrics.Tick("cache.search.hits", 1)
 			searchesBatcher := batcher.NewBatcher("search-cache-hits-batcher", func(ids []int64) error {
 				//_, err := ctx.Storage.Db.Exec("make-search-cache-hits", ids)
 				//return err
@@ -81,6 +90,7 @@ func processGoogleSearch(gsr *GoogleSearchRequest, ctx *server.Context) (*Google
 		}
 	}
 
+	metrics.Tick("cache.search.misses", 1)
 	mapResultsChannel := make(chan *GoogleSearchResponse)
 	currentSearchesLock.Lock()
 	if _, exists := currentSearches[gsr.Keywords]; exists {
@@ -88,8 +98,8 @@ func processGoogleSearch(gsr *GoogleSearchRequest, ctx *server.Context) (*Google
 		currentSearchesLock.Unlock()
 
 		someResult := <-mapResultsChannel
-		if someResult.URLSearchInfos == nil {
-			return nil, fmt.Errorf("error running Google search for keywords: %s", gsr.Keywords)
+		if someResult.Error != nil {
+			return nil, someResult.Error
 		}
 		return someResult, nil
 	}
@@ -100,7 +110,7 @@ func processGoogleSearch(gsr *GoogleSearchRequest, ctx *server.Context) (*Google
 		gsr.MaxRetries = 10
 	}
 
-	result := &GoogleSearchCacheRecord{}
+	var result *GoogleSearchCacheRecord
 
 	for retryCounter := 0; retryCounter < gsr.MaxRetries; retryCounter++ {
 		result, err = executeSearch(gsr, ctx)
@@ -108,12 +118,17 @@ func processGoogleSearch(gsr *GoogleSearchRequest, ctx *server.Context) (*Google
 			break
 		}
 
+		if responseError := ToResponseError(err); !responseError.Retryable {
+			break
+		}
+
 		time.Sleep(time.Duration(1000) * time.Millisecond)
 	}
 
 	if result == nil {
 		ctx.Log.Error().Err(err).
 			Msgf("[MAX-ATTEMPT-REACHED] error running google search for keywords: %s", gsr.Keywords)
+		responseError := ToResponseError(err)
 		// also send error to all channels
 		currentSearchesLock.Lock()
 		for _, ch := range currentSearches[gsr.Keywords] {
@@ -122,12 +137,13 @@ func processGoogleSearch(gsr *GoogleSearchRequest, ctx *server.Context) (*Google
 				AnswerBox:      "",
 				DownloadedAt:   -1,
 				SearchAge:      0,
+				Error:          responseError,
 			}
 		}
 		// now delete all these searches
 		delete(currentSearches, gsr.Keywords)
 		currentSearchesLock.Unlock()
-		return nil, fmt.Errorf("error running Google search for keywords: %s", gsr.Keywords)
+		return nil, fmt.Errorf("error running Google search for keywords: %s: %w", gsr.Keywords, responseError)
 	}
 
 	// now save results to cache and return
@@ -168,22 +184,70 @@ func processGoogleSearch(gsr *GoogleSearchRequest, ctx *server.Context) (*Google
 }
 
 func executeSearch(gsr *GoogleSearchRequest, ctx *server.Context) (result *GoogleSearchCacheRecord, err error) {
+	searchResults, answerBoxText, err := searchProvider(ctx).Search(gsr.Keywords, gsr.Lang, gsr.Country, gsr.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	organicUrls := make([]*URLSearchInfo, 0, len(searchResults))
+	for _, searchResult := range searchResults {
+		organicUrls = append(organicUrls, &URLSearchInfo{
+			URL:     searchResult.URL,
+			Title:   searchResult.Title,
+			Snippet: searchResult.Snippet,
+		})
+	}
+
+	rawContent, err := generateRawSearchContentJson(organicUrls, answerBoxText)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdSearchResults := &GoogleSearchCacheRecord{
+		Keywords:   gsr.Keywords,
+		Lang:       gsr.Lang,
+		Country:    gsr.Country,
+		Location:   gsr.Location,
+		RawContent: rawContent,
+		CreatedAt:  time.Now(),
+	}
+
+	return cmdSearchResults, nil
+}
+
+func searchProvider(ctx *server.Context) server.SearchProvider {
+	if ctx.Search != nil {
+		return ctx.Search
+	}
+
+	return &serpApiSearch{token: ctx.Config.Tools.SerpApi.Token}
+}
+
+// serpApiSearch - runs searches with serpapi
+type serpApiSearch struct {
+	token string
+}
+
+func (s *serpApiSearch) Search(keywords, lang, country, location string) ([]server.SearchResult, string, error) {
 	parameter := map[string]string{
-		"q":             gsr.Keywords,
-		"location":      gsr.Location,
-		"hl":            gsr.Lang,
-		"gl":            gsr.Country,
+		"q":             keywords,
+		"location":      location,
+		"hl":            lang,
+		"gl":            country,
 		"google_domain": "google.com",
 		"start":         "0",
 		"num":           "100",
-		"api_key":       ctx.Config.Tools.SerpApi.Token,
+		"api_key":       s.token,
 	}
 
-	organicUrls := make([]*URLSearchInfo, 0)
+	organicUrls := make([]server.SearchResult, 0)
 	answerBoxText := ""
 
-	search := g.NewGoogleSearch(parameter, ctx.Config.Tools.SerpApi.Token)
+	search := g.NewGoogleSearch(parameter, s.token)
 	searchResults, err := search.GetJSON()
+	if err != nil && !isEmptySearchError(err) {
+		return nil, "", classifySearchError(err)
+	}
 
 	if searchResults["organic_results"] != nil {
 		organicResults := searchResults["organic_results"].([]interface{})
@@ -196,7 +260,7 @@ func executeSearch(gsr *GoogleSearchRequest, ctx *server.Context) (result *Googl
 				continue
 			}
 
-			organicUrls = append(organicUrls, &URLSearchInfo{
+			organicUrls = append(organicUrls, server.SearchResult{
 				URL:     organicResultsUrl.(map[string]interface{})["link"].(string),
 				Title:   organicResultsUrl.(map[string]interface{})["title"].(string),
 				Snippet: organicResultsUrl.(map[string]interface{})["snippet"].(string),
@@ -212,21 +276,31 @@ func executeSearch(gsr *GoogleSearchRequest, ctx *server.Context) (result *Googl
 		}
 	}
 
-	rawContent, err := generateRawSearchContentJson(organicUrls, answerBoxText)
-	if err != nil {
-		return nil, err
-	}
+	return organicUrls, answerBoxText, nil
+}
 
-	cmdSearchResults := &GoogleSearchCacheRecord{
-		Keywords:   gsr.Keywords,
-		Lang:       gsr.Lang,
-		Country:    gsr.Country,
-		Location:   gsr.Location,
-		RawContent: rawContent,
-		CreatedAt:  time.Now(),
+// isEmptySearchError - serpapi reports searches with no results as an error
+func isEmptySearchError(err error) bool {
+	return strings.Contains(err.Error(), "hasn't returned any results")
+}
+
+func classifySearchError(err error) error {
+	var netError net.Error
+	if errors.As(err, &netError) {
+		return err
 	}
 
-	return cmdSearchResults, nil
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "run out of searches"):
+		return NewResponseError(ErrCodeQuotaExceeded, false, "serpapi: %v", err)
+	case strings.Contains(message, "limit"):
+		return NewResponseError(ErrCodeQuotaExceeded, true, "serpapi: %v", err)
+	case strings.Contains(message, "api key"):
+		return NewResponseError(ErrCodeUpstream, false, "serpapi: %v", err)
+	default:
+		return NewResponseError(ErrCodeUpstream, true, "serpapi: %v", err)
+	}
 }
 
 type searchResultsJson struct {
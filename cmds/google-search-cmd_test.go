@@ -1,15 +1,16 @@
 package cmds
 
 import (
+	"github.com/d0rc/agent-os/syslib/server"
 	zlog "github.com/rs/zerolog/log"
 	"testing"
 )
 
 func TestProcessGoogleSearches(t *testing.T) {
-	storage, err := NewStorage(zlog.Logger)
-	if err != nil {
-		zlog.Fatal().Err(err).Msg("init storage failed")
-	}
+	ctx := newTestContext(t)
+	ctx.Search = &server.StaticSearch{Results: map[string][]server.SearchResult{
+		"best restaurants": {{URL: "https://example.com/milan", Title: "Milan", Snippet: "Restaurants of Milan"}},
+	}}
 
 	resp, err := ProcessGoogleSearches([]GoogleSearchRequest{
 		{
@@ -18,9 +19,12 @@ func TestProcessGoogleSearches(t *testing.T) {
 			Country:    "it",
 			Location:   "Milan, Italy",
 			MaxAge:     0,
-			MaxRetries: 10,
+			MaxRetries: 1,
 		},
-	}, storage)
+	}, ctx)
 
 	zlog.Info().Err(err).Interface("resp", resp).Msg("process google searches")
+	if err != nil || len(resp.GoogleSearchResponse) != 1 || len(resp.GoogleSearchResponse[0].URLSearchInfos) != 1 {
+		t.Fatalf("unexpected search response: %+v, %v", resp, err)
+	}
 }
@@ -0,0 +1,129 @@
+package cmds
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/syslib/borrow-engine"
+	"sort"
+	"time"
+)
+
+// maxGenerationSettingsLength - size of llm_cache.generation_settings column, longer settings are hashed
+const maxGenerationSettingsLength = 1024
+
+// llmCacheKey - hash of everything, which changes generated texts, except for the model; model is known
+// only once compute router picks the node, so records are matched against the model mask of the request;
+// number of choices and log probabilities don't change the texts, so they are not a part of the key;
+// generation settings are returned as they're stored next to the key
+func llmCacheKey(cr *GetCompletionRequest) (string, string, error) {
+	stopTokens := append([]string{}, cr.StopTokens...)
+	sort.Strings(stopTokens)
+	settings := struct {
+		Temperature      float32         `json:"temperature"`
+		StopTokens       []string        `json:"stop-tokens,omitempty"`
+		BestOf           int             `json:"best-of,omitempty"`
+		MaxTokens        int             `json:"max-tokens,omitempty"`
+		TopP             float32         `json:"top-p,omitempty"`
+		TopK             int             `json:"top-k,omitempty"`
+		PresencePenalty  float32         `json:"presence-penalty,omitempty"`
+		FrequencyPenalty float32         `json:"frequency-penalty,omitempty"`
+		Seed             *int            `json:"seed,omitempty"`
+		JSONSchema       json.RawMessage `json:"json-schema,omitempty"`
+		Grammar          string          `json:"grammar,omitempty"`
+	}{
+		Temperature:      cr.Temperature,
+		StopTokens:       stopTokens,
+		BestOf:           cr.BestOf,
+		MaxTokens:        cr.MaxTokens,
+		TopP:             cr.TopP,
+		TopK:             cr.TopK,
+		PresencePenalty:  cr.PresencePenalty,
+		FrequencyPenalty: cr.FrequencyPenalty,
+		Seed:             cr.Seed,
+		JSONSchema:       cr.JSONSchema,
+		Grammar:          cr.Grammar,
+	}
+	if settings.BestOf == 1 {
+		// engines default to 1
+		settings.BestOf = 0
+	}
+
+	// json schema is compacted, so formatting of the schema doesn't matter
+	serializedSettings, err := json.Marshal(settings)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid generation settings: %w", err)
+	}
+
+	type keyMessage struct {
+		Role    engines.ChatRole `json:"role"`
+		Content string           `json:"content"`
+	}
+	key := struct {
+		Messages  []keyMessage    `json:"messages,omitempty"`
+		RawPrompt string          `json:"raw-prompt,omitempty"`
+		Settings  json.RawMessage `json:"settings"`
+	}{
+		Settings: serializedSettings,
+	}
+	if len(cr.Messages) > 0 {
+		// engines use messages, raw prompt of chat requests is ignored
+		for _, msg := range cr.Messages {
+			key.Messages = append(key.Messages, keyMessage{Role: msg.Role, Content: msg.Content})
+		}
+	} else {
+		key.RawPrompt = cr.RawPrompt
+	}
+
+	serializedKey, err := json.Marshal(key)
+	if err != nil {
+		return "", "", fmt.Errorf("error serializing llm cache key: %w", err)
+	}
+
+	storedSettings := string(serializedSettings)
+	if len(storedSettings) > maxGenerationSettingsLength {
+		storedSettings = fmt.Sprintf("sha256:%x", sha256.Sum256(serializedSettings))
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(serializedKey)), storedSettings, nil
+}
+
+func validateCachePolicy(cr *GetCompletionRequest) error {
+	switch cr.CachePolicy {
+	case "", CachePolicyReadThrough, CachePolicyWriteOnly, CachePolicyBypass:
+		return nil
+	case CachePolicyFresherThan:
+		if cr.MaxAge <= 0 {
+			return fmt.Errorf("cache policy %s needs positive max-age", cr.CachePolicy)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown cache policy: %s", cr.CachePolicy)
+	}
+}
+
+// readsCache - cache keeps generated texts only, so requests for log probabilities don't read it
+func readsCache(cr *GetCompletionRequest) bool {
+	return cr.LogProbs == 0 && cr.CachePolicy != CachePolicyWriteOnly && cr.CachePolicy != CachePolicyBypass
+}
+
+func writesCache(cr *GetCompletionRequest) bool {
+	return cr.CachePolicy != CachePolicyBypass
+}
+
+// usableCacheRecords - records of the models matching the request, fresh enough for its cache policy
+func usableCacheRecords(cr *GetCompletionRequest, records []CompletionCacheRecord, now time.Time) []CompletionCacheRecord {
+	usable := make([]CompletionCacheRecord, 0, len(records))
+	for _, record := range records {
+		if !borrow_engine.MatchModelMask(cr.Model, record.Model) {
+			continue
+		}
+		if cr.CachePolicy == CachePolicyFresherThan && now.Sub(record.CreatedAt) >= time.Duration(cr.MaxAge)*time.Second {
+			continue
+		}
+		usable = append(usable, record)
+	}
+
+	return usable
+}
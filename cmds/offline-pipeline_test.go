@@ -0,0 +1,69 @@
+package cmds
+
+import (
+	"context"
+	"github.com/d0rc/agent-os/engines"
+	borrow_engine "github.com/d0rc/agent-os/syslib/borrow-engine"
+	"github.com/d0rc/agent-os/syslib/server"
+	"testing"
+)
+
+// TestOfflinePipeline - search, page download, completion and embeddings run with mock engine
+// and static tools, the way agents use them
+func TestOfflinePipeline(t *testing.T) {
+	mock := &engines.MockEngine{Responses: []*engines.MockResponse{
+		{Pattern: `(?s)Summarize:.*Best pasta`, Content: "Pasta is served. ### ignored"},
+		{Pattern: `flaky`, Error: "engine overloaded", Times: 1},
+		{Pattern: `flaky`, Content: "recovered"},
+	}}
+	ctx := newMockTestContext(t, mock)
+	ctx.Search = &server.StaticSearch{Results: map[string][]server.SearchResult{
+		"pasta milan": {{URL: "https://example.com/pasta", Title: "Pasta", Snippet: "Pasta in Milan"}},
+	}}
+	ctx.Pages = &server.StaticPages{Pages: map[string]string{
+		"https://example.com/pasta": "<html><body><h1>Pasta</h1><p>Best pasta in Milan</p></body></html>",
+	}}
+
+	search, err := ProcessGoogleSearches([]GoogleSearchRequest{{Keywords: "pasta milan", MaxRetries: 1}}, ctx)
+	if err != nil || len(search.GoogleSearchResponse[0].URLSearchInfos) != 1 {
+		t.Fatalf("unexpected search response: %+v, %v", search, err)
+	}
+	page, err := ProcessPageRequests([]GetPageRequest{{Url: search.GoogleSearchResponse[0].URLSearchInfos[0].URL, MaxRetries: 1}}, ctx)
+	if err != nil || page.GetPageResponse[0].Error != nil {
+		t.Fatalf("unexpected page response: %+v, %v", page, err)
+	}
+
+	summarize := GetCompletionRequest{
+		RawPrompt:  "Summarize:\n" + page.GetPageResponse[0].Markdown,
+		StopTokens: []string{"###"},
+		MinResults: 1,
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		// the second request is served by the llm cache
+		completion, err := ProcessGetCompletions([]GetCompletionRequest{summarize}, ctx, "test", borrow_engine.PRIO_User)
+		if err != nil || len(completion.GetCompletionResponse[0].Choices) != 1 ||
+			completion.GetCompletionResponse[0].Choices[0] != "Pasta is served. " {
+			t.Fatalf("unexpected completion: %+v, %v", completion.GetCompletionResponse[0], err)
+		}
+	}
+	if len(mock.Prompts()) != 1 {
+		t.Fatalf("expected cached completion not to reach the engine, got prompts %v", mock.Prompts())
+	}
+
+	// failed job is retried by the compute router
+	completion, err := ProcessGetCompletions([]GetCompletionRequest{{RawPrompt: "flaky", MinResults: 1}}, ctx, "test", borrow_engine.PRIO_User)
+	if err != nil || len(completion.GetCompletionResponse[0].Choices) != 1 || completion.GetCompletionResponse[0].Choices[0] != "recovered" {
+		t.Fatalf("unexpected completion of retried job: %+v, %v", completion.GetCompletionResponse[0], err)
+	}
+
+	embeddings, err := ProcessGetEmbeddings(context.Background(), []GetEmbeddingsRequest{
+		{RawPrompt: "pasta"}, {RawPrompt: "pasta"}, {RawPrompt: "pizza"},
+	}, ctx, "test", borrow_engine.PRIO_User)
+	if err != nil || len(embeddings.GetEmbeddingsResponse) != 3 {
+		t.Fatalf("unexpected embeddings: %+v, %v", embeddings, err)
+	}
+	pasta, again, pizza := embeddings.GetEmbeddingsResponse[0], embeddings.GetEmbeddingsResponse[1], embeddings.GetEmbeddingsResponse[2]
+	if len(pasta.Embeddings) != engines.DefaultMockEmbeddingsDims || pasta.Embeddings[0] != again.Embeddings[0] || pasta.Embeddings[0] == pizza.Embeddings[0] {
+		t.Fatalf("expected deterministic embeddings of %d dimensions", engines.DefaultMockEmbeddingsDims)
+	}
+}
@@ -0,0 +1,438 @@
+package cmds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/syslib/borrow-engine"
+	"github.com/d0rc/agent-os/syslib/server"
+	"github.com/google/uuid"
+	"time"
+)
+
+// OpenAI compatible end-points, these are thin wrappers around
+// ProcessGetCompletions and ProcessGetEmbeddings, so off-the-shelf
+// SDKs get llm cache, batching and priority queue for free
+
+const openAIProcessName = "openai-api"
+
+type OpenAICompletionRequest struct {
+	Model            string   `json:"model"`
+	Prompt           string   `json:"prompt"`
+	MaxTokens        int      `json:"max_tokens,omitempty"`
+	Temperature      float32  `json:"temperature,omitempty"`
+	TopP             float32  `json:"top_p,omitempty"`
+	N                int      `json:"n,omitempty"`
+	Stream           bool     `json:"stream,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	LogProbs         int      `json:"logprobs,omitempty"`
+	BestOf           int      `json:"best_of,omitempty"`
+	User             string   `json:"user,omitempty"`
+}
+
+type OpenAICompletionChoice struct {
+	Text         string                      `json:"text"`
+	Index        int                         `json:"index"`
+	LogProbs     *engines.CompletionLogProbs `json:"logprobs,omitempty"`
+	FinishReason string                      `json:"finish_reason"`
+}
+
+type OpenAICompletionResponse struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []OpenAICompletionChoice `json:"choices"`
+	Usage   engines.Usage            `json:"usage"`
+}
+
+type OpenAIEmbeddingsRequest struct {
+	Model string      `json:"model"`
+	Input interface{} `json:"input"` // string or []string
+	User  string      `json:"user,omitempty"`
+}
+
+type OpenAIEmbedding struct {
+	Object    string    `json:"object"`
+	Embedding []float64 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
+type OpenAIEmbeddingsResponse struct {
+	Object string            `json:"object"`
+	Data   []OpenAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  engines.Usage     `json:"usage"`
+}
+
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type OpenAIModelsList struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+type OpenAIError struct {
+	Error struct {
+		Message   string    `json:"message"`
+		Type      string    `json:"type"`
+		Code      ErrorCode `json:"code,omitempty"`
+		Retryable bool      `json:"retryable,omitempty"`
+	} `json:"error"`
+}
+
+// NewOpenAIError - code and retryability of ResponseError are passed to the client
+func NewOpenAIError(errType string, err error) *OpenAIError {
+	result := &OpenAIError{}
+	result.Error.Message = err.Error()
+	result.Error.Type = errType
+
+	var responseError *ResponseError
+	if errors.As(err, &responseError) {
+		result.Error.Message = responseError.Message
+		result.Error.Code = responseError.Code
+		result.Error.Retryable = responseError.Retryable
+	}
+
+	return result
+}
+
+// toOpenAIUsage - choices returned from llm cache took no tokens
+func toOpenAIUsage(usage *CompletionUsage) engines.Usage {
+	if usage == nil {
+		return engines.Usage{}
+	}
+
+	return engines.Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.GeneratedTokens,
+		TotalTokens:      usage.PromptTokens + usage.GeneratedTokens,
+	}
+}
+
+func openAIProcessNameFor(user string) string {
+	if user == "" {
+		return openAIProcessName
+	}
+
+	return fmt.Sprintf("%s[%s]", openAIProcessName, user)
+}
+
+// openAIJSONSchema - json_object response format is a schema, which any object matches
+func openAIJSONSchema(format *engines.ChatCompletionResponseFormat) json.RawMessage {
+	if format == nil {
+		return nil
+	}
+
+	switch format.Type {
+	case engines.ChatCompletionResponseFormatTypeJSONObject:
+		return json.RawMessage(`{"type":"object"}`)
+	case engines.ChatCompletionResponseFormatTypeJSONSchema:
+		if format.JSONSchema != nil {
+			return format.JSONSchema.Schema
+		}
+	}
+
+	return nil
+}
+
+// choiceLogProbs - log probabilities of the choice, if they were requested
+func choiceLogProbs(response *GetCompletionResponse, idx int) []engines.LogProb {
+	if idx >= len(response.LogProbs) {
+		return nil
+	}
+
+	return response.LogProbs[idx]
+}
+
+// ProcessOpenAIChatCompletion - if onChunk is not nil, chat.completion.chunk
+// objects are sent to it as text is generated
+func ProcessOpenAIChatCompletion(reqCtx context.Context, request *engines.ChatCompletionRequest, ctx *server.Context, onChunk func(chunk interface{})) (*engines.ChatCompletionResponse, error) {
+	if len(request.Messages) == 0 {
+		return nil, fmt.Errorf("messages are empty")
+	}
+
+	messages := make([]*engines.Message, len(request.Messages))
+	for idx, msg := range request.Messages {
+		messages[idx] = &engines.Message{
+			Role:    engines.ChatRole(msg.Role),
+			Content: msg.Content,
+		}
+	}
+
+	n := max(request.N, 1)
+	logProbs := 0
+	if request.LogProbs {
+		// sampled token is returned along with the most likely ones
+		logProbs = max(request.TopLogProbs, 1)
+	}
+	id := fmt.Sprintf("chatcmpl-%s", uuid.New().String())
+	created := time.Now().Unix()
+	var onStreamChunk func(chunk *StreamChunk)
+	if onChunk != nil {
+		onStreamChunk = func(chunk *StreamChunk) {
+			if chunk.Choice >= n {
+				return
+			}
+			onChunk(&engines.ChatCompletionStreamResponse{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   request.Model,
+				Choices: []engines.ChatCompletionStreamChoice{
+					{
+						Index: chunk.Choice,
+						Delta: engines.ChatCompletionStreamChoiceDelta{
+							Role:    engines.ChatMessageRoleAssistant,
+							Content: chunk.Delta,
+						},
+					},
+				},
+			})
+		}
+	}
+
+	process := openAIProcessNameFor(request.User)
+	ctx.ComputeRouter.AccountProcessRequest(process)
+	resp, err := ProcessGetCompletionsStream(reqCtx, []GetCompletionRequest{
+		{
+			Model: request.Model,
+			// raw prompt is only used as llm cache key here,
+			// inference engines will use messages
+			RawPrompt:        chatToRawPrompt(messages),
+			Temperature:      request.Temperature,
+			StopTokens:       request.Stop,
+			MinResults:       n,
+			Messages:         messages,
+			MaxTokens:        request.MaxTokens,
+			TopP:             request.TopP,
+			PresencePenalty:  request.PresencePenalty,
+			FrequencyPenalty: request.FrequencyPenalty,
+			Seed:             request.Seed,
+			LogProbs:         logProbs,
+			N:                n,
+			JSONSchema:       openAIJSONSchema(request.ResponseFormat),
+		},
+	}, ctx, process, borrow_engine.PRIO_User, onStreamChunk)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.GetCompletionResponse) == 0 || resp.GetCompletionResponse[0] == nil {
+		return nil, fmt.Errorf("no completion generated")
+	}
+
+	choices := resp.GetCompletionResponse[0].Choices
+	result := &engines.ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   request.Model,
+		Choices: make([]engines.ChatCompletionChoice, 0, n),
+		Usage:   toOpenAIUsage(resp.GetCompletionResponse[0].Usage),
+	}
+	for idx, choice := range choices {
+		if idx >= n {
+			break
+		}
+		chatChoice := engines.ChatCompletionChoice{
+			Index: idx,
+			Message: engines.ChatCompletionMessage{
+				Role:    engines.ChatMessageRoleAssistant,
+				Content: choice,
+			},
+			FinishReason: engines.FinishReasonStop,
+		}
+		if logProbs := choiceLogProbs(resp.GetCompletionResponse[0], idx); logProbs != nil {
+			chatChoice.LogProbs = &engines.LogProbs{Content: logProbs}
+		}
+		result.Choices = append(result.Choices, chatChoice)
+	}
+
+	return result, nil
+}
+
+// ProcessOpenAICompletion - if onChunk is not nil, partial text_completion
+// objects are sent to it as text is generated
+func ProcessOpenAICompletion(reqCtx context.Context, request *OpenAICompletionRequest, ctx *server.Context, onChunk func(chunk interface{})) (*OpenAICompletionResponse, error) {
+	if request.Prompt == "" {
+		return nil, fmt.Errorf("prompt is empty")
+	}
+
+	n := max(request.N, 1)
+	id := fmt.Sprintf("cmpl-%s", uuid.New().String())
+	created := time.Now().Unix()
+	var onStreamChunk func(chunk *StreamChunk)
+	if onChunk != nil {
+		onStreamChunk = func(chunk *StreamChunk) {
+			if chunk.Choice >= n {
+				return
+			}
+			onChunk(&OpenAICompletionResponse{
+				ID:      id,
+				Object:  "text_completion",
+				Created: created,
+				Model:   request.Model,
+				Choices: []OpenAICompletionChoice{
+					{
+						Text:  chunk.Delta,
+						Index: chunk.Choice,
+					},
+				},
+			})
+		}
+	}
+
+	process := openAIProcessNameFor(request.User)
+	ctx.ComputeRouter.AccountProcessRequest(process)
+	resp, err := ProcessGetCompletionsStream(reqCtx, []GetCompletionRequest{
+		{
+			Model:            request.Model,
+			RawPrompt:        request.Prompt,
+			Temperature:      request.Temperature,
+			StopTokens:       request.Stop,
+			MinResults:       n,
+			BestOf:           request.BestOf,
+			MaxTokens:        request.MaxTokens,
+			TopP:             request.TopP,
+			PresencePenalty:  request.PresencePenalty,
+			FrequencyPenalty: request.FrequencyPenalty,
+			Seed:             request.Seed,
+			LogProbs:         request.LogProbs,
+			N:                n,
+		},
+	}, ctx, process, borrow_engine.PRIO_User, onStreamChunk)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.GetCompletionResponse) == 0 || resp.GetCompletionResponse[0] == nil {
+		return nil, fmt.Errorf("no completion generated")
+	}
+
+	choices := resp.GetCompletionResponse[0].Choices
+	result := &OpenAICompletionResponse{
+		ID:      id,
+		Object:  "text_completion",
+		Created: created,
+		Model:   request.Model,
+		Choices: make([]OpenAICompletionChoice, 0, n),
+		Usage:   toOpenAIUsage(resp.GetCompletionResponse[0].Usage),
+	}
+	for idx, choice := range choices {
+		if idx >= n {
+			break
+		}
+		result.Choices = append(result.Choices, OpenAICompletionChoice{
+			Text:         choice,
+			Index:        idx,
+			LogProbs:     engines.ToCompletionLogProbs(choiceLogProbs(resp.GetCompletionResponse[0], idx)),
+			FinishReason: string(engines.FinishReasonStop),
+		})
+	}
+
+	return result, nil
+}
+
+func ProcessOpenAIEmbeddings(reqCtx context.Context, request *OpenAIEmbeddingsRequest, ctx *server.Context) (*OpenAIEmbeddingsResponse, error) {
+	inputs := make([]string, 0)
+	switch input := request.Input.(type) {
+	case string:
+		inputs = append(inputs, input)
+	case []interface{}:
+		for _, item := range input {
+			text, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("only string inputs are supported, got %T", item)
+			}
+			inputs = append(inputs, text)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported input type %T", request.Input)
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("input is empty")
+	}
+
+	embeddingsRequests := make([]GetEmbeddingsRequest, len(inputs))
+	for idx, text := range inputs {
+		embeddingsRequests[idx] = GetEmbeddingsRequest{
+			Model:     request.Model,
+			RawPrompt: text,
+		}
+	}
+
+	process := openAIProcessNameFor(request.User)
+	ctx.ComputeRouter.AccountProcessRequest(process)
+	resp, err := ProcessGetEmbeddings(reqCtx, embeddingsRequests, ctx, process, borrow_engine.PRIO_User)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OpenAIEmbeddingsResponse{
+		Object: "list",
+		Data:   make([]OpenAIEmbedding, 0, len(resp.GetEmbeddingsResponse)),
+		Model:  request.Model,
+	}
+	for idx, embedding := range resp.GetEmbeddingsResponse {
+		if embedding == nil {
+			return nil, NewResponseError(ErrCodeInternal, true, "input %d: no embeddings", idx)
+		}
+		if embedding.Error != nil {
+			// the whole request fails, but client can tell which input failed and why
+			return nil, NewResponseError(embedding.Error.Code, embedding.Error.Retryable,
+				"input %d: %s", idx, embedding.Error.Message)
+		}
+		if result.Model == "" {
+			result.Model = embedding.Model
+		}
+		result.Data = append(result.Data, OpenAIEmbedding{
+			Object:    "embedding",
+			Embedding: embedding.Embeddings,
+			Index:     idx,
+		})
+	}
+
+	return result, nil
+}
+
+const openAIModelsOwner = "agent-os"
+
+// ProcessOpenAIListModels - list models auto-detected on all registered compute nodes
+func ProcessOpenAIListModels(ctx *server.Context) *OpenAIModelsList {
+	result := &OpenAIModelsList{
+		Object: "list",
+		Data:   make([]OpenAIModel, 0),
+	}
+
+	seen := make(map[string]struct{})
+	for _, node := range ctx.ComputeRouter.GetNodes() {
+		if node.RemoteEngine == nil {
+			continue
+		}
+		for _, model := range node.RemoteEngine.Models {
+			if _, exists := seen[model]; exists || model == "" {
+				continue
+			}
+			seen[model] = struct{}{}
+			result.Data = append(result.Data, OpenAIModel{
+				ID:      model,
+				Object:  "model",
+				Created: time.Now().Unix(),
+				// endpoints of the nodes are internal, and can have tokens in them
+				OwnedBy: openAIModelsOwner,
+			})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,53 @@
+package cmds
+
+import (
+	"context"
+	"errors"
+	"github.com/d0rc/agent-os/engines"
+	borrow_engine "github.com/d0rc/agent-os/syslib/borrow-engine"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIListModelsHidesEndpoints(t *testing.T) {
+	ctx := newMockTestContext(t, &engines.MockEngine{Model: "mock-model"})
+
+	models := ProcessOpenAIListModels(ctx)
+	if len(models.Data) != 1 || models.Data[0].ID != "mock-model" {
+		t.Fatalf("unexpected models: %+v", models.Data)
+	}
+	if models.Data[0].OwnedBy != openAIModelsOwner || strings.Contains(models.Data[0].OwnedBy, "mock://") {
+		t.Fatalf("model's owner exposes the node: %s", models.Data[0].OwnedBy)
+	}
+}
+
+func TestOpenAIEmbeddingsItemError(t *testing.T) {
+	ctx := newTestContext(t)
+	endpoint := "mock://" + t.Name()
+	if err := engines.RegisterMockEngine(endpoint, &engines.MockEngine{Responses: []*engines.MockResponse{
+		{Pattern: "broken", Error: "engine is down"},
+	}}); err != nil {
+		t.Fatalf("error registering mock engine: %v", err)
+	}
+	t.Cleanup(func() {
+		engines.UnregisterMockEngine(endpoint)
+	})
+	// one job per batch, so failing input doesn't fail the batch of the other one
+	<-ctx.ComputeRouter.AddNode(&borrow_engine.InferenceNode{EmbeddingsEndpointUrl: endpoint, Protocol: "http-mock",
+		JobTypes: []borrow_engine.JobType{borrow_engine.JT_Embeddings}, MaxBatchSize: 1, MaxRequests: 1})
+
+	_, err := ProcessOpenAIEmbeddings(context.Background(),
+		&OpenAIEmbeddingsRequest{Input: []interface{}{"fine", "broken"}}, ctx)
+
+	var responseError *ResponseError
+	if !errors.As(err, &responseError) {
+		t.Fatalf("expected ResponseError, got %v", err)
+	}
+	if !strings.HasPrefix(responseError.Message, "input 1: ") || responseError.Code == ErrCodeInternal {
+		t.Fatalf("failed input isn't reported: %+v", responseError)
+	}
+	openAIError := NewOpenAIError("server_error", err)
+	if openAIError.Error.Code != responseError.Code || openAIError.Error.Retryable != responseError.Retryable {
+		t.Fatalf("client doesn't get code of the error: %+v", openAIError.Error)
+	}
+}
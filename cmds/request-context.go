@@ -0,0 +1,78 @@
+package cmds
+
+import (
+	"context"
+	"github.com/d0rc/agent-os/syslib/borrow-engine"
+	"github.com/d0rc/agent-os/syslib/server"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inflightRequests - cancel functions of the requests by trx and then by request id,
+// since several requests can share a trx, e.g. streaming and non-streaming ones
+var inflightRequests = make(map[string]map[uint64]context.CancelFunc)
+var inflightRequestsLock = sync.Mutex{}
+var lastRequestId uint64
+
+// NewRequestContext - context of the client request, it's done once request's
+// deadline is reached or its transaction is cancelled with ProcessCancelRequests,
+// compute jobs of the request are tagged with request's tags
+func NewRequestContext(request *ClientRequest) (context.Context, context.CancelFunc) {
+	var reqCtx context.Context
+	var cancel context.CancelFunc
+	taggedCtx := borrow_engine.WithTags(context.Background(), request.Tags)
+	if request.Deadline > 0 {
+		reqCtx, cancel = context.WithDeadline(taggedCtx, time.UnixMilli(request.Deadline))
+	} else {
+		reqCtx, cancel = context.WithCancel(taggedCtx)
+	}
+
+	if request.Trx == "" {
+		return reqCtx, cancel
+	}
+
+	trx := request.Trx
+	requestId := atomic.AddUint64(&lastRequestId, 1)
+	inflightRequestsLock.Lock()
+	if inflightRequests[trx] == nil {
+		inflightRequests[trx] = make(map[uint64]context.CancelFunc)
+	}
+	inflightRequests[trx][requestId] = cancel
+	inflightRequestsLock.Unlock()
+
+	return reqCtx, func() {
+		inflightRequestsLock.Lock()
+		delete(inflightRequests[trx], requestId)
+		if len(inflightRequests[trx]) == 0 {
+			delete(inflightRequests, trx)
+		}
+		inflightRequestsLock.Unlock()
+		cancel()
+	}
+}
+
+// ProcessCancelRequests - cancels in-flight requests, all of them if several share the trx,
+// queued compute jobs of these requests are dropped before they reach compute nodes
+func ProcessCancelRequests(trxs []string, ctx *server.Context) (*ServerResponse, error) {
+	cancelled := make([]string, 0, len(trxs))
+	inflightRequestsLock.Lock()
+	for _, trx := range trxs {
+		requests, exists := inflightRequests[trx]
+		if !exists {
+			continue
+		}
+		for _, cancel := range requests {
+			cancel()
+		}
+		delete(inflightRequests, trx)
+		cancelled = append(cancelled, trx)
+	}
+	inflightRequestsLock.Unlock()
+
+	ctx.Log.Info().Msgf("cancelled %d of %d requests", len(cancelled), len(trxs))
+
+	return &ServerResponse{
+		CancelledTrx: cancelled,
+	}, nil
+}
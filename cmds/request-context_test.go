@@ -0,0 +1,33 @@
+package cmds
+
+import (
+	"github.com/d0rc/agent-os/syslib/server"
+	"github.com/rs/zerolog"
+	"testing"
+)
+
+func TestCancelRequestsSharingTrx(t *testing.T) {
+	ctx := &server.Context{Log: zerolog.Nop()}
+	firstCtx, firstDone := NewRequestContext(&ClientRequest{Trx: "shared"})
+	secondCtx, secondDone := NewRequestContext(&ClientRequest{Trx: "shared"})
+	defer secondDone()
+	otherCtx, otherDone := NewRequestContext(&ClientRequest{Trx: "other"})
+	defer otherDone()
+
+	// the first request finishing doesn't unregister the second one
+	firstDone()
+	if firstCtx.Err() == nil {
+		t.Fatalf("finished request's context is not done")
+	}
+
+	resp, _ := ProcessCancelRequests([]string{"shared", "missing"}, ctx)
+	if len(resp.CancelledTrx) != 1 || resp.CancelledTrx[0] != "shared" {
+		t.Fatalf("unexpected cancelled transactions: %v", resp.CancelledTrx)
+	}
+	if secondCtx.Err() == nil {
+		t.Fatalf("request sharing the trx was not cancelled")
+	}
+	if otherCtx.Err() != nil {
+		t.Fatalf("request of another trx was cancelled")
+	}
+}
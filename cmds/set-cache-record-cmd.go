@@ -7,12 +7,13 @@ import (
 func ProcessSetCacheRecords(requests []SetCacheRecord, ctx *server.Context, process string) (response *ServerResponse, err error) {
 	var results = make([]*SetCacheRecordResponse, 0, len(requests))
 	for _, request := range requests {
-		_, err := ctx.Storage.GetTaskCachedResult(request.Namespace, request.Key)
+		err := ctx.Storage.SaveTaskCacheResult(request.Namespace, request.Key, request.Value)
 		if err != nil {
 			ctx.Log.Error().Err(err).
-				Msgf("error getting cached result for %s/%s", request.Namespace, request.Key)
+				Msgf("error saving cached result for %s/%s", request.Namespace, request.Key)
 			results = append(results, &SetCacheRecordResponse{
-				Done: false,
+				Done:  false,
+				Error: ToResponseError(err),
 			})
 			continue
 		}
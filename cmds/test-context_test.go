@@ -0,0 +1,59 @@
+package cmds
+
+import (
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/stdlib/settings"
+	"github.com/d0rc/agent-os/stdlib/storage"
+	borrow_engine "github.com/d0rc/agent-os/syslib/borrow-engine"
+	"github.com/d0rc/agent-os/syslib/server"
+	zlog "github.com/rs/zerolog/log"
+	"testing"
+	"time"
+)
+
+// newTestContext - server context with in-memory storage, static pages and search results
+// and no compute nodes, so commands can run without external services
+func newTestContext(t *testing.T) *server.Context {
+	lg := zlog.Logger
+	db, err := storage.NewSQLiteStorage(lg, ":memory:")
+	if err != nil {
+		t.Fatalf("init storage failed: %v", err)
+	}
+
+	computeRouter := borrow_engine.NewInferenceEngine(lg, server.NewComputeFunction(lg),
+		&borrow_engine.InferenceEngineSettings{TopInterval: time.Hour})
+	go computeRouter.Run()
+
+	return &server.Context{
+		Config:        &settings.ConfigurationFile{},
+		Storage:       db,
+		Log:           lg,
+		ComputeRouter: computeRouter,
+		Pages:         &server.StaticPages{},
+		Search:        &server.StaticSearch{},
+	}
+}
+
+// newMockTestContext - test context with completion and embeddings nodes served by the mock engine
+func newMockTestContext(t *testing.T, mock *engines.MockEngine) *server.Context {
+	ctx := newTestContext(t)
+	endpoint := "mock://" + t.Name()
+	if err := engines.RegisterMockEngine(endpoint, mock); err != nil {
+		t.Fatalf("error registering mock engine: %v", err)
+	}
+	t.Cleanup(func() {
+		engines.UnregisterMockEngine(endpoint)
+	})
+
+	for _, node := range []*borrow_engine.InferenceNode{
+		{EndpointUrl: endpoint, JobTypes: []borrow_engine.JobType{borrow_engine.JT_Completion}},
+		{EmbeddingsEndpointUrl: endpoint, JobTypes: []borrow_engine.JobType{borrow_engine.JT_Embeddings}},
+	} {
+		node.Protocol = "http-mock"
+		node.MaxBatchSize = 4
+		node.MaxRequests = 1
+		<-ctx.ComputeRouter.AddNode(node)
+	}
+
+	return ctx
+}
@@ -1,6 +1,7 @@
 package cmds
 
 import (
+	"encoding/json"
 	"github.com/d0rc/agent-os/engines"
 	"github.com/d0rc/agent-os/syslib/borrow-engine"
 )
@@ -15,14 +16,15 @@ type GetPageRequest struct {
 }
 
 type GetPageResponse struct {
-	StatusCode       uint   `json:"status-code"`
-	Markdown         string `json:"markdown"`
-	RawData          string `json:"raw-data"`
-	DownloadedAt     int    `json:"downloaded-at"`
-	PageAge          int    `json:"page-age"`
-	Question         string `json:"question"`
-	Url              string `json:"url"`
-	OriginalQuestion string `json:"original-question"`
+	StatusCode       uint           `json:"status-code"`
+	Markdown         string         `json:"markdown"`
+	RawData          string         `json:"raw-data"`
+	DownloadedAt     int            `json:"downloaded-at"`
+	PageAge          int            `json:"page-age"`
+	Question         string         `json:"question"`
+	Url              string         `json:"url"`
+	OriginalQuestion string         `json:"original-question"`
+	Error            *ResponseError `json:"error,omitempty"`
 }
 
 type GoogleSearchRequest struct {
@@ -45,19 +47,39 @@ type GoogleSearchResponse struct {
 	URLSearchInfos []*URLSearchInfo `json:"url-search-infos"`
 	DownloadedAt   int              `json:"downloaded-at"`
 	SearchAge      int              `json:"search-age"`
+	Error          *ResponseError   `json:"error,omitempty"`
 }
 
 type GetCompletionRequest struct {
-	Model       string             `json:"model-mask"` // * - any model
-	RawPrompt   string             `json:"raw-prompt"` //
-	Temperature float32            `json:"temperature"`
-	StopTokens  []string           `json:"stop-tokens"`
-	MinResults  int                `json:"min-results"`
-	MaxResults  int                `json:"max-results"` // default = 100
-	BestOf      int                `json:"best-of"`
-	Messages    []*engines.Message `json:"messages"`
+	Model            string             `json:"model-mask"` // glob over node models, * - any model
+	RawPrompt        string             `json:"raw-prompt"` //
+	Temperature      float32            `json:"temperature"`
+	StopTokens       []string           `json:"stop-tokens"`
+	MinResults       int                `json:"min-results"`
+	MaxResults       int                `json:"max-results"` // default = 100
+	BestOf           int                `json:"best-of"`
+	Messages         []*engines.Message `json:"messages"`
+	MaxTokens        int                `json:"max-tokens"` // 0 - engine's default
+	TopP             float32            `json:"top-p"`
+	TopK             int                `json:"top-k"`
+	PresencePenalty  float32            `json:"presence-penalty"`
+	FrequencyPenalty float32            `json:"frequency-penalty"`
+	Seed             *int               `json:"seed"`
+	LogProbs         int                `json:"logprobs"` // most likely tokens returned at each position, llm cache is not used
+	N                int                `json:"n"`        // choices generated at once, if cache has less than min-results
+	JSONSchema       json.RawMessage    `json:"json-schema"`
+	Grammar          string             `json:"grammar"`      // GBNF grammar
+	CachePolicy      string             `json:"cache-policy"` // see CachePolicy* constants, default is read-through
+	MaxAge           int                `json:"max-age"`      // seconds, for fresher-than cache policy
 }
 
+const (
+	CachePolicyReadThrough = "read-through" // cached choices are returned, new ones are cached
+	CachePolicyWriteOnly   = "write-only"   // cache is not read, new choices are cached
+	CachePolicyBypass      = "bypass"       // cache is neither read nor written
+	CachePolicyFresherThan = "fresher-than" // read-through, choices cached more than max-age seconds ago are ignored
+)
+
 type GetEmbeddingsRequest struct {
 	Model           string `json:"model-mask"` // * - any model
 	RawPrompt       string `json:"raw-prompt"` //
@@ -66,14 +88,26 @@ type GetEmbeddingsRequest struct {
 }
 
 type GetEmbeddingsResponse struct {
-	Embeddings []float64 `json:"embeddings"`
-	TextHash   string    `json:"text-hash"`
-	Model      string    `json:"model"`
-	Text       string    `json:"text"`
+	Embeddings []float64      `json:"embeddings"`
+	TextHash   string         `json:"text-hash"`
+	Model      string         `json:"model"`
+	Text       string         `json:"text"`
+	Error      *ResponseError `json:"error,omitempty"`
 }
 
 type GetCompletionResponse struct {
-	Choices []string `json:"choices"`
+	Choices  []string            `json:"choices"`
+	LogProbs [][]engines.LogProb `json:"logprobs,omitempty"` // per choice, if requested
+	Usage    *CompletionUsage    `json:"usage,omitempty"`    // nil if all choices came from llm cache
+	Error    *ResponseError      `json:"error,omitempty"`
+}
+
+// CompletionUsage - tokens of the choices generated for the request, cached choices take none
+type CompletionUsage struct {
+	PromptTokens    int `json:"prompt-tokens"`
+	GeneratedTokens int `json:"generated-tokens"`
+	// Estimated - engine didn't report usage, tokens are counted with GPT-2 tokenizer
+	Estimated bool `json:"estimated"`
 }
 
 type GetCacheRecord struct {
@@ -82,9 +116,10 @@ type GetCacheRecord struct {
 }
 
 type GetCacheRecordResponse struct {
-	Key       string `json:"key"`
-	Namespace string `json:"namespace"`
-	Value     []byte `json:"value"`
+	Key       string         `json:"key"`
+	Namespace string         `json:"namespace"`
+	Value     []byte         `json:"value"`
+	Error     *ResponseError `json:"error,omitempty"`
 }
 
 type SetCacheRecord struct {
@@ -94,7 +129,46 @@ type SetCacheRecord struct {
 }
 
 type SetCacheRecordResponse struct {
-	Done bool `json:"done"`
+	Done  bool           `json:"done"`
+	Error *ResponseError `json:"error,omitempty"`
+}
+
+const (
+	UsageByProcess = "process"
+	UsageByTag     = "tag"
+	UsageByNode    = "node"
+	UsageByModel   = "model"
+)
+
+// GetComputeUsage - compute used during the time window, for chargeback
+type GetComputeUsage struct {
+	From    int64    `json:"from"`     // unix time in seconds, 0 - since the first snapshot
+	To      int64    `json:"to"`       // unix time in seconds, 0 - till now
+	GroupBy []string `json:"group-by"` // any of process, tag, node, model; default is process
+	Process string   `json:"process"`  // glob over process names, empty - any process
+	Tag     string   `json:"tag"`      // glob over tags, empty - any tag or no tags at all
+}
+
+// ComputeUsage - usage of a group, only fields usage is grouped by are set;
+// job with several tags is accounted in the group of every tag
+type ComputeUsage struct {
+	Process         string  `json:"process,omitempty"`
+	Tag             string  `json:"tag,omitempty"`
+	Node            string  `json:"node,omitempty"`
+	Model           string  `json:"model,omitempty"`
+	Requests        uint64  `json:"requests"`
+	Jobs            uint64  `json:"jobs"`
+	PromptTokens    uint64  `json:"prompt-tokens"`
+	GeneratedTokens uint64  `json:"generated-tokens"`
+	GPUSeconds      float64 `json:"gpu-seconds"`
+	Cost            float64 `json:"cost"` // spent on paid nodes
+}
+
+type GetComputeUsageResponse struct {
+	From  int64           `json:"from"`
+	To    int64           `json:"to"`
+	Usage []*ComputeUsage `json:"usage"`
+	Error *ResponseError  `json:"error,omitempty"`
 }
 
 type ClientRequest struct {
@@ -111,20 +185,40 @@ type ClientRequest struct {
 	GetCacheRecords       []GetCacheRecord          `json:"get-cache-records"`
 	SetCacheRecords       []SetCacheRecord          `json:"set-cache-records"`
 	WriteMessagesTrace    []*engines.Message        `json:"write-messages-trace"`
+	Stream                bool                      `json:"stream"`
+	Deadline              int64                     `json:"deadline"`   // unix time in milliseconds, 0 - no deadline
+	CancelTrx             []string                  `json:"cancel-trx"` // transactions of in-flight requests to cancel
+	GetComputeUsage       []GetComputeUsage         `json:"get-compute-usage"`
 
 	UIRequest *UIRequest `json:"ui-request"`
 }
 
+const (
+	StreamSectionCompletions = "get-completion-requests"
+	StreamSectionUIMessages  = "ui-get-messages"
+)
+
+// StreamChunk - piece of generated text, sent to clients asking for a stream
+type StreamChunk struct {
+	Section string `json:"section"` // section of ClientRequest, see StreamSection* constants
+	Index   int    `json:"index"`   // index of the request within the section
+	Choice  int    `json:"choice"`
+	Delta   string `json:"delta"`
+}
+
 type ServerResponse struct {
-	Trx                   string                    `json:"trx"`
-	GoogleSearchResponse  []*GoogleSearchResponse   `json:"google-search-response"`
-	GetPageResponse       []*GetPageResponse        `json:"get-page-response"`
-	GetCompletionResponse []*GetCompletionResponse  `json:"get-completion-response"`
-	GetEmbeddingsResponse []*GetEmbeddingsResponse  `json:"get-embeddings-response"`
-	GetCacheRecords       []*GetCacheRecordResponse `json:"get-cache-records"`
-	SetCacheRecords       []*SetCacheRecordResponse `json:"set-cache-records"`
-	CorrelationId         string                    `json:"correlation-id"`
-	SpecialCaseResponse   string                    `json:"special-case-response"`
+	Trx                   string                     `json:"trx"`
+	GoogleSearchResponse  []*GoogleSearchResponse    `json:"google-search-response"`
+	GetPageResponse       []*GetPageResponse         `json:"get-page-response"`
+	GetCompletionResponse []*GetCompletionResponse   `json:"get-completion-response"`
+	GetEmbeddingsResponse []*GetEmbeddingsResponse   `json:"get-embeddings-response"`
+	GetCacheRecords       []*GetCacheRecordResponse  `json:"get-cache-records"`
+	SetCacheRecords       []*SetCacheRecordResponse  `json:"set-cache-records"`
+	CorrelationId         string                     `json:"correlation-id"`
+	SpecialCaseResponse   string                     `json:"special-case-response"`
+	Error                 *ResponseError             `json:"error,omitempty"` // whole request failed
+	CancelledTrx          []string                   `json:"cancelled-trx"`
+	GetComputeUsage       []*GetComputeUsageResponse `json:"get-compute-usage"`
 
 	UIResponse *UIResponse `json:"ui-response"`
 }
@@ -1,6 +1,7 @@
 package cmds
 
 import (
+	"context"
 	"fmt"
 	"github.com/d0rc/agent-os/engines"
 	"github.com/d0rc/agent-os/syslib/borrow-engine"
@@ -81,6 +82,11 @@ type UIDeleteDocumentResponse struct {
 }
 
 func ProcessUIRequest(uiReq *UIRequest, ctx *server.Context) (*ServerResponse, error) {
+	return ProcessUIRequestStream(context.Background(), uiReq, ctx, nil)
+}
+
+// ProcessUIRequestStream - same as ProcessUIRequest, streaming generated messages to onChunk
+func ProcessUIRequestStream(reqCtx context.Context, uiReq *UIRequest, ctx *server.Context, onChunk func(chunk *StreamChunk)) (*ServerResponse, error) {
 	result := &UIResponse{
 		UIGetMessagesResponse:     make([]UIGetMessageResponse, 0),
 		UIUploadDocumentsResponse: make([]UIUploadDocumentResponse, 0),
@@ -89,10 +95,22 @@ func ProcessUIRequest(uiReq *UIRequest, ctx *server.Context) (*ServerResponse, e
 	}
 
 	if uiReq.UIGetMessages != nil && len(uiReq.UIGetMessages) > 0 {
-		for _, uiGetMessage := range uiReq.UIGetMessages {
-			result.UIGetMessagesResponse = append(result.UIGetMessagesResponse, processUIGetMessage(
+		for idx, uiGetMessage := range uiReq.UIGetMessages {
+			var onDelta func(choice int, delta string)
+			if onChunk != nil {
+				onDelta = func(choice int, delta string) {
+					onChunk(&StreamChunk{
+						Section: StreamSectionUIMessages,
+						Index:   idx,
+						Choice:  choice,
+						Delta:   delta,
+					})
+				}
+			}
+			result.UIGetMessagesResponse = append(result.UIGetMessagesResponse, processUIGetMessage(reqCtx,
 				uiGetMessage,
-				ctx))
+				ctx,
+				onDelta))
 		}
 	}
 	if uiReq.UIUploadDocuments != nil && len(uiReq.UIUploadDocuments) > 0 {
@@ -123,10 +141,10 @@ func ProcessUIRequest(uiReq *UIRequest, ctx *server.Context) (*ServerResponse, e
 }
 
 // processUIGetMessage - process single completion request
-func processUIGetMessage(uiGetMessage UIGetMessage, ctx *server.Context) UIGetMessageResponse {
+func processUIGetMessage(reqCtx context.Context, uiGetMessage UIGetMessage, ctx *server.Context, onDelta func(choice int, delta string)) UIGetMessageResponse {
 	uiGetMessage.Messages = preprocessMessages(uiGetMessage.Messages)
 
-	resp, err := processGetCompletion(
+	resp, err := processGetCompletion(reqCtx,
 		GetCompletionRequest{
 			Model:       uiGetMessage.GenerationSettings.Model,
 			RawPrompt:   collectPrompt(uiGetMessage),
@@ -135,7 +153,10 @@ func processUIGetMessage(uiGetMessage UIGetMessage, ctx *server.Context) UIGetMe
 			MinResults:  uiGetMessage.MaxRequiredResults,
 			MaxResults:  0,
 			BestOf:      uiGetMessage.GenerationSettings.BestOf,
-		}, ctx, "ui", borrow_engine.PRIO_Kernel)
+			TopK:        uiGetMessage.GenerationSettings.TopK,
+			TopP:        uiGetMessage.GenerationSettings.TopP,
+			CachePolicy: uiCachePolicy(uiGetMessage.NoCache),
+		}, ctx, "ui", borrow_engine.PRIO_Kernel, onDelta)
 	if err != nil {
 		return UIGetMessageResponse{
 			Error: err.Error(),
@@ -223,3 +244,12 @@ func ProcessUIDeleteDocument(uiDeleteDocument UIDeleteDocument, ctx *server.Cont
 		Error: "not implemented",
 	}
 }
+
+// uiCachePolicy - no-cache messages are generated anew, but still cached
+func uiCachePolicy(noCache bool) string {
+	if noCache {
+		return CachePolicyWriteOnly
+	}
+
+	return CachePolicyReadThrough
+}
@@ -0,0 +1,254 @@
+package engines
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog"
+	"net/http"
+	"strings"
+)
+
+// Messages API of Anthropic, node endpoint is base url, like https://api.anthropic.com,
+// token is the API key
+
+const anthropicVersion = "2023-06-01"
+
+// anthropicDefaultMaxTokens - max_tokens is required, and it can't exceed model's output limit
+const anthropicDefaultMaxTokens = 4096
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   float32            `json:"temperature"`
+	TopP          float32            `json:"top_p,omitempty"`
+	TopK          int                `json:"top_k,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      anthropicUsage `json:"usage"`
+}
+
+func (r *anthropicResponse) text() string {
+	text := &strings.Builder{}
+	for _, block := range r.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return text.String()
+}
+
+// anthropicStreamEvent - message_start carries prompt usage, content_block_delta carries text,
+// message_delta carries generated tokens, error is sent if generation fails on the way
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta *struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// makeAnthropicMessages - system messages go to the system prompt, consecutive messages
+// of the same role are joined, raw prompt is sent as the user message
+func makeAnthropicMessages(req *GenerationSettings) (string, []anthropicMessage) {
+	if len(req.Messages) == 0 {
+		return "", []anthropicMessage{{Role: string(ChatRoleUser), Content: req.RawPrompt}}
+	}
+
+	system := make([]string, 0)
+	messages := make([]anthropicMessage, 0, len(req.Messages))
+	for idx := range req.Messages {
+		msg := &req.Messages[idx]
+		role := string(ChatRoleUser)
+		switch msg.Role {
+		case ChatRoleSystem:
+			system = append(system, msg.Content)
+			continue
+		case ChatRoleAssistant:
+			role = string(ChatRoleAssistant)
+		}
+
+		if len(messages) > 0 && messages[len(messages)-1].Role == role {
+			messages[len(messages)-1].Content += "\n\n" + msg.Content
+			continue
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: msg.Content})
+	}
+
+	return strings.Join(system, "\n\n"), messages
+}
+
+func anthropicUrl(inferenceEngine *RemoteInferenceEngine) string {
+	if strings.HasSuffix(strings.TrimSuffix(inferenceEngine.EndpointUrl, "/"), "/messages") {
+		return inferenceEngine.EndpointUrl
+	}
+
+	return joinUrl(inferenceEngine.EndpointUrl, "/v1/messages")
+}
+
+func anthropicInference(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*Message, error) {
+	results, err := runJobs(inferenceEngine, batch, func(task *JobQueueTask) (*Message, error) {
+		return anthropicCompletion(inferenceEngine, task, client)
+	})
+	if err != nil {
+		lg.Error().Err(err).
+			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
+	}
+
+	return results, err
+}
+
+func anthropicCompletion(inferenceEngine *RemoteInferenceEngine, task *JobQueueTask, client *http.Client) (*Message, error) {
+	req := task.Req
+	if req.Grammar != "" || len(req.JSONSchema) > 0 {
+		return nil, fmt.Errorf("grammar and json schema constraints are not supported by %s", inferenceEngine.Protocol)
+	}
+	if req.LogProbs > 0 {
+		return nil, fmt.Errorf("log probabilities are not supported by %s", inferenceEngine.Protocol)
+	}
+
+	model := inferenceEngine.modelFor(req)
+	if model == "" {
+		return nil, fmt.Errorf("no model is set for %s", inferenceEngine.EndpointUrl)
+	}
+	system, messages := makeAnthropicMessages(req)
+	cmd := &anthropicRequest{
+		Model:         model,
+		System:        system,
+		Messages:      messages,
+		MaxTokens:     anthropicDefaultMaxTokens,
+		Temperature:   min(req.Temperature, 1), // Anthropic's range is 0..1
+		TopP:          req.TopP,
+		TopK:          req.TopK,
+		StopSequences: req.StopTokens,
+		Stream:        task.ResStream != nil && req.choices() == 1,
+	}
+	if req.MaxTokens > 0 {
+		cmd.MaxTokens = req.MaxTokens
+	}
+
+	// API generates a single choice per request
+	choices := make([]*Message, 0, req.choices())
+	usage := anthropicUsage{}
+	for idx := 0; idx < req.choices(); idx++ {
+		resp, err := postJSON(client, inferenceEngine, anthropicUrl(inferenceEngine), cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedResponse := &anthropicResponse{}
+		content := ""
+		if cmd.Stream && resp.StatusCode == 200 {
+			content, parsedResponse.Usage, err = readAnthropicStream(resp, task)
+		} else {
+			err = readJSONResponse(resp, parsedResponse)
+			content = parsedResponse.text()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		choices = append(choices, &Message{
+			Role:    ChatRoleAssistant,
+			Content: content,
+		})
+		usage.InputTokens += parsedResponse.Usage.InputTokens
+		usage.OutputTokens += parsedResponse.Usage.OutputTokens
+	}
+
+	results, err := groupChoices(choices, 1, req.choices())
+	if err != nil {
+		return nil, err
+	}
+	reportUsage(inferenceEngine, []*JobQueueTask{task}, results, usage.InputTokens, usage.OutputTokens)
+	if task.Res != nil {
+		task.Res <- results[0]
+	}
+
+	return results[0], nil
+}
+
+func readAnthropicStream(resp *http.Response, task *JobQueueTask) (string, anthropicUsage, error) {
+	content := &strings.Builder{}
+	usage := anthropicUsage{}
+	err := readEventStream(resp.Body, func(data []byte) error {
+		event := &anthropicStreamEvent{}
+		if err := json.Unmarshal(data, event); err != nil {
+			return fmt.Errorf("error unmarshalling stream event: %v, %s", err, string(data))
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				usage.InputTokens = event.Message.Usage.InputTokens
+			}
+		case "content_block_delta":
+			if event.Delta != nil && event.Delta.Text != "" {
+				content.WriteString(event.Delta.Text)
+				task.ResStream <- event.Delta.Text
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error != nil && (event.Error.Type == "overloaded_error" || event.Error.Type == "rate_limit_error") {
+				return &RateLimitError{StatusCode: 529, Message: event.Error.Message}
+			}
+			return fmt.Errorf("error in stream: %s", string(data))
+		}
+		return nil
+	})
+	_ = resp.Body.Close()
+
+	return content.String(), usage, err
+}
+
+// fetchAnthropicModels - models available with the token, newest first
+func fetchAnthropicModels(engine *RemoteInferenceEngine) error {
+	client := &http.Client{Timeout: InferenceTimeout}
+	models := &struct {
+		Data []struct {
+			Id string `json:"id"`
+		} `json:"data"`
+	}{}
+
+	if err := getJSON(client, engine, joinUrl(strings.TrimSuffix(anthropicUrl(engine), "/messages"), "/models?limit=1000"), models); err != nil {
+		return fmt.Errorf("error reading models: %w", err)
+	}
+
+	engine.Models = make([]string, 0, len(models.Data))
+	for _, model := range models.Data {
+		engine.Models = append(engine.Models, model.Id)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package engines
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnthropicProtocol(t *testing.T) {
+	var received map[string]interface{}
+	rateLimited := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" || r.Header.Get("anthropic-version") == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			_, _ = w.Write([]byte(`{"data":[{"id":"claude-haiku"}]}`))
+		case "/v1/messages":
+			if rateLimited {
+				w.Header().Set("Retry-After", "7")
+				w.WriteHeader(529)
+				_, _ = w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error"}}`))
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&received)
+			_, _ = w.Write([]byte(`{"content":[{"type":"text","text":"Paris"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: "http-anthropic", Token: "key"}
+	if err := discoverModels(engine); err != nil || len(engine.Models) != 1 || engine.Models[0] != "claude-haiku" {
+		t.Fatalf("unexpected models: %v, %v", engine.Models, err)
+	}
+
+	var info *StatisticsInfo
+	task := &JobQueueTask{Req: &GenerationSettings{
+		Messages: []Message{
+			{Role: ChatRoleSystem, Content: "Answer briefly."},
+			{Role: ChatRoleUser, Content: "Capital of France?"},
+			{Role: ChatRoleUser, Content: "One word."},
+		},
+		StopTokens:         []string{"\n"},
+		Temperature:        1.5,
+		StatisticsCallback: func(i *StatisticsInfo) { info = i },
+	}}
+	results, err := RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+	if err != nil || results[0].Content != "Paris" {
+		t.Fatalf("unexpected completion: %v, %v", results, err)
+	}
+	messages, _ := received["messages"].([]interface{})
+	if received["system"] != "Answer briefly." || len(messages) != 1 || received["temperature"] != 1.0 ||
+		received["max_tokens"] != float64(anthropicDefaultMaxTokens) || len(received["stop_sequences"].([]interface{})) != 1 {
+		t.Fatalf("unexpected request: %v", received)
+	}
+	if info == nil || info.PromptTokens != 12 || info.TokensGenerated != 3 {
+		t.Fatalf("usage is not mapped: %+v", info)
+	}
+
+	rateLimited = true
+	_, err = RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+	rateLimit := &RateLimitError{}
+	if !errors.As(err, &rateLimit) || rateLimit.RetryAfter != 7*time.Second {
+		t.Fatalf("expected rate limit error with retry after, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// BatchError - jobs of the batch failed separately, Errors has an entry for every job
+// of the batch, nil for the ones which succeeded and got their results
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return fmt.Sprintf("%d of %d jobs failed, first error: %v", failed, len(e.Errors), first)
+}
+
+func (e *BatchError) Unwrap() []error {
+	return e.Errors
+}
+
+// Failed - tells if job of the batch has failed, any error other than BatchError fails the whole batch
+func Failed(err error, idx int) bool {
+	batchErr := &BatchError{}
+	if errors.As(err, &batchErr) {
+		return idx < len(batchErr.Errors) && batchErr.Errors[idx] != nil
+	}
+
+	return err != nil
+}
+
+// parallelRequests - requests to the engine running at once for a single batch,
+// engine is expected to handle as many sequences as its batch size
+func (engine *RemoteInferenceEngine) parallelRequests() int {
+	return max(engine.MaxBatchSize, 1)
+}
+
+// runJobs - runs jobs of the batch as separate requests, results of failed jobs are nil
+// and their errors are reported with BatchError
+func runJobs(engine *RemoteInferenceEngine, batch []*JobQueueTask, job func(task *JobQueueTask) (*Message, error)) ([]*Message, error) {
+	results := make([]*Message, len(batch))
+	errs := make([]error, len(batch))
+	runParallel(engine, len(batch), func(idx int) {
+		results[idx], errs[idx] = job(batch[idx])
+	})
+
+	return results, batchError(errs)
+}
+
+// runParallel - calls f for every index, at most parallelRequests at once
+func runParallel(engine *RemoteInferenceEngine, n int, f func(idx int)) {
+	slots := make(chan struct{}, engine.parallelRequests())
+	wg := sync.WaitGroup{}
+	for idx := 0; idx < n; idx++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(idx int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			f(idx)
+		}(idx)
+	}
+	wg.Wait()
+}
+
+func batchError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errors: errs}
+		}
+	}
+
+	return nil
+}
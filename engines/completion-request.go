@@ -22,5 +22,25 @@ func RunCompletionRequest(lg zerolog.Logger, inferenceEngine *RemoteInferenceEng
 		return togetherAIInference(inferenceEngine, batch, &client)
 	}
 
+	if inferenceEngine.Protocol == "http-llamacpp" {
+		return llamaCppInference(lg, inferenceEngine, batch, &client)
+	}
+
+	if inferenceEngine.Protocol == "http-ollama" {
+		return ollamaInference(lg, inferenceEngine, batch, &client)
+	}
+
+	if inferenceEngine.Protocol == "http-anthropic" {
+		return anthropicInference(lg, inferenceEngine, batch, &client)
+	}
+
+	if inferenceEngine.Protocol == "http-gemini" {
+		return geminiInference(lg, inferenceEngine, batch, &client)
+	}
+
+	if inferenceEngine.Protocol == "http-mock" {
+		return mockInference(inferenceEngine, batch)
+	}
+
 	return nil, fmt.Errorf("unsupported protocol %s", inferenceEngine.Protocol)
 }
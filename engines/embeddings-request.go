@@ -21,6 +21,26 @@ func RunEmbeddingsRequest(inferenceEngine *RemoteInferenceEngine, batch []*JobQu
 		Timeout: InferenceTimeout,
 	}
 
+	if inferenceEngine.Protocol == "http-llamacpp" {
+		return llamaCppEmbeddings(inferenceEngine, batch, &client)
+	}
+
+	if inferenceEngine.Protocol == "http-ollama" {
+		return ollamaEmbeddings(inferenceEngine, batch, &client)
+	}
+
+	if inferenceEngine.Protocol == "http-gemini" {
+		return geminiEmbeddings(inferenceEngine, batch, &client)
+	}
+
+	if inferenceEngine.Protocol == "http-anthropic" {
+		return nil, fmt.Errorf("embeddings are not supported by %s", inferenceEngine.Protocol)
+	}
+
+	if inferenceEngine.Protocol == "http-mock" {
+		return mockEmbeddings(inferenceEngine, batch)
+	}
+
 	type command struct {
 		Input []string `json:"texts"`
 	}
@@ -0,0 +1,55 @@
+package engines
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+const eventStreamMaxLineSize = 1024 * 1024
+
+var eventStreamDataPrefix = []byte("data:")
+var eventStreamDone = []byte("[DONE]")
+
+// readEventStream - reads server-sent events stream, calling f for each data payload,
+// stops on [DONE] marker or end of the stream
+func readEventStream(body io.Reader, f func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), eventStreamMaxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if !bytes.HasPrefix(line, eventStreamDataPrefix) {
+			// comments, event names and keep-alive empty lines
+			continue
+		}
+
+		data := bytes.TrimSpace(line[len(eventStreamDataPrefix):])
+		if bytes.Equal(data, eventStreamDone) {
+			return nil
+		}
+
+		if err := f(data); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readJSONLines - reads newline delimited JSON stream, calling f for each line
+func readJSONLines(body io.Reader, f func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), eventStreamMaxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := f(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,350 @@
+package engines
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Gemini API, node endpoint is base url with the version, like
+// https://generativelanguage.googleapis.com/v1beta, token is the API key
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature        float32         `json:"temperature"`
+	TopP               float32         `json:"topP,omitempty"`
+	TopK               int             `json:"topK,omitempty"`
+	MaxOutputTokens    int             `json:"maxOutputTokens,omitempty"`
+	StopSequences      []string        `json:"stopSequences,omitempty"`
+	CandidateCount     int             `json:"candidateCount,omitempty"`
+	PresencePenalty    float32         `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   float32         `json:"frequencyPenalty,omitempty"`
+	Seed               *int            `json:"seed,omitempty"`
+	ResponseLogprobs   bool            `json:"responseLogprobs,omitempty"`
+	Logprobs           int             `json:"logprobs,omitempty"`
+	ResponseMimeType   string          `json:"responseMimeType,omitempty"`
+	ResponseJsonSchema json.RawMessage `json:"responseJsonSchema,omitempty"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiLogProbsCandidate struct {
+	Token          string  `json:"token"`
+	LogProbability float64 `json:"logProbability"`
+}
+
+type geminiLogProbsResult struct {
+	ChosenCandidates []geminiLogProbsCandidate `json:"chosenCandidates"`
+	TopCandidates    []struct {
+		Candidates []geminiLogProbsCandidate `json:"candidates"`
+	} `json:"topCandidates"`
+}
+
+type geminiCandidate struct {
+	Content        geminiContent         `json:"content"`
+	FinishReason   string                `json:"finishReason"`
+	LogprobsResult *geminiLogProbsResult `json:"logprobsResult"`
+}
+
+type geminiResponse struct {
+	Candidates     []geminiCandidate `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (r *geminiResponse) choices() []*Message {
+	choices := make([]*Message, 0, len(r.Candidates))
+	for _, candidate := range r.Candidates {
+		text := &strings.Builder{}
+		for _, part := range candidate.Content.Parts {
+			text.WriteString(part.Text)
+		}
+		message := &Message{Role: ChatRoleAssistant, Content: text.String()}
+		if lp := candidate.LogprobsResult; lp != nil {
+			for idx, chosen := range lp.ChosenCandidates {
+				logProb := LogProb{Token: chosen.Token, LogProb: chosen.LogProbability}
+				if idx < len(lp.TopCandidates) {
+					for _, top := range lp.TopCandidates[idx].Candidates {
+						logProb.TopLogProbs = append(logProb.TopLogProbs, TopLogProbs{Token: top.Token, LogProb: top.LogProbability})
+					}
+				}
+				message.LogProbs = append(message.LogProbs, logProb)
+			}
+		}
+		choices = append(choices, message)
+	}
+
+	return choices
+}
+
+// makeGeminiContents - system messages go to system instruction, assistant is the model,
+// consecutive messages of the same role are parts of the same content
+func makeGeminiContents(req *GenerationSettings) (*geminiContent, []geminiContent) {
+	if len(req.Messages) == 0 {
+		return nil, []geminiContent{{Role: "user", Parts: []geminiPart{{Text: req.RawPrompt}}}}
+	}
+
+	var system *geminiContent
+	contents := make([]geminiContent, 0, len(req.Messages))
+	for idx := range req.Messages {
+		msg := &req.Messages[idx]
+		role := "user"
+		switch msg.Role {
+		case ChatRoleSystem:
+			if system == nil {
+				system = &geminiContent{}
+			}
+			system.Parts = append(system.Parts, geminiPart{Text: msg.Content})
+			continue
+		case ChatRoleAssistant:
+			role = "model"
+		}
+
+		if len(contents) > 0 && contents[len(contents)-1].Role == role {
+			contents[len(contents)-1].Parts = append(contents[len(contents)-1].Parts, geminiPart{Text: msg.Content})
+			continue
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: []geminiPart{{Text: msg.Content}}})
+	}
+
+	return system, contents
+}
+
+func geminiModelUrl(inferenceEngine *RemoteInferenceEngine, model, method string) string {
+	return joinUrl(inferenceEngine.EndpointUrl, fmt.Sprintf("/models/%s:%s", url.PathEscape(model), method))
+}
+
+func geminiInference(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*Message, error) {
+	results, err := runJobs(inferenceEngine, batch, func(task *JobQueueTask) (*Message, error) {
+		return geminiCompletion(inferenceEngine, task, client)
+	})
+	if err != nil {
+		lg.Error().Err(err).
+			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
+	}
+
+	return results, err
+}
+
+func geminiCompletion(inferenceEngine *RemoteInferenceEngine, task *JobQueueTask, client *http.Client) (*Message, error) {
+	req := task.Req
+	if req.Grammar != "" {
+		return nil, fmt.Errorf("grammar constraints are not supported by %s", inferenceEngine.Protocol)
+	}
+
+	model := inferenceEngine.modelFor(req)
+	if model == "" {
+		return nil, fmt.Errorf("no model is set for %s", inferenceEngine.EndpointUrl)
+	}
+	system, contents := makeGeminiContents(req)
+	cmd := &geminiRequest{
+		Contents:          contents,
+		SystemInstruction: system,
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:      req.Temperature,
+			TopP:             req.TopP,
+			TopK:             req.TopK,
+			MaxOutputTokens:  req.MaxTokens,
+			StopSequences:    req.StopTokens,
+			CandidateCount:   req.N,
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+			Seed:             req.Seed,
+			ResponseLogprobs: req.LogProbs > 0,
+			Logprobs:         req.LogProbs,
+		},
+	}
+	if len(req.JSONSchema) > 0 {
+		cmd.GenerationConfig.ResponseMimeType = "application/json"
+		cmd.GenerationConfig.ResponseJsonSchema = req.JSONSchema
+	}
+
+	stream := task.ResStream != nil && req.choices() == 1
+	method := "generateContent"
+	if stream {
+		method = "streamGenerateContent?alt=sse"
+	}
+	resp, err := postJSON(client, inferenceEngine, geminiModelUrl(inferenceEngine, model, method), cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResponse := &geminiResponse{}
+	if stream && resp.StatusCode == 200 {
+		parsedResponse, err = readGeminiStream(resp, task)
+	} else {
+		err = readJSONResponse(resp, parsedResponse)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(parsedResponse.Candidates) == 0 && parsedResponse.PromptFeedback != nil {
+		return nil, fmt.Errorf("prompt is blocked: %s", parsedResponse.PromptFeedback.BlockReason)
+	}
+
+	results, err := groupChoices(parsedResponse.choices(), 1, req.choices())
+	if err != nil {
+		return nil, err
+	}
+	promptTokens, completionTokens := 0, 0
+	if parsedResponse.UsageMetadata != nil {
+		promptTokens = parsedResponse.UsageMetadata.PromptTokenCount
+		completionTokens = parsedResponse.UsageMetadata.CandidatesTokenCount
+	}
+	reportUsage(inferenceEngine, []*JobQueueTask{task}, results, promptTokens, completionTokens)
+	if task.Res != nil {
+		task.Res <- results[0]
+	}
+
+	return results[0], nil
+}
+
+// readGeminiStream - every chunk is a response with a piece of the candidate,
+// usage of the latest chunk is the usage of the whole response
+func readGeminiStream(resp *http.Response, task *JobQueueTask) (*geminiResponse, error) {
+	content := &strings.Builder{}
+	result := &geminiResponse{}
+	err := readEventStream(resp.Body, func(data []byte) error {
+		chunk := &geminiResponse{}
+		if err := json.Unmarshal(data, chunk); err != nil {
+			return fmt.Errorf("error unmarshalling stream chunk: %v, %s", err, string(data))
+		}
+		if chunk.UsageMetadata != nil {
+			result.UsageMetadata = chunk.UsageMetadata
+		}
+		if chunk.PromptFeedback != nil {
+			result.PromptFeedback = chunk.PromptFeedback
+		}
+		for _, choice := range chunk.choices() {
+			if choice.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Content)
+			task.ResStream <- choice.Content
+		}
+		return nil
+	})
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if content.Len() > 0 || result.PromptFeedback == nil {
+		result.Candidates = append(result.Candidates, geminiCandidate{
+			Content: geminiContent{Role: "model", Parts: []geminiPart{{Text: content.String()}}},
+		})
+	}
+
+	return result, nil
+}
+
+// fetchGeminiModels - models supporting generation, or embeddings for nodes serving embeddings only
+func fetchGeminiModels(engine *RemoteInferenceEngine) error {
+	client := &http.Client{Timeout: InferenceTimeout}
+	base, method := engine.EndpointUrl, "generateContent"
+	if base == "" {
+		base, method = engine.EmbeddingsEndpointUrl, "embedContent"
+	}
+
+	models := make([]string, 0)
+	pageToken := ""
+	for {
+		page := &struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		if err := getJSON(client, engine, joinUrl(base, "/models?pageSize=1000&pageToken="+url.QueryEscape(pageToken)), page); err != nil {
+			return fmt.Errorf("error reading models: %w", err)
+		}
+
+		for _, model := range page.Models {
+			for _, supported := range model.SupportedGenerationMethods {
+				if supported == method {
+					models = append(models, strings.TrimPrefix(model.Name, "models/"))
+					break
+				}
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	engine.Models = models
+
+	return nil
+}
+
+func geminiEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*vectors.Vector, error) {
+	type embedRequest struct {
+		Model   string        `json:"model"`
+		Content geminiContent `json:"content"`
+	}
+
+	model := inferenceEngine.modelFor(nil)
+	if model == "" {
+		return nil, fmt.Errorf("no model is set for %s", inferenceEngine.EmbeddingsEndpointUrl)
+	}
+	cmd := &struct {
+		Requests []embedRequest `json:"requests"`
+	}{Requests: make([]embedRequest, 0, len(batch))}
+	for _, task := range batch {
+		cmd.Requests = append(cmd.Requests, embedRequest{
+			Model:   "models/" + model,
+			Content: geminiContent{Parts: []geminiPart{{Text: task.Req.RawPrompt}}},
+		})
+	}
+
+	resp, err := postJSON(client, inferenceEngine,
+		joinUrl(inferenceEngine.EmbeddingsEndpointUrl, fmt.Sprintf("/models/%s:batchEmbedContents", url.PathEscape(model))), cmd)
+	if err != nil {
+		return nil, err
+	}
+	response := &struct {
+		Embeddings []struct {
+			Values []float64 `json:"values"`
+		} `json:"embeddings"`
+	}{}
+	if err = readJSONResponse(resp, response); err != nil {
+		return nil, err
+	}
+	if len(response.Embeddings) != len(batch) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(response.Embeddings))
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	for idx, task := range batch {
+		results[idx] = &vectors.Vector{
+			VecF64: response.Embeddings[idx].Values,
+			Model:  &model,
+		}
+		if task.ResEmbeddings != nil {
+			task.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,79 @@
+package engines
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGeminiProtocol(t *testing.T) {
+	var received geminiRequest
+	rateLimited := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-goog-api-key") != "key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1beta/models":
+			_, _ = w.Write([]byte(`{"models":[
+				{"name":"models/text-embedding-004","supportedGenerationMethods":["embedContent"]},
+				{"name":"models/gemini-flash","supportedGenerationMethods":["generateContent","countTokens"]}]}`))
+		case "/v1beta/models/gemini-flash:generateContent":
+			if rateLimited {
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","details":[
+					{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"38s"}]}}`))
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&received)
+			_, _ = w.Write([]byte(`{"candidates":[
+				{"content":{"role":"model","parts":[{"text":"Pa"},{"text":"ris"}]},"finishReason":"STOP"},
+				{"content":{"role":"model","parts":[{"text":"Paris, France"}]},"finishReason":"STOP"}],
+				"usageMetadata":{"promptTokenCount":9,"candidatesTokenCount":5}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL + "/v1beta", Protocol: "http-gemini", Token: "key"}
+	if err := discoverModels(engine); err != nil || len(engine.Models) != 1 || engine.Models[0] != "gemini-flash" {
+		t.Fatalf("unexpected models: %v, %v", engine.Models, err)
+	}
+
+	var info *StatisticsInfo
+	task := &JobQueueTask{Req: &GenerationSettings{
+		Messages: []Message{
+			{Role: ChatRoleSystem, Content: "Answer briefly."},
+			{Role: ChatRoleUser, Content: "Capital of France?"},
+			{Role: ChatRoleAssistant, Content: "Let me think."},
+		},
+		StopTokens:         []string{"\n"},
+		N:                  2,
+		StatisticsCallback: func(i *StatisticsInfo) { info = i },
+	}}
+	results, err := RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+	if err != nil || results[0].Content != "Paris" || len(results[0].Alternatives) != 1 {
+		t.Fatalf("unexpected completion: %+v, %v", results, err)
+	}
+	if received.SystemInstruction == nil || received.SystemInstruction.Parts[0].Text != "Answer briefly." ||
+		len(received.Contents) != 2 || received.Contents[1].Role != "model" ||
+		received.GenerationConfig.CandidateCount != 2 || received.GenerationConfig.StopSequences[0] != "\n" {
+		t.Fatalf("unexpected request: %+v", received)
+	}
+	if info == nil || info.PromptTokens != 9 || info.TokensGenerated != 5 {
+		t.Fatalf("usage is not mapped: %+v", info)
+	}
+
+	rateLimited = true
+	_, err = RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+	rateLimit := &RateLimitError{}
+	if !errors.As(err, &rateLimit) || rateLimit.RetryAfter != 38*time.Second {
+		t.Fatalf("expected rate limit error with retry delay, got %v", err)
+	}
+}
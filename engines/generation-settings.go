@@ -0,0 +1,97 @@
+package engines
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultMaxTokens - generation limit, used if request doesn't set one
+const DefaultMaxTokens = 16384
+
+func (s *GenerationSettings) maxTokens() int {
+	if s.MaxTokens <= 0 {
+		return DefaultMaxTokens
+	}
+
+	return s.MaxTokens
+}
+
+func (s *GenerationSettings) choices() int {
+	return max(s.N, 1)
+}
+
+// CompletionLogProbs - log probabilities in the format of /completions API
+type CompletionLogProbs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogProbs []float64            `json:"token_logprobs"`
+	TopLogProbs   []map[string]float64 `json:"top_logprobs,omitempty"`
+}
+
+func (lp *CompletionLogProbs) toLogProbs() []LogProb {
+	if lp == nil {
+		return nil
+	}
+
+	result := make([]LogProb, 0, len(lp.Tokens))
+	for idx, token := range lp.Tokens {
+		logProb := LogProb{Token: token}
+		if idx < len(lp.TokenLogProbs) {
+			logProb.LogProb = lp.TokenLogProbs[idx]
+		}
+		if idx < len(lp.TopLogProbs) {
+			for topToken, topLogProb := range lp.TopLogProbs[idx] {
+				logProb.TopLogProbs = append(logProb.TopLogProbs, TopLogProbs{Token: topToken, LogProb: topLogProb})
+			}
+			// maps are unordered, most likely tokens go first
+			sort.Slice(logProb.TopLogProbs, func(i, j int) bool {
+				return logProb.TopLogProbs[i].LogProb > logProb.TopLogProbs[j].LogProb
+			})
+		}
+		result = append(result, logProb)
+	}
+
+	return result
+}
+
+// ToCompletionLogProbs - converts log probabilities to the format of /completions API
+func ToCompletionLogProbs(logProbs []LogProb) *CompletionLogProbs {
+	if len(logProbs) == 0 {
+		return nil
+	}
+
+	result := &CompletionLogProbs{
+		Tokens:        make([]string, 0, len(logProbs)),
+		TokenLogProbs: make([]float64, 0, len(logProbs)),
+	}
+	for _, logProb := range logProbs {
+		result.Tokens = append(result.Tokens, logProb.Token)
+		result.TokenLogProbs = append(result.TokenLogProbs, logProb.LogProb)
+		if len(logProb.TopLogProbs) > 0 {
+			top := make(map[string]float64, len(logProb.TopLogProbs))
+			for _, topLogProb := range logProb.TopLogProbs {
+				top[topLogProb.Token] = topLogProb.LogProb
+			}
+			result.TopLogProbs = append(result.TopLogProbs, top)
+		}
+	}
+
+	return result
+}
+
+// groupChoices - engines return choices of all the prompts of the batch in a single list,
+// n choices per prompt, the first choice becomes the message and the rest are its alternatives
+func groupChoices(choices []*Message, batchSize, n int) ([]*Message, error) {
+	if len(choices) < batchSize*n {
+		return nil, fmt.Errorf("expected %d choices for %d prompts, got %d", batchSize*n, batchSize, len(choices))
+	}
+
+	results := make([]*Message, batchSize)
+	for idx := range results {
+		results[idx] = choices[idx*n]
+		if n > 1 {
+			results[idx].Alternatives = choices[idx*n+1 : (idx+1)*n]
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,87 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// joinUrl - native protocols take base url of the server as node endpoint
+func joinUrl(base, path string) string {
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// postJSON - sends request with the engine's token, if it has one, response body
+// should be closed by the caller, unless error is returned
+func postJSON(client *http.Client, engine *RemoteInferenceEngine, url string, request interface{}) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	setAuthHeaders(httpReq, engine)
+
+	return client.Do(httpReq)
+}
+
+// readJSONResponse - reads and parses the whole response, closing its body
+func readJSONResponse(resp *http.Response, v interface{}) error {
+	result, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return responseError(resp, result)
+	}
+
+	if err = json.Unmarshal(result, v); err != nil {
+		return fmt.Errorf("error unmarshalling response: %v, %s", err, string(result))
+	}
+
+	return nil
+}
+
+// getJSON - same as postJSON followed by readJSONResponse, for GET requests
+func getJSON(client *http.Client, engine *RemoteInferenceEngine, url string, v interface{}) error {
+	httpReq, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	setAuthHeaders(httpReq, engine)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+
+	return readJSONResponse(resp, v)
+}
+
+// setAuthHeaders - engine's token goes as bearer token, unless the protocol has its own header
+func setAuthHeaders(httpReq *http.Request, engine *RemoteInferenceEngine) {
+	switch engine.Protocol {
+	case "http-anthropic":
+		httpReq.Header.Set("anthropic-version", anthropicVersion)
+		if engine.Token != "" {
+			httpReq.Header.Set("x-api-key", engine.Token)
+		}
+	case "http-gemini":
+		if engine.Token != "" {
+			httpReq.Header.Set("x-goog-api-key", engine.Token)
+		}
+	default:
+		if engine.Token != "" {
+			httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", engine.Token))
+		}
+	}
+}
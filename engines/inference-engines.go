@@ -1,6 +1,7 @@
 package engines
 
 import (
+	"github.com/d0rc/agent-os/vectors"
 	"github.com/rs/zerolog"
 	"strings"
 	"time"
@@ -68,27 +69,24 @@ type RemoteInferenceEngine struct {
 	Protocol              string
 	Token                 string
 	ModelsEndpoint        string
+	ContextSize           int // tokens, reported by http-llamacpp servers, 0 - unknown
 }
 
 func StartInferenceEngine(lg zerolog.Logger, engine *RemoteInferenceEngine, done chan struct{}) {
 	// we need to send a completion request to the engine
 	// detect the model, then send embeddings request to the engine and
 	// detect the model and dimensions
-	_, err := RunCompletionRequest(lg, engine, []*JobQueueTask{
-		{
-			Req: &GenerationSettings{RawPrompt: "### Instruction\nProvide an answer. 2 + 2 = ?\n### Assistant: ", MaxRetries: 1, Temperature: 0.1},
-		},
-	})
+	if err := discoverModels(engine); err != nil {
+		lg.Error().Err(err).Msgf("[%s] error discovering models", engine.Protocol)
+	}
+
+	err := ProbeCompletion(lg, engine)
 	if err != nil {
 		// engine failed to run completion
 		engine.CompletionFailed = true
 	}
 
-	cEmb, err := RunEmbeddingsRequest(engine, []*JobQueueTask{
-		{
-			Req: &GenerationSettings{RawPrompt: "Hello world", MaxRetries: 1, Temperature: 0.1},
-		},
-	})
+	cEmb, err := ProbeEmbeddings(engine)
 	if err != nil {
 		// engine failed to run embeddings
 		engine.EmbeddingsFailed = true
@@ -118,6 +116,60 @@ func StartInferenceEngine(lg zerolog.Logger, engine *RemoteInferenceEngine, done
 	done <- struct{}{}
 }
 
+// discoverModels - native protocols list models the server runs, OpenAI compatible engines
+// are asked for them on the first completion request
+func discoverModels(engine *RemoteInferenceEngine) error {
+	switch engine.Protocol {
+	case "http-llamacpp":
+		return fetchLlamaCppProps(engine)
+	case "http-ollama":
+		return fetchOllamaModels(engine)
+	case "http-anthropic":
+		return fetchAnthropicModels(engine)
+	case "http-gemini":
+		return fetchGeminiModels(engine)
+	case "http-mock":
+		engine.Models = []string{mockFor(engine).model()}
+	}
+
+	return nil
+}
+
+const probeCompletionPrompt = "### Instruction\nProvide an answer. 2 + 2 = ?\n### Assistant: "
+const probeEmbeddingsPrompt = "Hello world"
+
+// ProbeCompletion - runs the detection prompt, also used to check if failing engine has recovered
+func ProbeCompletion(lg zerolog.Logger, engine *RemoteInferenceEngine) error {
+	_, err := RunCompletionRequest(lg, engine, []*JobQueueTask{
+		{
+			Req: &GenerationSettings{RawPrompt: probeCompletionPrompt, MaxRetries: 1, Temperature: 0.1},
+		},
+	})
+
+	return err
+}
+
+// ProbeEmbeddings - same as ProbeCompletion for embeddings
+func ProbeEmbeddings(engine *RemoteInferenceEngine) ([]*vectors.Vector, error) {
+	return RunEmbeddingsRequest(engine, []*JobQueueTask{
+		{
+			Req: &GenerationSettings{RawPrompt: probeEmbeddingsPrompt, MaxRetries: 1, Temperature: 0.1},
+		},
+	})
+}
+
+// modelFor - model chosen by the compute router, or the first model of the engine
+func (engine *RemoteInferenceEngine) modelFor(req *GenerationSettings) string {
+	if req != nil && req.Model != "" {
+		return req.Model
+	}
+	if len(engine.Models) > 0 {
+		return engine.Models[0]
+	}
+
+	return ""
+}
+
 func parseModelName(s string) string {
 	// /Users/ds/.cache/lm-studio/models/TheBloke/dolphin-2.2.1-mistral-7B-GGUF/dolphin-2.2.1-mistral-7b.Q6_K.gguf
 	if strings.HasSuffix(s, ".gguf") {
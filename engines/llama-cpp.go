@@ -0,0 +1,316 @@
+package engines
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	"github.com/rs/zerolog"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// native API of llama.cpp server: /completion, /embedding, /props and /apply-template,
+// node endpoints are base urls of the server, like http://localhost:8080
+
+type llamaCppCompletionRequest struct {
+	Prompt           string          `json:"prompt"`
+	NPredict         int             `json:"n_predict"` // -1 - until context is full
+	Temperature      float32         `json:"temperature"`
+	TopK             int             `json:"top_k,omitempty"`
+	TopP             float32         `json:"top_p,omitempty"`
+	PresencePenalty  float32         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32         `json:"frequency_penalty,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	NProbs           int             `json:"n_probs,omitempty"`
+	JSONSchema       json.RawMessage `json:"json_schema,omitempty"`
+	Grammar          string          `json:"grammar,omitempty"`
+	Stream           bool            `json:"stream,omitempty"`
+	CachePrompt      bool            `json:"cache_prompt"`
+}
+
+// llamaCppTokenProbs - older servers return probabilities of the most likely tokens,
+// newer ones return log probabilities, the same way OpenAI does
+type llamaCppTokenProbs struct {
+	Content string `json:"content"`
+	Probs   []struct {
+		TokStr string  `json:"tok_str"`
+		Prob   float64 `json:"prob"`
+	} `json:"probs"`
+	Token       string        `json:"token"`
+	LogProb     *float64      `json:"logprob"`
+	TopLogProbs []TopLogProbs `json:"top_logprobs"`
+}
+
+func (p *llamaCppTokenProbs) toLogProb() LogProb {
+	if p.LogProb != nil {
+		return LogProb{Token: p.Token, LogProb: *p.LogProb, TopLogProbs: p.TopLogProbs}
+	}
+
+	logProb := LogProb{Token: p.Content, TopLogProbs: make([]TopLogProbs, 0, len(p.Probs))}
+	found := false
+	for _, prob := range p.Probs {
+		top := TopLogProbs{Token: prob.TokStr, LogProb: math.Log(prob.Prob)}
+		logProb.TopLogProbs = append(logProb.TopLogProbs, top)
+		if prob.TokStr == p.Content && !found {
+			logProb.LogProb = top.LogProb
+			found = true
+		}
+	}
+	if !found && len(logProb.TopLogProbs) > 0 {
+		// sampled token is less likely than the least likely of the top ones
+		logProb.LogProb = logProb.TopLogProbs[len(logProb.TopLogProbs)-1].LogProb
+	}
+
+	return logProb
+}
+
+type llamaCppCompletionResponse struct {
+	Content                 string               `json:"content"`
+	Stop                    bool                 `json:"stop"`
+	TokensEvaluated         int                  `json:"tokens_evaluated"`
+	TokensPredicted         int                  `json:"tokens_predicted"`
+	CompletionProbabilities []llamaCppTokenProbs `json:"completion_probabilities"`
+}
+
+func llamaCppInference(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*Message, error) {
+	// server runs prompts in parallel slots, so batch jobs are sent as separate requests
+	results, err := runJobs(inferenceEngine, batch, func(task *JobQueueTask) (*Message, error) {
+		return llamaCppCompletion(inferenceEngine, task, client)
+	})
+	if err != nil {
+		lg.Error().Err(err).
+			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
+	}
+
+	return results, err
+}
+
+func llamaCppCompletion(inferenceEngine *RemoteInferenceEngine, task *JobQueueTask, client *http.Client) (*Message, error) {
+	req := task.Req
+	prompt := req.RawPrompt
+	if len(req.Messages) > 0 {
+		var err error
+		prompt, err = llamaCppApplyTemplate(inferenceEngine, req.Messages, client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nPredict := -1
+	if req.MaxTokens > 0 {
+		nPredict = req.MaxTokens
+	}
+	stream := task.ResStream != nil && req.choices() == 1
+	// server generates a single choice per request
+	choices := make([]*Message, 0, req.choices())
+	promptTokens, completionTokens := 0, 0
+	for idx := 0; idx < req.choices(); idx++ {
+		cmd := &llamaCppCompletionRequest{
+			Prompt:           prompt,
+			NPredict:         nPredict,
+			Temperature:      req.Temperature,
+			TopK:             req.TopK,
+			TopP:             req.TopP,
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+			Seed:             req.Seed,
+			Stop:             req.StopTokens,
+			NProbs:           req.LogProbs,
+			JSONSchema:       req.JSONSchema,
+			Grammar:          req.Grammar,
+			Stream:           stream,
+			CachePrompt:      true,
+		}
+		if req.Seed != nil {
+			// choices generated with the same seed would be the same
+			seed := *req.Seed + idx
+			cmd.Seed = &seed
+		}
+
+		resp, err := postJSON(client, inferenceEngine, joinUrl(inferenceEngine.EndpointUrl, "/completion"), cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedResponse := &llamaCppCompletionResponse{}
+		if stream && resp.StatusCode == 200 {
+			parsedResponse, err = readLlamaCppStream(resp, task)
+		} else {
+			err = readJSONResponse(resp, parsedResponse)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		choice := &Message{
+			Role:    ChatRoleAssistant,
+			Content: parsedResponse.Content,
+		}
+		for probIdx := range parsedResponse.CompletionProbabilities {
+			choice.LogProbs = append(choice.LogProbs, parsedResponse.CompletionProbabilities[probIdx].toLogProb())
+		}
+		choices = append(choices, choice)
+		promptTokens += parsedResponse.TokensEvaluated
+		completionTokens += parsedResponse.TokensPredicted
+	}
+
+	results, err := groupChoices(choices, 1, req.choices())
+	if err != nil {
+		return nil, err
+	}
+	reportUsage(inferenceEngine, []*JobQueueTask{task}, results, promptTokens, completionTokens)
+	if task.Res != nil {
+		task.Res <- results[0]
+	}
+
+	return results[0], nil
+}
+
+// readLlamaCppStream - streamed chunks carry pieces of content, the last one has stop set and token counts
+func readLlamaCppStream(resp *http.Response, task *JobQueueTask) (*llamaCppCompletionResponse, error) {
+	content := &strings.Builder{}
+	result := &llamaCppCompletionResponse{}
+	err := readEventStream(resp.Body, func(data []byte) error {
+		chunk := &llamaCppCompletionResponse{}
+		if err := json.Unmarshal(data, chunk); err != nil {
+			return fmt.Errorf("error unmarshalling stream chunk: %v, %s", err, string(data))
+		}
+		result.CompletionProbabilities = append(result.CompletionProbabilities, chunk.CompletionProbabilities...)
+		if chunk.Stop {
+			result.TokensEvaluated = chunk.TokensEvaluated
+			result.TokensPredicted = chunk.TokensPredicted
+		}
+		if chunk.Content != "" {
+			content.WriteString(chunk.Content)
+			task.ResStream <- chunk.Content
+		}
+		return nil
+	})
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	result.Content = content.String()
+
+	return result, nil
+}
+
+// llamaCppApplyTemplate - formats chat messages with the chat template of the model
+func llamaCppApplyTemplate(inferenceEngine *RemoteInferenceEngine, messages []Message, client *http.Client) (string, error) {
+	request := struct {
+		Messages []ChatCompletionMessage `json:"messages"`
+	}{
+		Messages: makeChatCompletionMessages(messages),
+	}
+
+	resp, err := postJSON(client, inferenceEngine, joinUrl(inferenceEngine.EndpointUrl, "/apply-template"), request)
+	if err != nil {
+		return "", err
+	}
+
+	response := &struct {
+		Prompt string `json:"prompt"`
+	}{}
+	if err = readJSONResponse(resp, response); err != nil {
+		return "", fmt.Errorf("error applying chat template: %w", err)
+	}
+
+	return response.Prompt, nil
+}
+
+// fetchLlamaCppProps - server runs a single model, props also tell the context size
+func fetchLlamaCppProps(engine *RemoteInferenceEngine) error {
+	client := &http.Client{Timeout: InferenceTimeout}
+	props := &struct {
+		ModelPath                 string `json:"model_path"`
+		DefaultGenerationSettings struct {
+			Model string `json:"model"`
+			NCtx  int    `json:"n_ctx"`
+		} `json:"default_generation_settings"`
+	}{}
+
+	base := engine.EndpointUrl
+	if base == "" {
+		base = engine.EmbeddingsEndpointUrl
+	}
+	if err := getJSON(client, engine, joinUrl(base, "/props"), props); err != nil {
+		return fmt.Errorf("error reading props: %w", err)
+	}
+
+	model := props.ModelPath
+	if model == "" {
+		model = props.DefaultGenerationSettings.Model
+	}
+	engine.Models = []string{parseModelName(model)}
+	engine.ContextSize = props.DefaultGenerationSettings.NCtx
+
+	return nil
+}
+
+func llamaCppEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*vectors.Vector, error) {
+	model := inferenceEngine.modelFor(nil)
+	results := make([]*vectors.Vector, len(batch))
+	for idx, task := range batch {
+		resp, err := postJSON(client, inferenceEngine, joinUrl(inferenceEngine.EmbeddingsEndpointUrl, "/embedding"),
+			map[string]string{"content": task.Req.RawPrompt})
+		if err != nil {
+			return nil, err
+		}
+
+		response := json.RawMessage{}
+		if err = readJSONResponse(resp, &response); err != nil {
+			return nil, err
+		}
+		embedding, err := parseLlamaCppEmbedding(response)
+		if err != nil {
+			return nil, err
+		}
+
+		results[idx] = &vectors.Vector{
+			VecF64: embedding,
+			Model:  &model,
+		}
+	}
+
+	for idx, task := range batch {
+		if task.ResEmbeddings != nil {
+			task.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+// parseLlamaCppEmbedding - older servers return {"embedding": [...]}, newer ones return
+// [{"index": 0, "embedding": [[...]]}], with a single pooled embedding, or an embedding
+// per token if pooling is off, which isn't an embedding of the text
+func parseLlamaCppEmbedding(response json.RawMessage) ([]float64, error) {
+	single := &struct {
+		Embedding []float64 `json:"embedding"`
+	}{}
+	if err := json.Unmarshal(response, single); err == nil && len(single.Embedding) > 0 {
+		return single.Embedding, nil
+	}
+
+	list := make([]struct {
+		Embedding json.RawMessage `json:"embedding"`
+	}, 0)
+	if err := json.Unmarshal(response, &list); err == nil && len(list) > 0 {
+		flat := make([]float64, 0)
+		if err = json.Unmarshal(list[0].Embedding, &flat); err == nil && len(flat) > 0 {
+			return flat, nil
+		}
+		perToken := make([][]float64, 0)
+		if err = json.Unmarshal(list[0].Embedding, &perToken); err == nil && len(perToken) == 1 {
+			return perToken[0], nil
+		}
+		if len(perToken) > 1 {
+			return nil, fmt.Errorf("server returned embeddings of %d tokens, "+
+				"it has to be started with --pooling, e.g. --pooling mean", len(perToken))
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected embedding response: %s", string(response))
+}
@@ -0,0 +1,65 @@
+package engines
+
+import (
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLlamaCppProtocol(t *testing.T) {
+	var completion map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/props":
+			_, _ = w.Write([]byte(`{"model_path":"/models/TheBloke/Mistral-7B-GGUF/mistral-7b.Q6_K.gguf","default_generation_settings":{"n_ctx":8192}}`))
+		case "/apply-template":
+			_, _ = w.Write([]byte(`{"prompt":"<s>[INST] hello [/INST]"}`))
+		case "/completion":
+			_ = json.NewDecoder(r.Body).Decode(&completion)
+			_, _ = w.Write([]byte(`{"content":"hi","stop":true,"tokens_evaluated":7,"tokens_predicted":2}`))
+		case "/embedding":
+			_, _ = w.Write([]byte(`[{"index":0,"embedding":[[0.5,0.25]]}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, EmbeddingsEndpointUrl: server.URL, Protocol: "http-llamacpp"}
+	if err := discoverModels(engine); err != nil {
+		t.Fatalf("error discovering models: %v", err)
+	}
+	if len(engine.Models) != 1 || engine.Models[0] != "TheBloke/Mistral-7B-GGUF" || engine.ContextSize != 8192 {
+		t.Fatalf("unexpected model or context size: %v, %d", engine.Models, engine.ContextSize)
+	}
+
+	var info *StatisticsInfo
+	task := &JobQueueTask{Req: &GenerationSettings{
+		Messages:           []Message{{Role: ChatRoleUser, Content: "hello"}},
+		MaxTokens:          32,
+		StatisticsCallback: func(i *StatisticsInfo) { info = i },
+	}}
+	results, err := RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+	if err != nil || len(results) != 1 || results[0].Content != "hi" {
+		t.Fatalf("unexpected completion: %v, %v", results, err)
+	}
+	if completion["prompt"] != "<s>[INST] hello [/INST]" || completion["n_predict"] != 32.0 {
+		t.Fatalf("chat template or generation settings are not applied: %v", completion)
+	}
+	if info == nil || info.PromptTokens != 7 || info.TokensGenerated != 2 || info.Estimated {
+		t.Fatalf("usage reported by the server is not accounted: %+v", info)
+	}
+
+	vectors, err := RunEmbeddingsRequest(engine, []*JobQueueTask{{Req: &GenerationSettings{RawPrompt: "hello"}}})
+	if err != nil || len(vectors) != 1 || len(vectors[0].VecF64) != 2 || *vectors[0].Model != "TheBloke/Mistral-7B-GGUF" {
+		t.Fatalf("unexpected embeddings: %v, %v", vectors, err)
+	}
+}
+
+func TestLlamaCppEmbeddingsNeedPooling(t *testing.T) {
+	if _, err := parseLlamaCppEmbedding([]byte(`[{"index":0,"embedding":[[0.5,0.25],[0.1,0.2]]}]`)); err == nil {
+		t.Fatalf("expected error for embeddings per token")
+	}
+}
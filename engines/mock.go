@@ -0,0 +1,251 @@
+package engines
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMockEmbeddingsDims - dimensions of embeddings generated by mock engine, if not set
+const DefaultMockEmbeddingsDims = 32
+
+// MockResponse - canned response of the mock engine, for prompts matching the pattern
+type MockResponse struct {
+	Pattern string // regular expression, matched against raw prompt or contents of the messages
+	Content string
+	// Error - if set, jobs matching the pattern fail with this error, embeddings jobs included,
+	// streaming jobs get the content streamed before they fail
+	Error   string
+	Times   int           // response is used that many times, then next matching one is, 0 - no limit
+	Latency time.Duration // added to latency of the engine
+
+	pattern *regexp.Regexp
+	used    int
+}
+
+// MockEngine - in-process engine of http-mock protocol, which answers without network and
+// deterministically: prompts get the first matching response, others get a completion made of
+// prompt hash, embeddings are made of text hash as well
+type MockEngine struct {
+	Model          string // "mock" if not set
+	Responses      []*MockResponse
+	EmbeddingsDims int
+	Latency        time.Duration // of every job
+
+	lock    sync.Mutex
+	prompts []string
+}
+
+var mockEngines = make(map[string]*MockEngine)
+var mockEnginesLock = sync.RWMutex{}
+
+// RegisterMockEngine - nodes of http-mock protocol with the endpoint are served by the mock,
+// nodes with unregistered endpoints are served by a mock without responses
+func RegisterMockEngine(endpoint string, mock *MockEngine) error {
+	for _, response := range mock.Responses {
+		pattern, err := regexp.Compile(response.Pattern)
+		if err != nil {
+			return fmt.Errorf("error compiling mock response pattern %s: %w", response.Pattern, err)
+		}
+		response.pattern = pattern
+	}
+
+	mockEnginesLock.Lock()
+	mockEngines[endpoint] = mock
+	mockEnginesLock.Unlock()
+
+	return nil
+}
+
+func UnregisterMockEngine(endpoint string) {
+	mockEnginesLock.Lock()
+	delete(mockEngines, endpoint)
+	mockEnginesLock.Unlock()
+}
+
+func mockFor(engine *RemoteInferenceEngine) *MockEngine {
+	mockEnginesLock.RLock()
+	defer mockEnginesLock.RUnlock()
+
+	if mock, exists := mockEngines[engine.EndpointUrl]; exists && engine.EndpointUrl != "" {
+		return mock
+	}
+	if mock, exists := mockEngines[engine.EmbeddingsEndpointUrl]; exists && engine.EmbeddingsEndpointUrl != "" {
+		return mock
+	}
+
+	return &MockEngine{}
+}
+
+// Prompts - prompts of the jobs mock has run, probes of the engine are not included
+func (mock *MockEngine) Prompts() []string {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+
+	return append([]string(nil), mock.prompts...)
+}
+
+func (mock *MockEngine) model() string {
+	if mock.Model == "" {
+		return "mock"
+	}
+
+	return mock.Model
+}
+
+// respond - picks the response for the prompt, nil if none matches
+func (mock *MockEngine) respond(prompt string) *MockResponse {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+
+	if prompt == probeCompletionPrompt || prompt == probeEmbeddingsPrompt {
+		// probes shouldn't use up scripted responses
+		return nil
+	}
+	mock.prompts = append(mock.prompts, prompt)
+	for _, response := range mock.Responses {
+		if response.Times > 0 && response.used >= response.Times {
+			continue
+		}
+		if response.pattern == nil || response.pattern.MatchString(prompt) {
+			response.used++
+			return response
+		}
+	}
+
+	return nil
+}
+
+func (mock *MockEngine) wait(response *MockResponse) {
+	latency := mock.Latency
+	if response != nil {
+		latency += response.Latency
+	}
+	time.Sleep(latency)
+}
+
+func mockPrompt(req *GenerationSettings) string {
+	if len(req.Messages) == 0 {
+		return req.RawPrompt
+	}
+
+	contents := make([]string, 0, len(req.Messages))
+	for idx := range req.Messages {
+		contents = append(contents, req.Messages[idx].Content)
+	}
+
+	return strings.Join(contents, "\n")
+}
+
+func mockInference(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	mock := mockFor(inferenceEngine)
+	return runJobs(inferenceEngine, batch, func(task *JobQueueTask) (*Message, error) {
+		prompt := mockPrompt(task.Req)
+		response := mock.respond(prompt)
+		mock.wait(response)
+		if response != nil && response.Error != "" {
+			if task.ResStream != nil {
+				streamMockContent(task, response.Content)
+			}
+			return nil, errors.New(response.Error)
+		}
+
+		content := fmt.Sprintf("mock completion %s", textHash(prompt)[:16])
+		if response != nil {
+			content = response.Content
+		}
+		for _, stopToken := range task.Req.StopTokens {
+			if idx := strings.Index(content, stopToken); idx >= 0 && stopToken != "" {
+				content = content[:idx]
+			}
+		}
+
+		choices := make([]*Message, task.Req.choices())
+		for idx := range choices {
+			choices[idx] = &Message{Role: ChatRoleAssistant, Content: content}
+		}
+		results, err := groupChoices(choices, 1, task.Req.choices())
+		if err != nil {
+			return nil, err
+		}
+		if task.ResStream != nil {
+			streamMockContent(task, content)
+		}
+		reportUsage(inferenceEngine, []*JobQueueTask{task}, results, 0, 0)
+		if task.Res != nil {
+			task.Res <- results[0]
+		}
+
+		return results[0], nil
+	})
+}
+
+// streamMockContent - content is streamed word by word
+func streamMockContent(task *JobQueueTask, content string) {
+	for _, word := range strings.SplitAfter(content, " ") {
+		if word != "" {
+			task.ResStream <- word
+		}
+	}
+}
+
+func mockEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	mock := mockFor(inferenceEngine)
+	model := mock.model()
+	dims := mock.EmbeddingsDims
+	if dims <= 0 {
+		dims = DefaultMockEmbeddingsDims
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	for idx, task := range batch {
+		response := mock.respond(task.Req.RawPrompt)
+		mock.wait(response)
+		if response != nil && response.Error != "" {
+			return nil, errors.New(response.Error)
+		}
+
+		results[idx] = &vectors.Vector{
+			VecF64: hashEmbedding(task.Req.RawPrompt, dims),
+			Model:  &model,
+		}
+	}
+
+	for idx, task := range batch {
+		if task.ResEmbeddings != nil {
+			task.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashEmbedding - unit vector made of hashes of the text, same texts get the same vectors
+func hashEmbedding(text string, dims int) []float64 {
+	embedding := make([]float64, dims)
+	norm := 0.0
+	for idx := range embedding {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", idx, text)))
+		embedding[idx] = float64(binary.BigEndian.Uint32(sum[:4]))/math.MaxUint32*2 - 1
+		norm += embedding[idx] * embedding[idx]
+	}
+	norm = math.Sqrt(norm)
+	for idx := range embedding {
+		embedding[idx] /= norm
+	}
+
+	return embedding
+}
@@ -0,0 +1,44 @@
+package engines
+
+import (
+	"github.com/rs/zerolog"
+	"strings"
+	"testing"
+)
+
+func TestMockEngine(t *testing.T) {
+	mock := &MockEngine{Model: "scripted", Responses: []*MockResponse{
+		{Pattern: `^fail`, Error: "scripted failure"},
+		{Pattern: `weather`, Content: "sunny and warm"},
+	}}
+	if err := RegisterMockEngine("mock://unit", mock); err != nil {
+		t.Fatalf("error registering mock: %v", err)
+	}
+	defer UnregisterMockEngine("mock://unit")
+
+	engine := &RemoteInferenceEngine{EndpointUrl: "mock://unit", Protocol: "http-mock", MaxBatchSize: 2}
+	if err := discoverModels(engine); err != nil || engine.Models[0] != "scripted" {
+		t.Fatalf("unexpected models: %v, %v", engine.Models, err)
+	}
+
+	stream := make(chan string, 8)
+	batch := []*JobQueueTask{
+		{Req: &GenerationSettings{Messages: []Message{{Role: ChatRoleUser, Content: "what's the weather?"}}}, ResStream: stream},
+		{Req: &GenerationSettings{RawPrompt: "fail me"}},
+		{Req: &GenerationSettings{RawPrompt: "anything else"}},
+	}
+	results, err := RunCompletionRequest(zerolog.Nop(), engine, batch)
+	if !Failed(err, 1) || Failed(err, 0) || Failed(err, 2) || !strings.Contains(err.Error(), "scripted failure") {
+		t.Fatalf("expected only the second job to fail, got %v", err)
+	}
+	if results[0].Content != "sunny and warm" || len(stream) != 3 {
+		t.Fatalf("unexpected scripted completion: %q, %d pieces streamed", results[0].Content, len(stream))
+	}
+	again, _ := RunCompletionRequest(zerolog.Nop(), engine, batch[2:])
+	if !strings.HasPrefix(results[2].Content, "mock completion ") || again[0].Content != results[2].Content {
+		t.Fatalf("expected deterministic completion of unmatched prompt, got %q and %q", results[2].Content, again[0].Content)
+	}
+	if len(mock.Prompts()) != 4 {
+		t.Fatalf("unexpected prompts recorded: %v", mock.Prompts())
+	}
+}
@@ -0,0 +1,240 @@
+package engines
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	"github.com/rs/zerolog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// native API of Ollama: /api/generate, /api/chat, /api/embeddings and /api/tags,
+// node endpoints are base urls of the server, like http://localhost:11434
+
+type ollamaOptions struct {
+	Temperature      float32  `json:"temperature"`
+	TopK             int      `json:"top_k,omitempty"`
+	TopP             float32  `json:"top_p,omitempty"`
+	NumPredict       int      `json:"num_predict,omitempty"`
+	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+}
+
+type ollamaRequest struct {
+	Model    string                  `json:"model"`
+	Prompt   string                  `json:"prompt,omitempty"`
+	Raw      bool                    `json:"raw,omitempty"` // prompt is formatted already
+	Messages []ChatCompletionMessage `json:"messages,omitempty"`
+	Format   json.RawMessage         `json:"format,omitempty"` // json schema
+	Stream   bool                    `json:"stream"`           // Ollama streams, unless told otherwise
+	Options  ollamaOptions           `json:"options"`
+}
+
+// ollamaResponse - /api/generate returns response, /api/chat returns message,
+// streamed chunks are the same, the last one has done set and token counts
+type ollamaResponse struct {
+	Response string `json:"response"`
+	Message  *struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool `json:"done"`
+	PromptEvalCount int  `json:"prompt_eval_count"`
+	EvalCount       int  `json:"eval_count"`
+}
+
+func (r *ollamaResponse) content() string {
+	if r.Message != nil {
+		return r.Message.Content
+	}
+
+	return r.Response
+}
+
+func ollamaInference(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*Message, error) {
+	// Ollama has no batched requests, it runs them in parallel up to OLLAMA_NUM_PARALLEL
+	results, err := runJobs(inferenceEngine, batch, func(task *JobQueueTask) (*Message, error) {
+		return ollamaCompletion(inferenceEngine, task, client)
+	})
+	if err != nil {
+		lg.Error().Err(err).
+			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
+	}
+
+	return results, err
+}
+
+func ollamaCompletion(inferenceEngine *RemoteInferenceEngine, task *JobQueueTask, client *http.Client) (*Message, error) {
+	req := task.Req
+	if req.Grammar != "" {
+		return nil, fmt.Errorf("grammar constraints are not supported by %s", inferenceEngine.Protocol)
+	}
+	if req.LogProbs > 0 {
+		return nil, fmt.Errorf("log probabilities are not supported by %s", inferenceEngine.Protocol)
+	}
+
+	cmd := &ollamaRequest{
+		Model:  inferenceEngine.modelFor(req),
+		Format: req.JSONSchema,
+		Stream: task.ResStream != nil && req.choices() == 1,
+		Options: ollamaOptions{
+			Temperature:      req.Temperature,
+			TopK:             req.TopK,
+			TopP:             req.TopP,
+			NumPredict:       req.maxTokens(),
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+			Stop:             req.StopTokens,
+		},
+	}
+	path := "/api/generate"
+	if len(req.Messages) > 0 {
+		path = "/api/chat"
+		cmd.Messages = makeChatCompletionMessages(req.Messages)
+	} else {
+		cmd.Prompt = req.RawPrompt
+		cmd.Raw = true
+	}
+
+	// server generates a single choice per request
+	choices := make([]*Message, 0, req.choices())
+	promptTokens, completionTokens := 0, 0
+	for idx := 0; idx < req.choices(); idx++ {
+		if req.Seed != nil {
+			// choices generated with the same seed would be the same
+			seed := *req.Seed + idx
+			cmd.Options.Seed = &seed
+		}
+
+		resp, err := postJSON(client, inferenceEngine, joinUrl(inferenceEngine.EndpointUrl, path), cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedResponse := &ollamaResponse{}
+		if cmd.Stream && resp.StatusCode == 200 {
+			parsedResponse, err = readOllamaStream(resp, task)
+		} else {
+			err = readJSONResponse(resp, parsedResponse)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		choices = append(choices, &Message{
+			Role:    ChatRoleAssistant,
+			Content: parsedResponse.content(),
+		})
+		promptTokens += parsedResponse.PromptEvalCount
+		completionTokens += parsedResponse.EvalCount
+	}
+
+	results, err := groupChoices(choices, 1, req.choices())
+	if err != nil {
+		return nil, err
+	}
+	reportUsage(inferenceEngine, []*JobQueueTask{task}, results, promptTokens, completionTokens)
+	if task.Res != nil {
+		task.Res <- results[0]
+	}
+
+	return results[0], nil
+}
+
+func readOllamaStream(resp *http.Response, task *JobQueueTask) (*ollamaResponse, error) {
+	content := &strings.Builder{}
+	result := &ollamaResponse{}
+	err := readJSONLines(resp.Body, func(data []byte) error {
+		chunk := &ollamaResponse{}
+		if err := json.Unmarshal(data, chunk); err != nil {
+			return fmt.Errorf("error unmarshalling stream chunk: %v, %s", err, string(data))
+		}
+		if chunk.Done {
+			result.PromptEvalCount = chunk.PromptEvalCount
+			result.EvalCount = chunk.EvalCount
+		}
+		if delta := chunk.content(); delta != "" {
+			content.WriteString(delta)
+			task.ResStream <- delta
+		}
+		return nil
+	})
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	result.Response = content.String()
+
+	return result, nil
+}
+
+// fetchOllamaModels - Ollama loads any of the pulled models on demand, so all of them are served;
+// nodes serving embeddings only list embedding models first, as the first model is the default one
+func fetchOllamaModels(engine *RemoteInferenceEngine) error {
+	client := &http.Client{Timeout: InferenceTimeout}
+	tags := &struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}{}
+
+	base := engine.EndpointUrl
+	if base == "" {
+		base = engine.EmbeddingsEndpointUrl
+	}
+	if err := getJSON(client, engine, joinUrl(base, "/api/tags"), tags); err != nil {
+		return fmt.Errorf("error reading tags: %w", err)
+	}
+	if len(tags.Models) == 0 {
+		return fmt.Errorf("no models pulled")
+	}
+
+	models := make([]string, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		models = append(models, model.Name)
+	}
+	if engine.EndpointUrl == "" {
+		sort.SliceStable(models, func(i, j int) bool {
+			return strings.Contains(models[i], "embed") && !strings.Contains(models[j], "embed")
+		})
+	}
+	engine.Models = models
+
+	return nil
+}
+
+func ollamaEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*vectors.Vector, error) {
+	results := make([]*vectors.Vector, len(batch))
+	for idx, task := range batch {
+		model := inferenceEngine.modelFor(task.Req)
+		resp, err := postJSON(client, inferenceEngine, joinUrl(inferenceEngine.EmbeddingsEndpointUrl, "/api/embeddings"),
+			map[string]string{"model": model, "prompt": task.Req.RawPrompt})
+		if err != nil {
+			return nil, err
+		}
+
+		response := &struct {
+			Embedding []float64 `json:"embedding"`
+		}{}
+		if err = readJSONResponse(resp, response); err != nil {
+			return nil, err
+		}
+
+		results[idx] = &vectors.Vector{
+			VecF64: response.Embedding,
+			Model:  &model,
+		}
+	}
+
+	for idx, task := range batch {
+		if task.ResEmbeddings != nil {
+			task.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,57 @@
+package engines
+
+import (
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllamaProtocol(t *testing.T) {
+	var chat map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = w.Write([]byte(`{"models":[{"name":"llama3:8b"},{"name":"nomic-embed-text:latest"}]}`))
+		case "/api/chat":
+			_ = json.NewDecoder(r.Body).Decode(&chat)
+			_, _ = w.Write([]byte("{\"message\":{\"role\":\"assistant\",\"content\":\"he\"},\"done\":false}\n" +
+				"{\"message\":{\"role\":\"assistant\",\"content\":\"llo\"},\"done\":false}\n" +
+				"{\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true,\"prompt_eval_count\":5,\"eval_count\":2}\n"))
+		case "/api/embeddings":
+			_, _ = w.Write([]byte(`{"embedding":[0.1,0.2,0.3]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: "http-ollama"}
+	if err := discoverModels(engine); err != nil || len(engine.Models) != 2 || engine.Models[0] != "llama3:8b" {
+		t.Fatalf("unexpected models: %v, %v", engine.Models, err)
+	}
+
+	stream := make(chan string, 16)
+	task := &JobQueueTask{
+		Req:       &GenerationSettings{Messages: []Message{{Role: ChatRoleUser, Content: "hi"}}, Temperature: 0.2},
+		ResStream: stream,
+	}
+	results, err := RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+	if err != nil || len(results) != 1 || results[0].Content != "hello" || len(stream) != 2 {
+		t.Fatalf("unexpected completion: %v, %v", results, err)
+	}
+	if chat["model"] != "llama3:8b" || chat["stream"] != true {
+		t.Fatalf("unexpected chat request: %v", chat)
+	}
+
+	// embeddings only node prefers embedding models
+	embeddings := &RemoteInferenceEngine{EmbeddingsEndpointUrl: server.URL, Protocol: "http-ollama"}
+	if err = discoverModels(embeddings); err != nil || embeddings.Models[0] != "nomic-embed-text:latest" {
+		t.Fatalf("unexpected models of embeddings node: %v, %v", embeddings.Models, err)
+	}
+	vectors, err := RunEmbeddingsRequest(embeddings, []*JobQueueTask{{Req: &GenerationSettings{RawPrompt: "hello"}}})
+	if err != nil || len(vectors) != 1 || len(vectors[0].VecF64) != 3 || *vectors[0].Model != "nomic-embed-text:latest" {
+		t.Fatalf("unexpected embeddings: %v, %v", vectors, err)
+	}
+}
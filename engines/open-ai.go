@@ -24,6 +24,31 @@ func guessModelsEndpoint(engine *RemoteInferenceEngine) string {
 	return engine.EndpointUrl
 }
 
+// samplingParameters - parameters of /completions call, top_k and guided decoding are vLLM extensions
+type samplingParameters struct {
+	TopP             float32         `json:"top_p,omitempty"`
+	TopK             int             `json:"top_k,omitempty"`
+	PresencePenalty  float32         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32         `json:"frequency_penalty,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	LogProbs         int             `json:"logprobs,omitempty"`
+	GuidedJSON       json.RawMessage `json:"guided_json,omitempty"`
+	GuidedGrammar    string          `json:"guided_grammar,omitempty"`
+}
+
+func newSamplingParameters(req *GenerationSettings) samplingParameters {
+	return samplingParameters{
+		TopP:             req.TopP,
+		TopK:             req.TopK,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		Seed:             req.Seed,
+		LogProbs:         req.LogProbs,
+		GuidedJSON:       req.JSONSchema,
+		GuidedGrammar:    req.Grammar,
+	}
+}
+
 type commandList struct {
 	Prompts     []string `json:"prompt"`
 	N           int      `json:"n"`
@@ -32,6 +57,8 @@ type commandList struct {
 	Temperature float32  `json:"temperature"`
 	Model       string   `json:"model"`
 	BestOf      int      `json:"best_of"`
+	Stream      bool     `json:"stream,omitempty"`
+	samplingParameters
 }
 
 type commandSingle struct {
@@ -42,49 +69,121 @@ type commandSingle struct {
 	Temperature float32  `json:"temperature"`
 	Model       string   `json:"model"`
 	BestOf      int      `json:"best_of"`
+	Stream      bool     `json:"stream,omitempty"`
+	samplingParameters
+}
+
+// chatCommand - chat completion request along with vLLM extensions
+type chatCommand struct {
+	*ChatCompletionRequest
+	TopK          int    `json:"top_k,omitempty"`
+	GuidedGrammar string `json:"guided_grammar,omitempty"`
 }
 
 func openAICompatibleInference(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*Message, error) {
 	if len(inferenceEngine.Models) == 0 {
 		err := fetchInferenceEngineModels(inferenceEngine)
-		if err != nil {
+		if err != nil || len(inferenceEngine.Models) == 0 {
+			// server picks the model then
 			inferenceEngine.Models = []string{""}
 		}
-		if len(inferenceEngine.Models) > 0 {
-			inferenceEngine.Models[0] = "mistral-large:latest"
-			lg.Info().Msgf("[%s] using model %s ", inferenceEngine.EndpointUrl, inferenceEngine.Models[0])
-		}
+		lg.Info().Msgf("[%s] using model %s ", inferenceEngine.EndpointUrl, inferenceEngine.Models[0])
 	}
 
-	var stopTokens = []string{"<|im_end|>", "<|im_start|>"}
-	if batch[0].Req.StopTokens != nil {
-		stopTokens = append(stopTokens, batch[0].Req.StopTokens...)
-	}
+	// raw prompts with the same settings go to a single /completions call,
+	// chat messages can't be batched, every job is a separate call
+	calls := make([][]int, 0, len(batch))
+	groups := make(map[string]int)
+	for idx, task := range batch {
+		if len(task.Req.Messages) > 0 {
+			calls = append(calls, []int{idx})
+			continue
+		}
 
-	if batch[0].Req.BestOf == 0 {
-		batch[0].Req.BestOf = 1
+		key := completionSettingsKey(inferenceEngine, task.Req)
+		callIdx, exists := groups[key]
+		if !exists {
+			callIdx = len(calls)
+			groups[key] = callIdx
+			calls = append(calls, nil)
+		}
+		calls[callIdx] = append(calls[callIdx], idx)
 	}
 
-	if len(batch) == 0 {
-		return nil, nil
-	}
+	results := make([]*Message, len(batch))
+	errs := make([]error, len(batch))
+	runParallel(inferenceEngine, len(calls), func(callIdx int) {
+		jobs := calls[callIdx]
+		if len(batch[jobs[0]].Req.Messages) > 0 {
+			results[jobs[0]], errs[jobs[0]] = openAIChatCompletion(lg, inferenceEngine, batch[jobs[0]], client)
+			return
+		}
 
-	if len(batch[0].Req.Messages) == 0 {
-		return doFullContextCompletion(lg, inferenceEngine, batch, client, stopTokens)
-	}
+		group := make([]*JobQueueTask, len(jobs))
+		for idx, jobIdx := range jobs {
+			group[idx] = batch[jobIdx]
+		}
+		groupResults, err := doFullContextCompletion(lg, inferenceEngine, group, client)
+		for idx, jobIdx := range jobs {
+			if err != nil {
+				errs[jobIdx] = err
+			} else {
+				results[jobIdx] = groupResults[idx]
+			}
+		}
+	})
+
+	return results, batchError(errs)
+}
+
+// completionSettingsKey - prompts can share a /completions call, if their keys are the same
+func completionSettingsKey(inferenceEngine *RemoteInferenceEngine, req *GenerationSettings) string {
+	key, _ := json.Marshal(&commandSingle{
+		N:                  req.choices(),
+		Max:                req.maxTokens(),
+		Stop:               req.StopTokens,
+		Temperature:        req.Temperature,
+		BestOf:             max(req.BestOf, req.choices()),
+		Model:              inferenceEngine.modelFor(req),
+		samplingParameters: newSamplingParameters(req),
+	})
+
+	return string(key)
+}
 
-	// need to build chat completion
+func openAIChatCompletion(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, task *JobQueueTask, client *http.Client) (*Message, error) {
+	req := task.Req
 	request := &ChatCompletionRequest{
-		Model:       inferenceEngine.Models[0],
-		Messages:    makeChatCompletionMessages(batch[0].Req.Messages),
-		MaxTokens:   16384,
-		Temperature: batch[0].Req.Temperature,
-		N:           1,
-		Stream:      false,
-		Stop:        batch[0].Req.StopTokens,
+		Model:            inferenceEngine.modelFor(req),
+		Messages:         makeChatCompletionMessages(req.Messages),
+		MaxTokens:        req.maxTokens(),
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		N:                req.choices(),
+		Stream:           task.ResStream != nil && req.choices() == 1,
+		Stop:             req.StopTokens,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		Seed:             req.Seed,
+		LogProbs:         req.LogProbs > 0,
+		TopLogProbs:      req.LogProbs,
+	}
+	if len(req.JSONSchema) > 0 {
+		request.ResponseFormat = &ChatCompletionResponseFormat{
+			Type: ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &ChatCompletionResponseFormatJSONSchema{
+				Name:   "response",
+				Schema: req.JSONSchema,
+				Strict: true,
+			},
+		}
 	}
 
-	commandBuffer, err := json.Marshal(request)
+	commandBuffer, err := json.Marshal(&chatCommand{
+		ChatCompletionRequest: request,
+		TopK:                  req.TopK,
+		GuidedGrammar:         req.Grammar,
+	})
 	if err != nil {
 		lg.Fatal().Err(err).Msg("error marshaling command")
 	}
@@ -100,6 +199,45 @@ func openAICompatibleInference(lg zerolog.Logger, inferenceEngine *RemoteInferen
 		return nil, err
 	}
 
+	if request.Stream && resp.StatusCode == 200 {
+		content := &strings.Builder{}
+		usage := &Usage{}
+		err = readEventStream(resp.Body, func(data []byte) error {
+			chunk := &ChatCompletionStreamResponse{}
+			if err := json.Unmarshal(data, chunk); err != nil {
+				return fmt.Errorf("error unmarshalling stream chunk: %v, %s", err, string(data))
+			}
+			if chunk.Usage != nil {
+				// only sent by some servers, along with the last chunk
+				usage = chunk.Usage
+			}
+			for _, choice := range chunk.Choices {
+				if choice.Delta.Content == "" {
+					continue
+				}
+				content.WriteString(choice.Delta.Content)
+				task.ResStream <- choice.Delta.Content
+			}
+			return nil
+		})
+		_ = resp.Body.Close()
+		if err != nil {
+			lg.Error().Err(err).
+				Msgf("error reading response stream: %v", err)
+			return nil, err
+		}
+
+		results := []*Message{{
+			Role:    ChatRoleAssistant,
+			Content: content.String(),
+		}}
+		reportUsage(inferenceEngine, []*JobQueueTask{task}, results, usage.PromptTokens, usage.CompletionTokens)
+		if task.Res != nil {
+			task.Res <- results[0]
+		}
+		return results[0], nil
+	}
+
 	// read resp.Body to result
 	result, err := io.ReadAll(resp.Body)
 	_ = resp.Body.Close()
@@ -111,7 +249,7 @@ func openAICompatibleInference(lg zerolog.Logger, inferenceEngine *RemoteInferen
 	}
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("http code is %d, err: %v", resp.StatusCode, string(result))
+		err = responseError(resp, result)
 		lg.Error().Err(err).
 			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
 		return nil, err
@@ -125,22 +263,35 @@ func openAICompatibleInference(lg zerolog.Logger, inferenceEngine *RemoteInferen
 		return nil, err
 	}
 
-	results := make([]*Message, 1)
-
-	results[0] = &Message{
-		Role:    ChatRole(parsedResponse.Choices[0].Message.Role),
-		Content: parsedResponse.Choices[0].Message.Content,
+	choices := make([]*Message, 0, len(parsedResponse.Choices))
+	for _, choice := range parsedResponse.Choices {
+		message := &Message{
+			Role:    ChatRole(choice.Message.Role),
+			Content: choice.Message.Content,
+		}
+		if choice.LogProbs != nil {
+			message.LogProbs = choice.LogProbs.Content
+		}
+		choices = append(choices, message)
+	}
+	results, err := groupChoices(choices, 1, req.choices())
+	if err != nil {
+		lg.Error().Err(err).
+			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
+		return nil, err
 	}
-	if batch[0].Res != nil {
-		batch[0].Res <- results[0]
+	reportUsage(inferenceEngine, []*JobQueueTask{task}, results, parsedResponse.Usage.PromptTokens, parsedResponse.Usage.CompletionTokens)
+	if task.Res != nil {
+		task.Res <- results[0]
 	}
-	return results, nil
+	return results[0], nil
 }
 
 func makeChatCompletionMessages(messages []Message) []ChatCompletionMessage {
 	result := make([]ChatCompletionMessage, 0, len(messages))
 
-	for _, msg := range messages {
+	for idx := range messages {
+		msg := &messages[idx]
 		result = append(result, ChatCompletionMessage{
 			Role:    string(msg.Role),
 			Content: string(msg.Content),
@@ -150,25 +301,36 @@ func makeChatCompletionMessages(messages []Message) []ChatCompletionMessage {
 	return result
 }
 
-func doFullContextCompletion(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client, stopTokens []string) ([]*Message, error) {
-	// completions
-
+// doFullContextCompletion - runs prompts of the batch in a single /completions call,
+// prompts are expected to have the same settings
+func doFullContextCompletion(lg zerolog.Logger, inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*Message, error) {
+	req := batch[0].Req
+	var stopTokens = []string{"<|im_end|>", "<|im_start|>"}
+	if req.StopTokens != nil {
+		stopTokens = append(stopTokens, req.StopTokens...)
+	}
 	promptBodies := make([]string, len(batch))
+	stream := false
 	for i, b := range batch {
 		promptBodies[i] = b.Req.RawPrompt
+		stream = stream || b.ResStream != nil
 	}
+	// choices of several prompts can't be told apart in the stream
+	stream = stream && req.choices() == 1
 
 	var commandBuffer []byte
 	var err error
 	if len(batch) > 1 {
 		cmd := &commandList{
-			Prompts:     promptBodies,
-			N:           1,
-			Max:         16384,
-			Stop:        stopTokens,
-			Temperature: batch[0].Req.Temperature,
-			BestOf:      batch[0].Req.BestOf,
-			Model:       inferenceEngine.Models[0],
+			Prompts:            promptBodies,
+			N:                  req.choices(),
+			Max:                req.maxTokens(),
+			Stop:               stopTokens,
+			Temperature:        req.Temperature,
+			BestOf:             max(req.BestOf, req.choices()),
+			Model:              inferenceEngine.modelFor(req),
+			Stream:             stream,
+			samplingParameters: newSamplingParameters(req),
 		}
 
 		commandBuffer, err = json.Marshal(cmd)
@@ -177,13 +339,15 @@ func doFullContextCompletion(lg zerolog.Logger, inferenceEngine *RemoteInference
 		}
 	} else {
 		cmd := &commandSingle{
-			Prompts:     promptBodies[0],
-			N:           1,
-			Max:         16384,
-			Stop:        stopTokens,
-			Temperature: batch[0].Req.Temperature,
-			BestOf:      batch[0].Req.BestOf,
-			Model:       inferenceEngine.Models[0],
+			Prompts:            promptBodies[0],
+			N:                  req.choices(),
+			Max:                req.maxTokens(),
+			Stop:               stopTokens,
+			Temperature:        req.Temperature,
+			BestOf:             max(req.BestOf, req.choices()),
+			Model:              inferenceEngine.modelFor(req),
+			Stream:             stream,
+			samplingParameters: newSamplingParameters(req),
 		}
 
 		commandBuffer, err = json.Marshal(cmd)
@@ -204,6 +368,18 @@ func doFullContextCompletion(lg zerolog.Logger, inferenceEngine *RemoteInference
 		return nil, err
 	}
 
+	if stream && resp.StatusCode == 200 {
+		results, err := readCompletionStream(inferenceEngine, resp.Body, batch)
+		_ = resp.Body.Close()
+		if err != nil {
+			lg.Error().Err(err).
+				Msgf("error reading response stream: %v", err)
+			return nil, err
+		}
+
+		return results, nil
+	}
+
 	// read resp.Body to result
 	result, err := io.ReadAll(resp.Body)
 	_ = resp.Body.Close()
@@ -215,7 +391,7 @@ func doFullContextCompletion(lg zerolog.Logger, inferenceEngine *RemoteInference
 	}
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("http code is %d, err: %v", resp.StatusCode, string(result))
+		err = responseError(resp, result)
 		lg.Error().Err(err).
 			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
 		return nil, err
@@ -224,7 +400,8 @@ func doFullContextCompletion(lg zerolog.Logger, inferenceEngine *RemoteInference
 	// now, let us parse all the response in choices
 	type response struct {
 		Choices []struct {
-			Text string `json:"text"`
+			Text     string              `json:"text"`
+			LogProbs *CompletionLogProbs `json:"logprobs,omitempty"`
 		} `json:"choices"`
 		Usage struct {
 			PromptTokens     int `json:"prompt_tokens"`
@@ -240,13 +417,79 @@ func doFullContextCompletion(lg zerolog.Logger, inferenceEngine *RemoteInference
 		return nil, err
 	}
 
-	results := make([]*Message, len(batch))
+	choices := make([]*Message, 0, len(parsedResponse.Choices))
+	for _, choice := range parsedResponse.Choices {
+		choices = append(choices, &Message{
+			Role:     ChatRoleAssistant,
+			Content:  choice.Text,
+			LogProbs: choice.LogProbs.toLogProbs(),
+		})
+	}
+	results, err := groupChoices(choices, len(batch), req.choices())
+	if err != nil {
+		lg.Error().Err(err).
+			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
+		return nil, err
+	}
+	reportUsage(inferenceEngine, batch, results, parsedResponse.Usage.PromptTokens, parsedResponse.Usage.CompletionTokens)
 	// ok now each choice goes to its caller
 	for idx, job := range batch {
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+// readCompletionStream - collects streamed choices of /completions call,
+// choice index is the index of the prompt in the batch
+func readCompletionStream(inferenceEngine *RemoteInferenceEngine, body io.Reader, batch []*JobQueueTask) ([]*Message, error) {
+	type streamChunk struct {
+		Choices []struct {
+			Index int    `json:"index"`
+			Text  string `json:"text"`
+		} `json:"choices"`
+		Usage *Usage `json:"usage,omitempty"`
+	}
+
+	contents := make([]strings.Builder, len(batch))
+	usage := &Usage{}
+	err := readEventStream(body, func(data []byte) error {
+		chunk := &streamChunk{}
+		if err := json.Unmarshal(data, chunk); err != nil {
+			return fmt.Errorf("error unmarshalling stream chunk: %v, %s", err, string(data))
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index < 0 || choice.Index >= len(batch) {
+				return fmt.Errorf("stream chunk choice index %d is out of batch range", choice.Index)
+			}
+			if choice.Text == "" {
+				continue
+			}
+			contents[choice.Index].WriteString(choice.Text)
+			if batch[choice.Index].ResStream != nil {
+				batch[choice.Index].ResStream <- choice.Text
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Message, len(batch))
+	for idx := range batch {
 		results[idx] = &Message{
 			Role:    ChatRoleAssistant,
-			Content: parsedResponse.Choices[idx].Text,
+			Content: contents[idx].String(),
 		}
+	}
+	reportUsage(inferenceEngine, batch, results, usage.PromptTokens, usage.CompletionTokens)
+	for idx, job := range batch {
 		if job.Res != nil {
 			job.Res <- results[idx]
 		}
@@ -0,0 +1,115 @@
+package engines
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestOpenAIGenerationSettings(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		_, _ = w.Write([]byte(`{"choices":[
+			{"index":0,"message":{"role":"assistant","content":"{}"},"logprobs":{"content":[{"token":"{}","logprob":-0.5,"top_logprobs":[]}]}},
+			{"index":1,"message":{"role":"assistant","content":"{\"a\":1}"}}
+		],"usage":{"prompt_tokens":10,"completion_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	seed := 42
+	task := &JobQueueTask{Req: &GenerationSettings{
+		Messages:   []Message{{Role: ChatRoleUser, Content: "hello"}},
+		MaxTokens:  64,
+		TopP:       0.5,
+		TopK:       20,
+		Seed:       &seed,
+		LogProbs:   2,
+		N:          2,
+		JSONSchema: json.RawMessage(`{"type":"object"}`),
+	}}
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: "http-openai", Models: []string{"model"}}
+	results, err := RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+	if err != nil {
+		t.Fatalf("error running completion: %v", err)
+	}
+
+	for name, expected := range map[string]interface{}{
+		"max_tokens": 64.0, "top_p": 0.5, "top_k": 20.0, "seed": 42.0, "n": 2.0, "logprobs": true, "top_logprobs": 2.0,
+	} {
+		if received[name] != expected {
+			t.Fatalf("expected %s to be %v, got %v", name, expected, received[name])
+		}
+	}
+	if format, ok := received["response_format"].(map[string]interface{}); !ok || format["type"] != "json_schema" {
+		t.Fatalf("json schema is not passed as response format: %v", received["response_format"])
+	}
+
+	if len(results) != 1 || len(results[0].Alternatives) != 1 || results[0].Alternatives[0].Content != `{"a":1}` {
+		t.Fatalf("expected the second choice to be an alternative, got %+v", results)
+	}
+	if len(results[0].LogProbs) != 1 || results[0].LogProbs[0].LogProb != -0.5 {
+		t.Fatalf("log probabilities are not returned: %+v", results[0].LogProbs)
+	}
+}
+
+func TestOpenAIBatchedInference(t *testing.T) {
+	var lock sync.Mutex
+	prompts := make([][]interface{}, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var received map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		if r.URL.Path == "/chat/completions" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		batch, ok := received["prompt"].([]interface{})
+		if !ok {
+			batch = []interface{}{received["prompt"]}
+		}
+		lock.Lock()
+		prompts = append(prompts, batch)
+		lock.Unlock()
+
+		choices := make([]map[string]interface{}, 0, len(batch))
+		for _, prompt := range batch {
+			choices = append(choices, map[string]interface{}{"text": fmt.Sprintf("%v %v", prompt, received["temperature"])})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"choices": choices})
+	}))
+	defer server.Close()
+
+	batch := []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "a", Temperature: 0.5}, Res: make(chan *Message, 1)},
+		{Req: &GenerationSettings{Messages: []Message{{Role: ChatRoleUser, Content: "c"}}}, Res: make(chan *Message, 1)},
+		{Req: &GenerationSettings{RawPrompt: "b", Temperature: 1}, Res: make(chan *Message, 1)},
+		{Req: &GenerationSettings{RawPrompt: "d", Temperature: 0.5}, Res: make(chan *Message, 1)},
+	}
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: "http-openai", Models: []string{"model"}, MaxBatchSize: 4}
+	results, err := RunCompletionRequest(zerolog.Nop(), engine, batch)
+
+	if len(prompts) != 2 {
+		t.Fatalf("expected prompts with the same settings to share a call, got calls %v", prompts)
+	}
+	for idx, expected := range []string{"a 0.5", "", "b 1", "d 0.5"} {
+		if Failed(err, idx) != (expected == "") {
+			t.Fatalf("job %d failure is not reported correctly: %v", idx, err)
+		}
+		if expected == "" {
+			continue
+		}
+		if results[idx] == nil || results[idx].Content != expected {
+			t.Fatalf("job %d expected %q, got %+v", idx, expected, results[idx])
+		}
+		if result := <-batch[idx].Res; result.Content != expected {
+			t.Fatalf("job %d received %q", idx, result.Content)
+		}
+	}
+}
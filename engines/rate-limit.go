@@ -0,0 +1,69 @@
+package engines
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitError - engine refused the request because of its rate limits or overload,
+// it's not a failure of the node, compute router pauses the node and retries the jobs
+type RateLimitError struct {
+	StatusCode int
+	RetryAfter time.Duration // 0 - engine didn't tell
+	Message    string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, http code is %d, retry after %s, err: %s", e.StatusCode, e.RetryAfter, e.Message)
+}
+
+// isRateLimitStatus - 429 is sent by all providers, 529 is sent by Anthropic when it's overloaded
+func isRateLimitStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == 529
+}
+
+// responseError - error of the response with status other than 200
+func responseError(resp *http.Response, body []byte) error {
+	if isRateLimitStatus(resp.StatusCode) {
+		return &RateLimitError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: retryAfter(resp.Header, body),
+			Message:    string(body),
+		}
+	}
+
+	return fmt.Errorf("http code is %d, err: %v", resp.StatusCode, string(body))
+}
+
+// retryAfter - reads Retry-After header, in seconds or as a date, or retryDelay
+// of google.rpc.RetryInfo, which Gemini sends in the body
+func retryAfter(header http.Header, body []byte) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return max(time.Until(at), 0)
+		}
+	}
+
+	googleError := &struct {
+		Error struct {
+			Details []struct {
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}{}
+	if json.Unmarshal(body, googleError) == nil {
+		for _, detail := range googleError.Error.Details {
+			if delay, err := time.ParseDuration(detail.RetryDelay); err == nil {
+				return delay
+			}
+		}
+	}
+
+	return 0
+}
@@ -12,6 +12,13 @@ import (
 )
 
 func togetherAIInference(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, client *http.Client) ([]*Message, error) {
+	// completions API takes a single prompt
+	return runJobs(inferenceEngine, batch, func(task *JobQueueTask) (*Message, error) {
+		return togetherAICompletion(inferenceEngine, task, client)
+	})
+}
+
+func togetherAICompletion(inferenceEngine *RemoteInferenceEngine, task *JobQueueTask, client *http.Client) (*Message, error) {
 	// {
 	//  "model": "togethercomputer/RedPajama-INCITE-7B-Instruct",
 	//  "prompt": "Q: The capital of France is?\nA:",
@@ -21,31 +28,62 @@ func togetherAIInference(inferenceEngine *RemoteInferenceEngine, batch []*JobQue
 	//  "max_tokens": 1,
 	//  "repetition_penalty": 1
 	//}
+	type togetherResponseFormat struct {
+		Type   string          `json:"type"`
+		Schema json.RawMessage `json:"schema"`
+	}
 	type togetherRequest struct {
-		Model             string  `json:"model"`
-		Prompt            string  `json:"prompt"`
-		Temperature       float32 `json:"temperature"`
-		TopP              float32 `json:"top_p"`
-		TopK              int     `json:"top_k"`
-		MaxTokens         int     `json:"max_tokens"`
-		RepetitionPenalty float32 `json:"repetition_penalty"`
-		Stop              string  `json:"stop"`
+		Model             string                  `json:"model"`
+		Prompt            string                  `json:"prompt"`
+		Temperature       float32                 `json:"temperature"`
+		TopP              float32                 `json:"top_p"`
+		TopK              int                     `json:"top_k"`
+		MaxTokens         int                     `json:"max_tokens"`
+		RepetitionPenalty float32                 `json:"repetition_penalty"`
+		PresencePenalty   float32                 `json:"presence_penalty,omitempty"`
+		FrequencyPenalty  float32                 `json:"frequency_penalty,omitempty"`
+		Seed              *int                    `json:"seed,omitempty"`
+		LogProbs          int                     `json:"logprobs,omitempty"`
+		N                 int                     `json:"n,omitempty"`
+		ResponseFormat    *togetherResponseFormat `json:"response_format,omitempty"`
+		Stop              []string                `json:"stop,omitempty"`
+		StreamTokens      bool                    `json:"stream_tokens,omitempty"`
 	}
 
-	var stopTokens = []string{"###"}
-	if len(batch[0].Req.StopTokens) > 0 {
-		stopTokens[0] = batch[0].Req.StopTokens[0]
+	settings := task.Req
+	if settings.Grammar != "" {
+		return nil, fmt.Errorf("grammar constraints are not supported by %s", inferenceEngine.Protocol)
+	}
+
+	model := inferenceEngine.modelFor(task.Req)
+	if model == "" {
+		// model := "mistralai/Mistral-7B-Instruct-v0.1"
+		model = "Qwen/Qwen2-72B-Instruct"
 	}
 	req := &togetherRequest{
-		// Model:       "mistralai/Mistral-7B-Instruct-v0.1",
-		Model:             "Qwen/Qwen2-72B-Instruct",
-		Prompt:            batch[0].Req.RawPrompt,
-		Temperature:       batch[0].Req.Temperature,
+		Model:             model,
+		Prompt:            settings.RawPrompt,
+		Temperature:       settings.Temperature,
 		TopP:              0.9,
 		TopK:              50,
-		MaxTokens:         16384,
+		MaxTokens:         settings.maxTokens(),
 		RepetitionPenalty: 0.7,
-		Stop:              stopTokens[0],
+		PresencePenalty:   settings.PresencePenalty,
+		FrequencyPenalty:  settings.FrequencyPenalty,
+		Seed:              settings.Seed,
+		LogProbs:          settings.LogProbs,
+		N:                 settings.N,
+		Stop:              settings.StopTokens,
+		StreamTokens:      task.ResStream != nil && settings.choices() == 1,
+	}
+	if settings.TopP > 0 {
+		req.TopP = settings.TopP
+	}
+	if settings.TopK > 0 {
+		req.TopK = settings.TopK
+	}
+	if len(settings.JSONSchema) > 0 {
+		req.ResponseFormat = &togetherResponseFormat{Type: "json_object", Schema: settings.JSONSchema}
 	}
 
 	reqJson, err := json.Marshal(req)
@@ -73,6 +111,48 @@ func togetherAIInference(inferenceEngine *RemoteInferenceEngine, batch []*JobQue
 		return nil, err
 	}
 
+	if req.StreamTokens && resp.StatusCode == 200 {
+		// streamed events are: data: {"choices":[{"text":"..."}], ...}
+		type togetherStreamChunk struct {
+			Choices []struct {
+				Text string `json:"text"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage,omitempty"`
+		}
+		content := &strings.Builder{}
+		usage := &Usage{}
+		err = readEventStream(resp.Body, func(data []byte) error {
+			chunk := &togetherStreamChunk{}
+			if err := json.Unmarshal(data, chunk); err != nil {
+				return fmt.Errorf("error unmarshalling stream chunk: %v, %s", err, string(data))
+			}
+			if chunk.Usage != nil {
+				// sent along with the last chunk
+				usage = chunk.Usage
+			}
+			if len(chunk.Choices) > 0 && chunk.Choices[0].Text != "" {
+				content.WriteString(chunk.Choices[0].Text)
+				task.ResStream <- chunk.Choices[0].Text
+			}
+			return nil
+		})
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		results := []*Message{{
+			Role:    ChatRoleAssistant,
+			Content: content.String(),
+		}}
+		reportUsage(inferenceEngine, []*JobQueueTask{task}, results, usage.PromptTokens, usage.CompletionTokens)
+		if task.Res != nil {
+			task.Res <- results[0]
+		}
+
+		return results[0], nil
+	}
+
 	result, err := io.ReadAll(resp.Body)
 	_ = resp.Body.Close()
 	if err != nil {
@@ -90,18 +170,18 @@ func togetherAIInference(inferenceEngine *RemoteInferenceEngine, batch []*JobQue
 				Content: "context overflow",
 			}
 
-			if batch[0].Res != nil {
-				batch[0].Res <- results[0]
+			if task.Res != nil {
+				task.Res <- results[0]
 			}
 
-			return results, nil
+			return results[0], nil
 		} else {
 			zlog.Error().Msgf("error code is 400, it could be context overflow error, be we're not certain: %s", result)
 		}
 	}
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("http code is %d, err: %v", resp.StatusCode, string(result))
+		err = responseError(resp, result)
 		zlog.Error().Err(err).
 			Msgf("err in compl. (%s): %v", inferenceEngine.EndpointUrl, err)
 		return nil, err
@@ -111,9 +191,12 @@ func togetherAIInference(inferenceEngine *RemoteInferenceEngine, batch []*JobQue
 	type togetherResponse struct {
 		Output struct {
 			Choices []struct {
-				Text string `json:"text"`
+				Text     string              `json:"text"`
+				LogProbs *CompletionLogProbs `json:"logprobs,omitempty"`
 			}
+			Usage *Usage `json:"usage"`
 		} `json:"output"`
+		Usage *Usage `json:"usage"`
 	}
 	parsedResponse := &togetherResponse{}
 
@@ -122,17 +205,34 @@ func togetherAIInference(inferenceEngine *RemoteInferenceEngine, batch []*JobQue
 		return nil, err
 	}
 
-	results := make([]*Message, 1)
-	results[0] = &Message{
-		Role:    ChatRoleAssistant,
-		Content: parsedResponse.Output.Choices[0].Text,
+	choices := make([]*Message, 0, len(parsedResponse.Output.Choices))
+	for _, choice := range parsedResponse.Output.Choices {
+		choices = append(choices, &Message{
+			Role:     ChatRoleAssistant,
+			Content:  choice.Text,
+			LogProbs: choice.LogProbs.toLogProbs(),
+		})
+	}
+	results, err := groupChoices(choices, 1, settings.choices())
+	if err != nil {
+		return nil, err
+	}
+
+	// depending on API version, usage is reported either along with the output or next to it
+	usage := parsedResponse.Usage
+	if usage == nil {
+		usage = parsedResponse.Output.Usage
+	}
+	if usage == nil {
+		usage = &Usage{}
 	}
+	reportUsage(inferenceEngine, []*JobQueueTask{task}, results, usage.PromptTokens, usage.CompletionTokens)
 
-	if batch[0].Res != nil {
-		batch[0].Res <- results[0]
+	if task.Res != nil {
+		task.Res <- results[0]
 	}
 
-	return results, nil
+	return results[0], nil
 }
 
 type pointlessOpenAIList struct {
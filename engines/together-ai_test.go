@@ -0,0 +1,33 @@
+package engines
+
+import (
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTogetherAIStopTokens(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		_, _ = w.Write([]byte(`{"output":{"choices":[{"text":"hi"}]}}`))
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: "http-together", Models: []string{"model"}}
+	for _, stopTokens := range [][]string{{"###", "\n\n"}, nil} {
+		task := &JobQueueTask{Req: &GenerationSettings{RawPrompt: "hello", StopTokens: stopTokens}}
+		results, err := RunCompletionRequest(zerolog.Nop(), engine, []*JobQueueTask{task})
+		if err != nil || results[0].Content != "hi" {
+			t.Fatalf("unexpected completion: %v, %v", results, err)
+		}
+
+		stop, sent := received["stop"].([]interface{})
+		if len(stopTokens) == 0 && received["stop"] != nil || len(stopTokens) > 0 && (!sent || len(stop) != len(stopTokens)) {
+			t.Fatalf("expected stop tokens %q to be sent, got %v", stopTokens, received["stop"])
+		}
+	}
+}
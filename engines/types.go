@@ -2,6 +2,7 @@ package engines
 
 import (
 	"crypto/sha512"
+	"encoding/json"
 	"github.com/d0rc/agent-os/vectors"
 	"github.com/google/uuid"
 	"sync"
@@ -21,7 +22,11 @@ type Message struct {
 	MetaInfo interface{}         `json:"meta,omitempty"`
 	Role     ChatRole            `json:"role"`
 	Content  string              `json:"content"`
-	lock     sync.RWMutex
+	// LogProbs - generated tokens along with their log probabilities, if GenerationSettings.LogProbs is set
+	LogProbs []LogProb `json:"logprobs,omitempty"`
+	// Alternatives - the rest of the choices, if GenerationSettings.N is more than 1
+	Alternatives []*Message `json:"alternatives,omitempty"`
+	lock         sync.RWMutex
 }
 
 func (m *Message) Lock() {
@@ -54,6 +59,7 @@ func GenerateMessageId(body string) string {
 }
 
 type GenerationSettings struct {
+	Model              string                     `json:"model"` // set by compute router, empty - first model of the engine
 	Messages           []Message                  `json:"messages"`
 	AfterJoinPrefix    string                     `json:"after_join_prefix"`
 	RawPrompt          string                     `json:"raw_prompt"`
@@ -63,16 +69,32 @@ type GenerationSettings struct {
 	BestOf             int                        `json:"best_of"`
 	StatisticsCallback func(info *StatisticsInfo) `json:"statistics_callback"`
 	MaxRetries         int                        `json:"max_retries"`
+	Stream             bool                       `json:"stream"`
+	MaxTokens          int                        `json:"max_tokens"` // 0 - DefaultMaxTokens
+	TopP               float32                    `json:"top_p"`      // 0 - engine's default
+	TopK               int                        `json:"top_k"`      // 0 - engine's default
+	PresencePenalty    float32                    `json:"presence_penalty"`
+	FrequencyPenalty   float32                    `json:"frequency_penalty"`
+	Seed               *int                       `json:"seed"`
+	LogProbs           int                        `json:"logprobs"`    // most likely tokens returned at each position, 0 - none
+	N                  int                        `json:"n"`           // choices to generate, 0 - 1
+	JSONSchema         json.RawMessage            `json:"json_schema"` // constrains output to JSON matching the schema
+	Grammar            string                     `json:"grammar"`     // constrains output to GBNF grammar
 }
 
 type StatisticsInfo struct {
 	TokensProcessed int
 	TokensGenerated int
 	PromptTokens    int
+	// Estimated - engine didn't report usage, tokens are counted with GPT-2 tokenizer
+	Estimated bool
 }
 
 type JobQueueTask struct {
 	Req           *GenerationSettings
 	Res           chan *Message
 	ResEmbeddings chan *vectors.Vector
+	// ResStream receives generated text pieces as they arrive,
+	// full message is still sent to Res once generation is done
+	ResStream chan string
 }
@@ -0,0 +1,89 @@
+package engines
+
+import (
+	"github.com/d0rc/agent-os/syslib/utils"
+	"sync/atomic"
+)
+
+// reportUsage - passes token usage reported by the engine to statistics callbacks of the tasks
+// and accounts it in engine's totals; batched requests report usage of the whole batch, so prompt
+// tokens are split in proportion to prompt lengths and generated tokens are split evenly;
+// if engine reported no usage, it's estimated with the tokenizer
+func reportUsage(engine *RemoteInferenceEngine, batch []*JobQueueTask, results []*Message, promptTokens, completionTokens int) {
+	if promptTokens == 0 && completionTokens == 0 {
+		estimateUsage(engine, batch, results)
+		return
+	}
+
+	promptLengths := make([]int, len(batch))
+	totalLength := 0
+	for idx, task := range batch {
+		promptLengths[idx] = promptLength(task.Req)
+		totalLength += promptLengths[idx]
+	}
+
+	promptTokensLeft, completionTokensLeft := promptTokens, completionTokens
+	for idx, task := range batch {
+		info := &StatisticsInfo{}
+		if idx == len(batch)-1 {
+			// rounding leftovers go to the last task
+			info.PromptTokens = promptTokensLeft
+			info.TokensGenerated = completionTokensLeft
+		} else {
+			if totalLength > 0 {
+				info.PromptTokens = promptTokens * promptLengths[idx] / totalLength
+			}
+			info.TokensGenerated = completionTokens / len(batch)
+		}
+		info.TokensProcessed = info.PromptTokens + info.TokensGenerated
+		promptTokensLeft -= info.PromptTokens
+		completionTokensLeft -= info.TokensGenerated
+
+		accountUsage(engine, task, info)
+	}
+}
+
+func estimateUsage(engine *RemoteInferenceEngine, batch []*JobQueueTask, results []*Message) {
+	for idx, task := range batch {
+		info := &StatisticsInfo{Estimated: true}
+		if len(task.Req.Messages) == 0 {
+			info.PromptTokens = utils.CountTokensGPT2(task.Req.RawPrompt)
+		} else {
+			for msgIdx := range task.Req.Messages {
+				info.PromptTokens += utils.CountTokensGPT2(task.Req.Messages[msgIdx].Content)
+			}
+		}
+		if idx < len(results) && results[idx] != nil {
+			info.TokensGenerated = utils.CountTokensGPT2(results[idx].Content)
+			for _, alternative := range results[idx].Alternatives {
+				info.TokensGenerated += utils.CountTokensGPT2(alternative.Content)
+			}
+		}
+		info.TokensProcessed = info.PromptTokens + info.TokensGenerated
+
+		accountUsage(engine, task, info)
+	}
+}
+
+func accountUsage(engine *RemoteInferenceEngine, task *JobQueueTask, info *StatisticsInfo) {
+	atomic.AddUint64(&engine.PromptTokens, uint64(info.PromptTokens))
+	atomic.AddUint64(&engine.TokensGenerated, uint64(info.TokensGenerated))
+	atomic.AddUint64(&engine.TokensProcessed, uint64(info.TokensProcessed))
+
+	if task.Req.StatisticsCallback != nil {
+		task.Req.StatisticsCallback(info)
+	}
+}
+
+func promptLength(req *GenerationSettings) int {
+	if len(req.Messages) == 0 {
+		return len(req.RawPrompt)
+	}
+
+	length := 0
+	for idx := range req.Messages {
+		length += len(req.Messages[idx].Content)
+	}
+
+	return length
+}
@@ -0,0 +1,33 @@
+package engines
+
+import "testing"
+
+func TestReportUsage(t *testing.T) {
+	infos := make([]*StatisticsInfo, 0)
+	callback := func(info *StatisticsInfo) {
+		infos = append(infos, info)
+	}
+	batch := []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "short prompt", StatisticsCallback: callback}},
+		{Req: &GenerationSettings{RawPrompt: "three times longer prompt, than the one before", StatisticsCallback: callback}},
+	}
+	results := []*Message{{Content: "hello"}, {Content: "hello world"}}
+
+	engine := &RemoteInferenceEngine{}
+	reportUsage(engine, batch, results, 100, 11)
+	if len(infos) != 2 || infos[0].PromptTokens+infos[1].PromptTokens != 100 || infos[0].PromptTokens >= infos[1].PromptTokens {
+		t.Fatalf("prompt tokens are not split by prompt length: %+v %+v", infos[0], infos[1])
+	}
+	if infos[0].TokensGenerated+infos[1].TokensGenerated != 11 || infos[0].Estimated {
+		t.Fatalf("generated tokens are not split: %+v %+v", infos[0], infos[1])
+	}
+
+	infos = infos[:0]
+	reportUsage(engine, batch, results, 0, 0)
+	if len(infos) != 2 || !infos[1].Estimated || infos[1].PromptTokens == 0 || infos[1].TokensGenerated != 2 {
+		t.Fatalf("usage is not estimated when engine doesn't report it: %+v", infos[1])
+	}
+	if engine.PromptTokens != 100+uint64(infos[0].PromptTokens+infos[1].PromptTokens) || engine.TokensGenerated != 11+1+2 {
+		t.Fatalf("usage is not accounted in engine totals: %d/%d", engine.PromptTokens, engine.TokensGenerated)
+	}
+}
@@ -979,10 +979,14 @@ retryUpdatedReport:
 		requests[i].MinResults = minResults
 	}
 
-	updatedReportResponse := him.Client.RunRequest(&cmds.ClientRequest{
+	updatedReportResponse, err := him.Client.RunRequest(&cmds.ClientRequest{
 		ProcessName:           "him-merger",
 		GetCompletionRequests: requests,
 	}, 600*time.Second, os_client.REP_Default)
+	if err != nil {
+		him.Printf("error running report merge request: %v\n", err)
+		return nil
+	}
 
 	originalChoices := tools.FlattenChoices(updatedReportResponse.GetCompletionResponse)
 	if cycle == 1 && len(originalChoices) >= minResults {
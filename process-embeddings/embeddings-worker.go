@@ -1,6 +1,7 @@
 package process_embeddings
 
 import (
+	"context"
 	"crypto/sha512"
 	"fmt"
 	"github.com/d0rc/agent-os/cmds"
@@ -172,7 +173,7 @@ func processEmbeddings(vectorDb vectors.VectorDB, collection string, pointers *[
 
 		ts := time.Now()
 		ctx.ComputeRouter.AccountProcessRequest(process)
-		response, err := cmds.ProcessGetEmbeddings(jobs, ctx, process, borrow_engine.PRIO_Background)
+		response, err := cmds.ProcessGetEmbeddings(context.Background(), jobs, ctx, process, borrow_engine.PRIO_Background)
 		if err != nil {
 			lg.Error().Err(err).
 				Msgf("error getting embeddings in %v", time.Since(ts))
@@ -173,7 +173,7 @@ retry:
 		chatPrompt.AddMessage(msg)
 	}
 
-	response := p.Client.RunRequest(&cmds.ClientRequest{
+	response, err := p.Client.RunRequest(&cmds.ClientRequest{
 		ProcessName: p.ProcessName,
 		GetCompletionRequests: tools.Replicate(cmds.GetCompletionRequest{
 			RawPrompt:   chatPrompt.DefString(),
@@ -182,6 +182,9 @@ retry:
 			MinResults:  minResults,
 		}, min(64, minResults)),
 	}, 120*time.Second, executionPool)
+	if err != nil {
+		return err
+	}
 
 	choices := tools.DropDuplicates(tools.FlattenChoices(response.GetCompletionResponse))
 	if len(choices) > minResults {
@@ -23,6 +23,7 @@ type SemanticSpace struct {
 	growthFactor     int
 	nPendingRequests int
 	waiters          []chan struct{}
+	onTrajectoryDone func(TrajectoryID)
 }
 
 func NewSemanticSpace(growthFactor int) *SemanticSpace {
@@ -68,13 +69,26 @@ func (space *SemanticSpace) GetComputeRequests(maxRequests, maxPendingRequests i
 
 }
 
+// OnTrajectoryDone - f is called once there are no more pending requests
+// for the trajectory, so compute still running for it can be cancelled
+func (space *SemanticSpace) OnTrajectoryDone(f func(TrajectoryID)) {
+	space.lock.Lock()
+	space.onTrajectoryDone = f
+	space.lock.Unlock()
+}
+
 func (space *SemanticSpace) CancelPendingRequest(trajectoryID TrajectoryID) {
+	var onTrajectoryDone func(TrajectoryID)
 	space.lock.Lock()
 	pendingReqs, exists := space.pendingRequests[trajectoryID]
 	if exists && pendingReqs > 0 {
 		// drop first element from pendingReqs
 		space.pendingRequests[trajectoryID]--
 		space.nPendingRequests--
+		if space.pendingRequests[trajectoryID] == 0 {
+			delete(space.pendingRequests, trajectoryID)
+			onTrajectoryDone = space.onTrajectoryDone
+		}
 
 		for _, waiter := range space.waiters {
 			waiter <- struct{}{}
@@ -85,6 +99,10 @@ func (space *SemanticSpace) CancelPendingRequest(trajectoryID TrajectoryID) {
 	}
 
 	space.lock.Unlock()
+
+	if onTrajectoryDone != nil {
+		onTrajectoryDone(trajectoryID)
+	}
 }
 
 func (space *SemanticSpace) Wait() bool {
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets - upper bounds of latency histograms, in seconds,
+// completions can take minutes, so buckets go much higher than usual
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type Histogram struct {
+	name   string
+	labels []string // name, value pairs
+
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+var histograms = make(map[string]*Histogram)
+var histogramsLock = sync.Mutex{}
+
+// ObserveDuration - accounts duration in the histogram, labels are name, value pairs
+func ObserveDuration(name string, d time.Duration, labels ...string) {
+	Observe(name, d.Seconds(), labels...)
+}
+
+// Observe - accounts value in the histogram with LatencyBuckets, labels are name, value pairs
+func Observe(name string, value float64, labels ...string) {
+	key := name + "{" + strings.Join(labels, ",") + "}"
+
+	histogramsLock.Lock()
+	defer histogramsLock.Unlock()
+
+	histogram, exists := histograms[key]
+	if !exists {
+		histogram = &Histogram{
+			name:   name,
+			labels: labels,
+			counts: make([]uint64, len(LatencyBuckets)+1),
+		}
+		histograms[key] = histogram
+	}
+
+	histogram.counts[sort.SearchFloat64s(LatencyBuckets, value)]++
+	histogram.sum += value
+	histogram.count++
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType - of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Prefix - of all exported metric names
+const Prefix = "agentos_"
+
+// Writer - writes metrics in Prometheus text exposition format,
+// the first write error is kept and all further writes are skipped
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Family - starts metric family, all of its samples should follow
+func (pw *Writer) Family(name, kind, help string) {
+	pw.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", Prefix, name, help, Prefix, name, kind)
+}
+
+// Sample - writes a sample, labels are name, value pairs
+func (pw *Writer) Sample(name string, value float64, labels ...string) {
+	pw.printf("%s%s%s %s\n", Prefix, name, formatLabels(labels), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (pw *Writer) Err() error {
+	return pw.err
+}
+
+func (pw *Writer) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("{")
+	for idx := 0; idx+1 < len(labels); idx += 2 {
+		if idx > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(labels[idx])
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(labels[idx+1]))
+	}
+	sb.WriteString("}")
+
+	return sb.String()
+}
+
+// MetricName - turns counter name, like `cache.llm.hits`, into metric name
+func MetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// WriteCountersAndHistograms - exports all counters ticked with Tick and all histograms
+func (pw *Writer) WriteCountersAndHistograms() {
+	countersLock.RLock()
+	names := make([]string, 0, len(counters))
+	values := make(map[string]int64, len(counters))
+	for name, counter := range counters {
+		names = append(names, name)
+		values[name] = counter.value
+	}
+	countersLock.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		metricName := MetricName(name) + "_total"
+		pw.Family(metricName, "counter", fmt.Sprintf("Total of `%s` counter.", name))
+		pw.Sample(metricName, float64(values[name]))
+	}
+
+	histogramsLock.Lock()
+	snapshot := make([]Histogram, 0, len(histograms))
+	for _, histogram := range histograms {
+		h := *histogram
+		h.counts = append([]uint64(nil), histogram.counts...)
+		snapshot = append(snapshot, h)
+	}
+	histogramsLock.Unlock()
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].name != snapshot[j].name {
+			return snapshot[i].name < snapshot[j].name
+		}
+		return formatLabels(snapshot[i].labels) < formatLabels(snapshot[j].labels)
+	})
+
+	for idx, histogram := range snapshot {
+		metricName := MetricName(histogram.name)
+		if idx == 0 || snapshot[idx-1].name != histogram.name {
+			pw.Family(metricName, "histogram", fmt.Sprintf("Distribution of `%s`.", histogram.name))
+		}
+		cumulative := uint64(0)
+		for bucket, count := range histogram.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if bucket < len(LatencyBuckets) {
+				le = LatencyBuckets[bucket]
+			}
+			labels := append(append([]string(nil), histogram.labels...), "le", formatBound(le))
+			pw.Sample(metricName+"_bucket", float64(cumulative), labels...)
+		}
+		pw.Sample(metricName+"_sum", histogram.sum, histogram.labels...)
+		pw.Sample(metricName+"_count", float64(histogram.count), histogram.labels...)
+	}
+}
+
+func formatBound(le float64) string {
+	if math.IsInf(le, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(le, 'g', -1, 64)
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCountersAndHistograms(t *testing.T) {
+	Tick("test.cache.hits", 2)
+	ObserveDuration("test.request.duration.seconds", 30*time.Millisecond, "route", "/v1/completions")
+	ObserveDuration("test.request.duration.seconds", 3*time.Second, "route", "/v1/completions")
+
+	sb := &strings.Builder{}
+	pw := NewWriter(sb)
+	pw.WriteCountersAndHistograms()
+	if pw.Err() != nil {
+		t.Fatalf("error writing metrics: %v", pw.Err())
+	}
+
+	for _, line := range []string{
+		"# TYPE agentos_test_cache_hits_total counter",
+		"agentos_test_cache_hits_total 2",
+		"# TYPE agentos_test_request_duration_seconds histogram",
+		`agentos_test_request_duration_seconds_bucket{route="/v1/completions",le="0.025"} 0`,
+		`agentos_test_request_duration_seconds_bucket{route="/v1/completions",le="0.05"} 1`,
+		`agentos_test_request_duration_seconds_bucket{route="/v1/completions",le="+Inf"} 2`,
+		`agentos_test_request_duration_seconds_count{route="/v1/completions"} 2`,
+	} {
+		if !strings.Contains(sb.String(), line+"\n") {
+			t.Fatalf("expected line `%s` in metrics:\n%s", line, sb.String())
+		}
+	}
+}
@@ -2,7 +2,9 @@ package os_client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/d0rc/agent-os/cmds"
 	"github.com/google/uuid"
@@ -35,21 +37,31 @@ func NewAgentOSClient(url string) *AgentOSClient {
 }
 
 func (c *AgentOSClient) RunRequests(reqs []*cmds.ClientRequest, timeout time.Duration) ([]*cmds.ServerResponse, error) {
-	responses := make([]chan *cmds.ServerResponse, len(reqs))
+	type requestResult struct {
+		resp *cmds.ServerResponse
+		err  error
+	}
+	responses := make([]chan requestResult, len(reqs))
 	for idx, req := range reqs {
-		responses[idx] = make(chan *cmds.ServerResponse)
-		go func(req *cmds.ClientRequest, ch chan *cmds.ServerResponse) {
-			resp := c.RunRequest(req, timeout, REP_Default)
-			ch <- resp
+		responses[idx] = make(chan requestResult)
+		go func(req *cmds.ClientRequest, ch chan requestResult) {
+			resp, err := c.RunRequest(req, timeout, REP_Default)
+			ch <- requestResult{resp: resp, err: err}
 		}(req, responses[idx])
 	}
 
+	// failed requests keep their nil slots, so responses stay aligned with requests
 	finalResponses := make([]*cmds.ServerResponse, 0)
+	errs := make([]error, 0)
 	for _, ch := range responses {
-		finalResponses = append(finalResponses, <-ch)
+		result := <-ch
+		finalResponses = append(finalResponses, result.resp)
+		if result.err != nil {
+			errs = append(errs, result.err)
+		}
 	}
 
-	return finalResponses, nil
+	return finalResponses, errors.Join(errs...)
 }
 
 type RequestExecutionPool int
@@ -59,15 +71,25 @@ const (
 	REP_IO
 )
 
+const maxRequestRetries = 10
+
 var maxParallelRequestsChannel = make(chan struct{}, 256)
 
-func (c *AgentOSClient) RunRequest(req *cmds.ClientRequest, timeout time.Duration, executionPool RequestExecutionPool) *cmds.ServerResponse {
+// RunRequest - runs the request, re-trying transport failures and retryable
+// server errors until maxRequestRetries or timeout is reached
+func (c *AgentOSClient) RunRequest(req *cmds.ClientRequest, timeout time.Duration, executionPool RequestExecutionPool) (*cmds.ServerResponse, error) {
+	return c.RunRequestContext(context.Background(), req, timeout, executionPool)
+}
+
+// RunRequestContext - same as RunRequest, but once ctx is done the request
+// is cancelled on the server, so its queued compute jobs are dropped
+func (c *AgentOSClient) RunRequestContext(ctx context.Context, req *cmds.ClientRequest, timeout time.Duration, executionPool RequestExecutionPool) (*cmds.ServerResponse, error) {
 	//timeout = 60 * time.Second
 	if req.SpecialCaseResponse != "" || isRequestEmpty(req) {
 		return &cmds.ServerResponse{
 			SpecialCaseResponse: req.SpecialCaseResponse,
 			CorrelationId:       req.CorrelationId,
-		}
+		}, nil
 	}
 
 	if executionPool == REP_Default {
@@ -76,44 +98,100 @@ func (c *AgentOSClient) RunRequest(req *cmds.ClientRequest, timeout time.Duratio
 			<-maxParallelRequestsChannel
 		}()
 	}
+
+	deadline := time.Now().Add(timeout)
+	req.Deadline = deadline.UnixMilli()
+	// server caches responses by trx, so every run of the request gets a new one,
+	// which is kept across transport retries, so they pick up the response of the first attempt
 	req.Trx = uuid.New().String()
-retry:
+	var lastErr error
+	for attempt := 0; attempt < maxRequestRetries; attempt++ {
+		if attempt > 0 {
+			if time.Now().Add(300 * time.Millisecond).After(deadline) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(300 * time.Millisecond):
+			}
+		}
+
+		serverResponse, err := c.runRequestOnce(ctx, req)
+		if ctx.Err() != nil {
+			// server doesn't notice client going away, as the result is shared by trx
+			go c.cancelRequests(req.Trx)
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			fmt.Printf("%s running OS request, going to re-try: %v\n",
+				aurora.BrightRed("error"),
+				aurora.BrightGreen(err))
+			lastErr = err
+			continue
+		}
+
+		if serverResponse.Error != nil {
+			lastErr = serverResponse.Error
+			if !serverResponse.Error.Retryable {
+				return serverResponse, serverResponse.Error
+			}
+			// new transaction is needed to re-run the request
+			req.Trx = uuid.New().String()
+			continue
+		}
+
+		return serverResponse, nil
+	}
+
+	return nil, fmt.Errorf("giving up on OS request: %w", lastErr)
+}
+
+func (c *AgentOSClient) cancelRequests(trxs ...string) {
+	_, err := c.runRequestOnce(context.Background(), &cmds.ClientRequest{
+		CancelTrx: trxs,
+	})
+	if err != nil {
+		fmt.Printf("%s cancelling OS requests: %v\n",
+			aurora.BrightRed("error"),
+			aurora.BrightGreen(err))
+	}
+}
+
+func (c *AgentOSClient) runRequestOnce(ctx context.Context, req *cmds.ClientRequest) (*cmds.ServerResponse, error) {
+	if req.Trx == "" {
+		req.Trx = uuid.New().String()
+	}
 
 	reqBytes, err := json.Marshal(req)
 	if err != nil {
 		zlog.Fatal().Msgf("error marshalling request: %v", err)
 	}
 
-	resp, err := c.client.Post(c.Url, "application/json", bytes.NewBuffer(reqBytes))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Url, bytes.NewBuffer(reqBytes))
 	if err != nil {
-		fmt.Printf("%s running OS request, going to re-try: %v\n",
-			aurora.BrightRed("error"),
-			aurora.BrightGreen(err))
-		time.Sleep(300 * time.Millisecond)
-		goto retry
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("%s read OS response, going to re-try: %v\n",
-			aurora.BrightRed("error"),
-			aurora.BrightGreen(err))
-		time.Sleep(300 * time.Millisecond)
-		goto retry
+		return nil, fmt.Errorf("error reading OS response: %w", err)
 	}
 
 	var serverResponse cmds.ServerResponse
 	err = json.Unmarshal(respBytes, &serverResponse)
 	if err != nil {
-		fmt.Printf("%s processing OS response, going to re-try: %v\n",
-			aurora.BrightRed("error"),
-			aurora.BrightGreen(err))
-		time.Sleep(300 * time.Millisecond)
-		goto retry
+		return nil, fmt.Errorf("error parsing OS response, http status %d: %w", resp.StatusCode, err)
 	}
 
-	return &serverResponse
+	return &serverResponse, nil
 }
 
 func isRequestEmpty(req *cmds.ClientRequest) bool {
@@ -126,6 +204,7 @@ func isRequestEmpty(req *cmds.ClientRequest) bool {
 	isEmpty = isEmpty && (req.GoogleSearchRequests == nil || len(req.GoogleSearchRequests) == 0)
 
 	isEmpty = isEmpty && (req.WriteMessagesTrace == nil || len(req.WriteMessagesTrace) == 0)
+	isEmpty = isEmpty && len(req.CancelTrx) == 0
 
 	return isEmpty
 }
@@ -9,11 +9,9 @@ import (
 func ProcessGetCompletions(request []cmds.GetCompletionRequest, ctx *AgentOSClient, process string, priority borrow_engine.JobPriority) (response *cmds.ServerResponse, err error) {
 	// the purpose of this function is mirror the functionality
 	// available in OS core
-	resp := ctx.RunRequest(&cmds.ClientRequest{
+	return ctx.RunRequest(&cmds.ClientRequest{
 		GetCompletionRequests: request,
 		ProcessName:           process,
 		Priority:              priority,
 	}, 120*time.Second, REP_IO)
-
-	return resp, nil
 }
@@ -9,7 +9,7 @@ import (
 
 func (c *AgentOSClient) GetTaskCachedResult(namespace, key string) ([]byte, error) {
 	documentId := engines.GenerateMessageId(key)
-	cachedResult := c.RunRequest(&cmds.ClientRequest{
+	cachedResult, err := c.RunRequest(&cmds.ClientRequest{
 		GetCacheRecords: []cmds.GetCacheRecord{
 			{
 				Namespace: namespace,
@@ -17,17 +17,24 @@ func (c *AgentOSClient) GetTaskCachedResult(namespace, key string) ([]byte, erro
 			},
 		},
 	}, 60*time.Second, REP_IO)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(cachedResult.GetCacheRecords) == 0 {
+	if len(cachedResult.GetCacheRecords) == 0 || cachedResult.GetCacheRecords[0] == nil {
 		return nil, nil
 	}
 
+	if cachedResult.GetCacheRecords[0].Error != nil {
+		return nil, cachedResult.GetCacheRecords[0].Error
+	}
+
 	return cachedResult.GetCacheRecords[0].Value, nil
 }
 
 func (c *AgentOSClient) SetTaskCachedResult(namespace, key string, result []byte) error {
 	documentId := engines.GenerateMessageId(key)
-	response := c.RunRequest(&cmds.ClientRequest{
+	response, err := c.RunRequest(&cmds.ClientRequest{
 		SetCacheRecords: []cmds.SetCacheRecord{
 			{
 				Namespace: namespace,
@@ -36,10 +43,19 @@ func (c *AgentOSClient) SetTaskCachedResult(namespace, key string, result []byte
 			},
 		},
 	}, 60*time.Second, REP_IO)
+	if err != nil {
+		return err
+	}
 
-	done := response.SetCacheRecords[0].Done
+	if len(response.SetCacheRecords) == 0 || response.SetCacheRecords[0] == nil {
+		return fmt.Errorf("error setting cached result")
+	}
+
+	if response.SetCacheRecords[0].Error != nil {
+		return response.SetCacheRecords[0].Error
+	}
 
-	if done {
+	if response.SetCacheRecords[0].Done {
 		return nil
 	}
 
@@ -4,18 +4,13 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"os"
+	"reflect"
+	"time"
 )
 
 type ConfigurationFile struct {
-	Database struct {
-		Type     string `yaml:"type"`
-		Host     string `yaml:"host"`
-		Port     int    `yaml:"port"`
-		User     string `yaml:"user"`
-		Password string `yaml:"password"`
-		Database string `yaml:"database"`
-	} `yaml:"database"`
-	Tools struct {
+	Database DatabaseConfigurationSection `yaml:"database"`
+	Tools    struct {
 		SerpApi struct {
 			Token string `yaml:"token"`
 		} `yaml:"serp-api"`
@@ -23,16 +18,76 @@ type ConfigurationFile struct {
 			Token string `yaml:"token"`
 		} `yaml:"proxy-crawl"`
 	} `yaml:"tools"`
-	VectorDBs []VectorDBConfigurationSection `yaml:"vector-dbs"`
-	Compute   []struct {
-		Endpoint           string   `yaml:"endpoint"`
-		EmbeddingsEndpoint string   `yaml:"embeddings-endpoint"`
-		Type               string   `yaml:"type"`
-		MaxBatchSize       int      `yaml:"max-batch-size"`
-		MaxRequests        int      `yaml:"max-requests"`
-		JobTypes           []string `yaml:"job-types"`
-		Token              string   `yaml:"token"`
-	} `yaml:"compute"`
+	VectorDBs  []VectorDBConfigurationSection `yaml:"vector-dbs"`
+	Compute    []ComputeConfigurationSection  `yaml:"compute"`
+	Quotas     []QuotaConfigurationSection    `yaml:"quotas"`
+	Accounting AccountingConfigurationSection `yaml:"accounting"`
+}
+
+type AccountingConfigurationSection struct {
+	// SnapshotInterval - how often compute usage is saved to the database
+	SnapshotInterval time.Duration `yaml:"snapshot-interval"`
+}
+
+type ComputeConfigurationSection struct {
+	Endpoint           string `yaml:"endpoint"`
+	EmbeddingsEndpoint string `yaml:"embeddings-endpoint"`
+	Type               string `yaml:"type"`
+	MaxBatchSize       int    `yaml:"max-batch-size"`
+	// MaxBatchTokens - budget of estimated prompt tokens of the batch, 0 - batch is limited by max-batch-size only
+	MaxBatchTokens int      `yaml:"max-batch-tokens"`
+	MaxRequests    int      `yaml:"max-requests"`
+	JobTypes       []string `yaml:"job-types"`
+	Token          string   `yaml:"token"`
+	// Benchmark - `auto` uses limits measured earlier or measures them once node is detected,
+	// `always` measures them on every start, empty - max-batch-size and max-requests are used as is
+	Benchmark string                        `yaml:"benchmark"`
+	Scheduler SchedulerConfigurationSection `yaml:"scheduler"`
+	Cost      CostConfigurationSection      `yaml:"cost"`
+}
+
+// CostConfigurationSection - price of the node's compute, in the currency of quota budgets,
+// node without prices is free; paid nodes only take jobs free nodes can't keep up with
+type CostConfigurationSection struct {
+	PromptPer1K    float64       `yaml:"prompt-per-1k"`    // per 1000 prompt tokens
+	GeneratedPer1K float64       `yaml:"generated-per-1k"` // per 1000 generated tokens
+	PerHour        float64       `yaml:"per-hour"`         // per hour of node time
+	SpillAfter     time.Duration `yaml:"spill-after"`      // queue wait before user and background jobs go to the node
+}
+
+// SchedulerConfigurationSection - how workers of the compute node form batches, unset values take defaults
+type SchedulerConfigurationSection struct {
+	Policy          string         `yaml:"policy"`           // strict, weighted-fair or aging
+	BatchingLatency time.Duration  `yaml:"batching-latency"` // time the batch waits to be filled up
+	IdlePoll        time.Duration  `yaml:"idle-poll"`
+	PriorityWeights map[string]int `yaml:"priority-weights"` // weighted-fair shares of system, kernel, user and background jobs
+	AgingInterval   time.Duration  `yaml:"aging-interval"`   // waiting time which promotes job one priority up
+}
+
+// QuotaConfigurationSection - limits compute used by every process or tag matching the glob,
+// zero limit - no limit
+type QuotaConfigurationSection struct {
+	Process           string  `yaml:"process"`
+	Tag               string  `yaml:"tag"`
+	MaxConcurrentJobs int     `yaml:"max-concurrent-jobs"`
+	JobsPerMinute     int     `yaml:"jobs-per-minute"`
+	GPUSecondsPerHour float64 `yaml:"gpu-seconds-per-hour"`
+	SpendPerDay       float64 `yaml:"spend-per-day"` // budget for paid nodes
+	OverQuota         string  `yaml:"over-quota"`    // reject (default) or defer
+}
+
+// DatabaseConfigurationSection - for sqlite `database` is the path of the database file,
+// for mysql the host is reached through SSH tunnel when it's not directly accessible
+type DatabaseConfigurationSection struct {
+	Type     string `yaml:"type"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+	TLS      string `yaml:"tls"`      // mysql driver's tls: true, false, skip-verify or preferred
+	SSHUser  string `yaml:"ssh-user"` // defaults to database user
+	SSHKey   string `yaml:"ssh-key"`  // defaults to ~/.ssh/id_rsa
 }
 
 type VectorDBConfigurationSection struct {
@@ -50,10 +105,22 @@ func ProcessConfigurationFile(path string) (*ConfigurationFile, error) {
 		return nil, fmt.Errorf("error loading configuration file %s: %v", path, err)
 	}
 
-	err = yaml.Unmarshal(yamlText, config)
+	// unknown keys are mostly typos, which would silently leave settings at their defaults
+	err = yaml.UnmarshalStrict(yamlText, config)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing configuration file %s: %v", path, err)
 	}
 
+	err = expandEnvInStrings(reflect.ValueOf(config), "")
+	if err != nil {
+		return nil, fmt.Errorf("error expanding configuration file %s: %v", path, err)
+	}
+
+	config.applyDefaults()
+	err = config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+
 	return config, nil
 }
@@ -0,0 +1,193 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	return path
+}
+
+func TestProcessConfigurationFileExpandsEnv(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD", "p#ss: word")
+	t.Setenv("TEST_SERP_TOKEN", "serp-token")
+
+	config, err := ProcessConfigurationFile(writeConfig(t, `
+database:
+  type: mysql
+  host: db.local
+  port: 3307
+  user: ai
+  password: ${TEST_DB_PASSWORD}
+  database: ${TEST_DB_NAME:-ai_srv}
+tools:
+  serp-api:
+    token: ${TEST_SERP_TOKEN}
+  proxy-crawl:
+    token: literal-$token
+`))
+	if err != nil {
+		t.Fatalf("error processing config: %v", err)
+	}
+
+	if config.Database.Password != "p#ss: word" || config.Database.Database != "ai_srv" || config.Database.Port != 3307 {
+		t.Fatalf("unexpected database section: %+v", config.Database)
+	}
+	if config.Tools.SerpApi.Token != "serp-token" || config.Tools.ProxyCrawl.Token != "literal-$token" {
+		t.Fatalf("unexpected tools section: %+v", config.Tools)
+	}
+}
+
+func TestProcessConfigurationFileReportsUnsetEnv(t *testing.T) {
+	_, err := ProcessConfigurationFile(writeConfig(t, `
+tools:
+  serp-api:
+    token: ${TEST_UNSET_VARIABLE}
+`))
+	if err == nil {
+		t.Fatalf("expected error for unset variable")
+	}
+}
+
+func TestExampleConfigurationFileNeedsNoTokens(t *testing.T) {
+	for _, name := range []string{"AI_SRV_DB_PASSWORD", "SERP_API_TOKEN", "PROXY_CRAWL_TOKEN"} {
+		// restores the variable once the test is done
+		t.Setenv(name, "")
+		_ = os.Unsetenv(name)
+	}
+
+	config, err := ProcessConfigurationFile("../../config.example.yaml")
+	if err != nil {
+		t.Fatalf("error processing example config: %v", err)
+	}
+	if config.Tools.SerpApi.Token != "" || config.Tools.ProxyCrawl.Token != "" {
+		t.Fatalf("unexpected tools section: %+v", config.Tools)
+	}
+}
+
+func TestProcessConfigurationFileReadsSecretFiles(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secretPath, []byte("secret-token\n"), 0600); err != nil {
+		t.Fatalf("error writing secret: %v", err)
+	}
+
+	config, err := ProcessConfigurationFile(writeConfig(t, `
+tools:
+  serp-api:
+    token: ${file:`+secretPath+`}
+`))
+	if err != nil {
+		t.Fatalf("error processing config: %v", err)
+	}
+	if config.Tools.SerpApi.Token != "secret-token" {
+		t.Fatalf("unexpected token: %q", config.Tools.SerpApi.Token)
+	}
+}
+
+func TestProcessConfigurationFileAppliesComputeDefaults(t *testing.T) {
+	config, err := ProcessConfigurationFile(writeConfig(t, `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    job-types: [completion]
+`))
+	if err != nil {
+		t.Fatalf("error processing config: %v", err)
+	}
+
+	node := config.Compute[0]
+	if node.Type != DefaultComputeType || node.MaxRequests != 1 || node.MaxBatchSize != 1 {
+		t.Fatalf("unexpected compute defaults: %+v", node)
+	}
+}
+
+func TestProcessConfigurationFileParsesScheduler(t *testing.T) {
+	config, err := ProcessConfigurationFile(writeConfig(t, `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    job-types: [completion]
+    scheduler:
+      policy: weighted-fair
+      batching-latency: 20ms
+      priority-weights: {background: 3}
+`))
+	if err != nil {
+		t.Fatalf("error processing config: %v", err)
+	}
+
+	scheduler := config.Compute[0].Scheduler
+	if scheduler.Policy != "weighted-fair" || scheduler.BatchingLatency != 20*time.Millisecond ||
+		scheduler.PriorityWeights["background"] != 3 {
+		t.Fatalf("unexpected scheduler settings: %+v", scheduler)
+	}
+}
+
+func TestProcessConfigurationFileRejectsImpossibleSettings(t *testing.T) {
+	for name, text := range map[string]string{
+		"unknown key": `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    job-types: [completion]
+    max-batch: 16
+`,
+		"no job types": `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+`,
+		"unknown job type": `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    job-types: [chat]
+`,
+		"unknown compute type": `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    type: grpc
+    job-types: [completion]
+`,
+		"negative max-requests": `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    max-requests: -1
+    job-types: [completion]
+`,
+		"unknown scheduling policy": `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    job-types: [completion]
+    scheduler:
+      policy: lottery
+`,
+		"unknown priority weight": `
+compute:
+  - endpoint: http://localhost:8001/v1/completions
+    job-types: [completion]
+    scheduler:
+      priority-weights: {urgent: 2}
+`,
+		"quota without limits": `
+quotas:
+  - process: action-voter
+`,
+		"quota for process and tag": `
+quotas:
+  - process: action-voter
+    tag: crawler
+    jobs-per-minute: 60
+`,
+		"unknown database type": `
+database:
+  type: postgres
+`,
+	} {
+		if _, err := ProcessConfigurationFile(writeConfig(t, text)); err == nil {
+			t.Errorf("%s: expected configuration error", name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ${NAME}, ${NAME:-default} or ${file:/path/to/secret}, anything else containing `$` is left as is
+var envPlaceholder = regexp.MustCompile(`\$\{(?:file:([^}]+)|([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?)}`)
+
+// expandEnv - replaces ${NAME} placeholders in the string with environment variables
+// and ${file:path} with contents of the file, unset variables without default value
+// and unreadable files are reported as errors
+func expandEnv(value string) (string, error) {
+	errs := make([]error, 0)
+	result := envPlaceholder.ReplaceAllStringFunc(value, func(placeholder string) string {
+		groups := envPlaceholder.FindStringSubmatch(placeholder)
+		if groups[1] != "" {
+			secret, err := readSecretFile(groups[1])
+			if err != nil {
+				errs = append(errs, err)
+				return placeholder
+			}
+			return secret
+		}
+		if envValue, ok := os.LookupEnv(groups[2]); ok {
+			return envValue
+		}
+		if groups[3] != "" {
+			return groups[4]
+		}
+		errs = append(errs, fmt.Errorf("environment variable %s is not set", groups[2]))
+		return placeholder
+	})
+
+	if len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+
+	return result, nil
+}
+
+// readSecretFile - secrets mounted as files usually end with a newline, which isn't part of the secret
+func readSecretFile(path string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file %s: %v", path, err)
+		}
+		path = filepath.Join(home, path[2:])
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %v", err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// expandEnvInStrings - expands placeholders in every string reachable from v,
+// it's done after parsing, so the values can't break YAML syntax
+func expandEnvInStrings(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		expanded, err := expandEnv(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		v.SetString(expanded)
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return expandEnvInStrings(v.Elem(), path)
+		}
+	case reflect.Struct:
+		for idx := 0; idx < v.NumField(); idx++ {
+			field := v.Type().Field(idx)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			if err := expandEnvInStrings(v.Field(idx), joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for idx := 0; idx < v.Len(); idx++ {
+			if err := expandEnvInStrings(v.Index(idx), fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
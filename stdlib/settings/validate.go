@@ -0,0 +1,209 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	JobTypeCompletion = "completion"
+	JobTypeEmbeddings = "embeddings"
+
+	DefaultComputeType = "http-openai"
+
+	DefaultAccountingSnapshotInterval = time.Minute
+
+	BenchmarkAuto   = "auto"
+	BenchmarkAlways = "always"
+
+	OverQuotaReject = "reject"
+	OverQuotaDefer  = "defer"
+)
+
+// SchedulingPolicies - policies borrow-engine can form batches with
+var SchedulingPolicies = []string{"strict", "weighted-fair", "aging"}
+
+// JobPriorities - names of job priorities, from the highest to the lowest
+var JobPriorities = []string{"system", "kernel", "user", "background"}
+
+// ComputeTypes - protocols of compute nodes engines package can talk to
+var ComputeTypes = []string{"http-openai", "http-together", "http-llamacpp", "http-ollama", "http-anthropic", "http-gemini", "http-mock"}
+
+var databaseTypes = []string{"", "mysql", "sqlite", "sqlite3"}
+
+var vectorDBTypes = []string{"qdrant"}
+
+func (config *ConfigurationFile) applyDefaults() {
+	if config.Accounting.SnapshotInterval == 0 {
+		config.Accounting.SnapshotInterval = DefaultAccountingSnapshotInterval
+	}
+	for idx := range config.Compute {
+		node := &config.Compute[idx]
+		if node.Type == "" {
+			node.Type = DefaultComputeType
+		}
+		if node.MaxRequests == 0 {
+			node.MaxRequests = 1
+		}
+		if node.MaxBatchSize == 0 {
+			node.MaxBatchSize = 1
+		}
+	}
+}
+
+// Validate - reports all settings which can't work together, rather than
+// leaving them to fail at runtime
+func (config *ConfigurationFile) Validate() error {
+	errs := make([]error, 0)
+
+	if !contains(databaseTypes, strings.ToLower(config.Database.Type)) {
+		errs = append(errs, fmt.Errorf("database: unknown type `%s`, expected one of: %s",
+			config.Database.Type, strings.Join(databaseTypes[1:], ", ")))
+	}
+	if config.Database.Port < 0 || config.Database.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database: port %d is out of range", config.Database.Port))
+	}
+
+	for idx, vectorDB := range config.VectorDBs {
+		if !contains(vectorDBTypes, vectorDB.Type) {
+			errs = append(errs, fmt.Errorf("vector-dbs[%d]: unknown type `%s`, expected one of: %s",
+				idx, vectorDB.Type, strings.Join(vectorDBTypes, ", ")))
+		}
+		if vectorDB.Endpoint == "" {
+			errs = append(errs, fmt.Errorf("vector-dbs[%d]: no endpoint", idx))
+		}
+	}
+
+	seenNodes := make(map[string]int)
+	for idx, node := range config.Compute {
+		if err := node.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("compute[%d]: %v", idx, err))
+		}
+		if prevIdx, exists := seenNodes[node.Key()]; exists {
+			errs = append(errs, fmt.Errorf("compute[%d]: same node as compute[%d]", idx, prevIdx))
+		}
+		seenNodes[node.Key()] = idx
+	}
+
+	for idx, quota := range config.Quotas {
+		if err := quota.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("quotas[%d]: %v", idx, err))
+		}
+	}
+
+	if config.Accounting.SnapshotInterval < 0 {
+		errs = append(errs, fmt.Errorf("accounting: snapshot-interval can't be negative, got %s",
+			config.Accounting.SnapshotInterval))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (quota *QuotaConfigurationSection) Validate() error {
+	errs := make([]error, 0)
+
+	if (quota.Process == "") == (quota.Tag == "") {
+		errs = append(errs, fmt.Errorf("expected either process or tag"))
+	}
+	if quota.MaxConcurrentJobs < 0 || quota.JobsPerMinute < 0 || quota.GPUSecondsPerHour < 0 || quota.SpendPerDay < 0 {
+		errs = append(errs, fmt.Errorf("limits can't be negative"))
+	}
+	if quota.MaxConcurrentJobs == 0 && quota.JobsPerMinute == 0 && quota.GPUSecondsPerHour == 0 && quota.SpendPerDay == 0 {
+		errs = append(errs, fmt.Errorf("no limits, expected max-concurrent-jobs, jobs-per-minute, gpu-seconds-per-hour or spend-per-day"))
+	}
+	if quota.OverQuota != "" && quota.OverQuota != OverQuotaReject && quota.OverQuota != OverQuotaDefer {
+		errs = append(errs, fmt.Errorf("unknown over-quota action `%s`, expected `%s` or `%s`",
+			quota.OverQuota, OverQuotaReject, OverQuotaDefer))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Key - identifies the node across configuration reloads
+func (node *ComputeConfigurationSection) Key() string {
+	return fmt.Sprintf("%s|%s|%s", node.Endpoint, node.EmbeddingsEndpoint, strings.Join(node.JobTypes, ","))
+}
+
+func (node *ComputeConfigurationSection) Validate() error {
+	errs := make([]error, 0)
+
+	if node.Endpoint == "" && node.EmbeddingsEndpoint == "" {
+		errs = append(errs, fmt.Errorf("no endpoint"))
+	}
+	if !contains(ComputeTypes, node.Type) {
+		errs = append(errs, fmt.Errorf("unknown type `%s`, expected one of: %s",
+			node.Type, strings.Join(ComputeTypes, ", ")))
+	}
+	if node.Benchmark != "" && node.Benchmark != BenchmarkAuto && node.Benchmark != BenchmarkAlways {
+		errs = append(errs, fmt.Errorf("unknown benchmark mode `%s`, expected `%s` or `%s`",
+			node.Benchmark, BenchmarkAuto, BenchmarkAlways))
+	}
+	if err := node.Scheduler.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("scheduler: %w", err))
+	}
+	if node.MaxBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("max-batch-size should be positive, got %d", node.MaxBatchSize))
+	}
+	if node.MaxBatchTokens < 0 {
+		errs = append(errs, fmt.Errorf("max-batch-tokens can't be negative, got %d", node.MaxBatchTokens))
+	}
+	if node.Cost.PromptPer1K < 0 || node.Cost.GeneratedPer1K < 0 || node.Cost.PerHour < 0 || node.Cost.SpillAfter < 0 {
+		errs = append(errs, fmt.Errorf("cost can't be negative"))
+	}
+	if node.MaxRequests < 1 {
+		errs = append(errs, fmt.Errorf("max-requests should be positive, got %d", node.MaxRequests))
+	}
+
+	switch {
+	case len(node.JobTypes) == 0:
+		errs = append(errs, fmt.Errorf("no job-types, expected `%s` or `%s`",
+			JobTypeCompletion, JobTypeEmbeddings))
+	case len(node.JobTypes) > 1:
+		// workers of the node are serving a single queue
+		errs = append(errs, fmt.Errorf("node serves a single job type, got %s, add a node per job type",
+			strings.Join(node.JobTypes, ", ")))
+	case node.JobTypes[0] != JobTypeCompletion && node.JobTypes[0] != JobTypeEmbeddings:
+		errs = append(errs, fmt.Errorf("unknown job type `%s`, expected `%s` or `%s`",
+			node.JobTypes[0], JobTypeCompletion, JobTypeEmbeddings))
+	}
+
+	if len(errs) > 0 && node.Endpoint != "" {
+		return fmt.Errorf("%s: %w", node.Endpoint, errors.Join(errs...))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (scheduler *SchedulerConfigurationSection) Validate() error {
+	errs := make([]error, 0)
+
+	if scheduler.Policy != "" && !contains(SchedulingPolicies, scheduler.Policy) {
+		errs = append(errs, fmt.Errorf("unknown policy `%s`, expected one of: %s",
+			scheduler.Policy, strings.Join(SchedulingPolicies, ", ")))
+	}
+	if scheduler.BatchingLatency < 0 || scheduler.IdlePoll < 0 || scheduler.AgingInterval < 0 {
+		errs = append(errs, fmt.Errorf("batching-latency, idle-poll and aging-interval can't be negative"))
+	}
+	for priority, weight := range scheduler.PriorityWeights {
+		if !contains(JobPriorities, priority) {
+			errs = append(errs, fmt.Errorf("unknown priority `%s` in priority-weights, expected one of: %s",
+				priority, strings.Join(JobPriorities, ", ")))
+		}
+		if weight < 1 {
+			errs = append(errs, fmt.Errorf("weight of %s priority should be positive, got %d", priority, weight))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package storage
+
+import "time"
+
+// ComputeNodeLimits - batching limits of compute node measured by the benchmark
+type ComputeNodeLimits struct {
+	Endpoint     string    `db:"endpoint"`
+	JobType      string    `db:"job_type"`
+	MaxBatchSize int       `db:"max_batch_size"`
+	MaxRequests  int       `db:"max_requests"`
+	Performance  float32   `db:"performance"`
+	MeasuredAt   time.Time `db:"measured_at"`
+}
+
+func (s *Storage) SaveComputeNodeLimits(limits *ComputeNodeLimits) error {
+	_, err := s.Db.Exec("save-compute-node-limits",
+		limits.Endpoint,
+		limits.JobType,
+		limits.MaxBatchSize,
+		limits.MaxRequests,
+		limits.Performance,
+		limits.MeasuredAt)
+	return err
+}
+
+// GetComputeNodeLimits - returns nil if node was never measured
+func (s *Storage) GetComputeNodeLimits(endpoint, jobType string) (*ComputeNodeLimits, error) {
+	results := make([]ComputeNodeLimits, 0)
+	err := s.Db.GetStructsSlice("get-compute-node-limits", &results, endpoint, jobType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	return &results[0], nil
+}
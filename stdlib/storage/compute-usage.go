@@ -0,0 +1,48 @@
+package storage
+
+import "time"
+
+// ComputeUsageRecord - compute used by a process on a node during the period,
+// periods of records never overlap, so usage of any time window is the sum of records
+type ComputeUsageRecord struct {
+	PeriodStart     time.Time `db:"period_start"`
+	PeriodEnd       time.Time `db:"period_end"`
+	Process         string    `db:"process"`
+	Tags            string    `db:"tags"` // comma separated
+	Endpoint        string    `db:"endpoint"`
+	Model           string    `db:"model"`
+	Requests        uint64    `db:"requests"`
+	Jobs            uint64    `db:"jobs"`
+	PromptTokens    uint64    `db:"prompt_tokens"`
+	GeneratedTokens uint64    `db:"generated_tokens"`
+	GPUSeconds      float64   `db:"gpu_seconds"`
+	Cost            float64   `db:"cost"` // spent on paid nodes
+}
+
+// SaveComputeUsage - times are stored in UTC, truncated to seconds, so they compare the same way in every dialect
+func (s *Storage) SaveComputeUsage(record *ComputeUsageRecord) error {
+	_, err := s.Db.Exec("save-compute-usage",
+		record.PeriodStart.UTC().Truncate(time.Second),
+		record.PeriodEnd.UTC().Truncate(time.Second),
+		record.Process,
+		record.Tags,
+		record.Endpoint,
+		record.Model,
+		record.Requests,
+		record.Jobs,
+		record.PromptTokens,
+		record.GeneratedTokens,
+		record.GPUSeconds,
+		record.Cost)
+	return err
+}
+
+// GetComputeUsage - records of periods overlapping with [from, to)
+func (s *Storage) GetComputeUsage(from, to time.Time) ([]ComputeUsageRecord, error) {
+	results := make([]ComputeUsageRecord, 0)
+	err := s.Db.GetStructsSlice("query-compute-usage", &results,
+		from.UTC().Truncate(time.Second),
+		to.UTC().Truncate(time.Second))
+
+	return results, err
+}
@@ -0,0 +1,52 @@
+package storage
+
+// migration - adds a column to the table created by older version, DDLs create
+// new tables with the column already, so migration only runs if table exists without it
+type migration struct {
+	table  string
+	column string
+	query  string
+}
+
+// migrations - applied in order, before DDLs, so DDLs can index the new columns
+var migrations = []migration{
+	// rows cached before keys were introduced get an empty key, no request matches them,
+	// since they didn't record the model used, temperature or stop tokens
+	{table: "llm_cache", column: "cache_key", query: "migrate-llm-cache-key"},
+	// usage saved before nodes had prices was free anyway
+	{table: "compute_usage", column: "cost", query: "migrate-compute-usage-cost"},
+}
+
+type tableColumn struct {
+	Name string `db:"name"`
+}
+
+func (s *Storage) runMigrations() {
+	for _, m := range migrations {
+		columns := make([]tableColumn, 0)
+		err := s.Db.GetStructsSlice("query-table-columns", &columns, m.table)
+		if err != nil {
+			s.lg.Fatal().Err(err).Msgf("error reading columns of %s", m.table)
+		}
+		if len(columns) == 0 || hasColumn(columns, m.column) {
+			// table is new or migrated already
+			continue
+		}
+
+		s.lg.Info().Str("name", m.query).Msg("running migration")
+		_, err = s.Db.Exec(m.query)
+		if err != nil {
+			s.lg.Fatal().Err(err).Msgf("error running migration: %s", m.query)
+		}
+	}
+}
+
+func hasColumn(columns []tableColumn, column string) bool {
+	for _, c := range columns {
+		if c.Name == column {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,285 @@
+-- name: ddl-create-page-cache
+create table if not exists page_cache (
+    id integer primary key autoincrement,
+    url varchar(768) not null,
+    raw_content blob not null,
+    created_at datetime not null,
+    cache_hits integer not null,
+    status_code integer not null
+);
+create index if not exists page_cache_url on page_cache (url);
+
+-- name: query-page-cache
+select id, url, raw_content, created_at, cache_hits, status_code from page_cache where url = ?;
+
+-- name: save-page-cache-record
+insert into page_cache (url, raw_content, created_at, cache_hits, status_code) values (?,?,?,?,?);
+
+-- name: make-page-cache-hit
+update page_cache set cache_hits = cache_hits + 1 where id = ?;
+
+-- name: ddl-create-search-cache
+create table if not exists search_cache (
+    id integer primary key autoincrement,
+    keywords varchar(1024) default null,
+    lang varchar(32) default null,
+    country varchar(32) default null,
+    location varchar(255) default null,
+    raw_content blob not null,
+    created_at datetime not null,
+    cache_hits integer not null
+);
+
+-- name: query-search-by-keywords
+select id, keywords, lang, country, location, raw_content, created_at, cache_hits from search_cache where keywords =? and lang =? and country =? and location =?;
+
+-- name: save-search-cache-record
+insert into search_cache (
+          keywords,
+          lang,
+          country,
+          location,
+          raw_content,
+          created_at,
+          cache_hits)
+values (?,?,?,?,?,?,?);
+
+-- name: make-search-cache-hit
+update search_cache set cache_hits = cache_hits + 1 where id = ?;
+
+-- name: make-search-cache-hits
+update search_cache set cache_hits = cache_hits + 1 where id in (?);
+
+-- name: ddl-create-llm-embeddings
+create table if not exists llm_embeddings (
+    id integer primary key autoincrement,
+    model varchar(255) default null,
+    namespace varchar(255) default null,
+    namespace_id integer not null,
+    text_hash varchar(255) default null,
+    embedding blob,
+    dims integer not null,
+    cache_hits integer default 0,
+    unique (model, text_hash)
+);
+create index if not exists llm_embeddings_lookup_key on llm_embeddings (model, namespace, namespace_id);
+
+-- name: make-embeddings-cache-hit
+update llm_embeddings set cache_hits = cache_hits + 1 where id = ?;
+
+-- name: insert-embeddings-cache-record
+insert into llm_embeddings (
+    model,
+    namespace,
+    namespace_id,
+    text_hash,
+    dims,
+    embedding) values (?,?,?,?,?,?) on conflict (model, text_hash) do update set embedding = excluded.embedding;
+
+-- name: query-embeddings-cache
+select id, model, namespace, namespace_id, text_hash, embedding from llm_embeddings where model = ? and text_hash = ?;
+
+-- name: get-embeddings-by-id
+select id, model, namespace, namespace_id, text_hash, embedding from llm_embeddings where id = ?;
+
+-- name: get-embeddings-by-text
+select id, model, namespace, namespace_id, text_hash, embedding from llm_embeddings where text_hash = ?;
+
+-- name: ddl-embeddings-queues
+create table if not exists embeddings_queues (
+    id integer primary key autoincrement,
+    queue_name varchar(255),
+    queue_pointer integer,
+    unique (queue_name)
+);
+
+-- name: set-embeddings-queue-pointer
+insert into embeddings_queues (queue_name, queue_pointer) values (?, ?) on conflict (queue_name) do update set queue_pointer = excluded.queue_pointer;
+
+-- name: get-embeddings-queue-pointer
+select id, queue_name, queue_pointer from embeddings_queues where queue_name = ?;
+
+-- name: ddl-create-llm-cache
+create table if not exists llm_cache (
+    id integer primary key autoincrement,
+    model varchar(1024) default null,
+    prompt blob not null,
+    prompt_length integer not null,
+    created_at datetime not null,
+    generation_settings varchar(1024) default null,
+    cache_hits integer not null,
+    generation_result blob not null,
+    cache_key char(64) not null default ''
+);
+create index if not exists llm_cache_prompt_length on llm_cache (prompt_length);
+create index if not exists llm_cache_cache_key on llm_cache (cache_key);
+
+-- name: migrate-llm-cache-key
+alter table llm_cache add column cache_key char(64) not null default '';
+
+-- name: insert-llm-cache-record
+insert into llm_cache (model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result, cache_key)
+    values (?,?,?,?,?,?,?,?);
+
+-- name: query-llm-cache-by-id
+select id, model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result from llm_cache where id = ?;
+
+-- name: query-llm-cache-by-ids-multi
+select id, model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result from llm_cache where id > ? order by id limit ?;
+
+-- name: query-llm-cache
+select id,
+       model,
+       prompt,
+       prompt_length,
+       created_at,
+       generation_settings,
+       cache_hits,
+       generation_result
+from llm_cache where cache_key = ?;
+
+-- name: make-llm-cache-hit
+update llm_cache set cache_hits = cache_hits + 1 where id = ?;
+
+-- name: make-llm-cache-hits
+update llm_cache set cache_hits = cache_hits + 1 where id in (?);
+
+-- name: ddl-task-cache
+create table if not exists compute_cache (
+    id integer primary key autoincrement,
+    namespace varchar(255) default null,
+    task_hash varchar(255) default null,
+    task_result blob not null,
+    cache_hits integer not null default 0,
+    created_at timestamp not null default current_timestamp,
+    unique (namespace, task_hash)
+);
+
+-- name: mark-task-cache-hit
+update compute_cache set cache_hits = cache_hits + 1 where id = ?;
+
+-- name: get-task-cache-record
+select id,
+       namespace,
+       task_result
+from compute_cache where namespace =? and task_hash =?;
+
+-- name: save-task-cache-record
+insert into compute_cache (namespace, task_hash, task_result) values (?,?,?);
+
+-- name: ddl-create-messages
+create table if not exists messages (
+    id varchar(255) not null primary key,
+    role varchar(255) default null,
+    content text,
+    created_at timestamp not null default current_timestamp,
+    name varchar(255) default null
+);
+create index if not exists messages_role on messages (role);
+create index if not exists messages_name on messages (name);
+
+-- name: ddl-create-message-links
+create table if not exists message_links (
+     id varchar(255) not null,
+     reply_to varchar(255) not null,
+     unique (id, reply_to)
+);
+create index if not exists message_links_reply_to on message_links (reply_to);
+
+-- name: save-messages-trace
+insert into messages (id, name, role, content) values (?, ?, ?, ?) on conflict (id) do update set role = excluded.role, name = excluded.name;
+
+-- name: save-message-link
+insert into message_links (id, reply_to) values (?,?) on conflict (id, reply_to) do nothing;
+
+-- name: get-message-by-id
+select id, name, role, content from messages where id =?;
+
+-- name: get-messages-by-ids
+select id, name, role, content from messages where id in (?);
+
+-- name: get-messages-by-text
+select id, name, role, content from messages where content like ?;
+
+-- name: get-message-links-by-id
+select id, reply_to from message_links where id = ?;
+
+-- name: get-message-links-by-reply-to
+select id, reply_to from message_links where reply_to =?;
+
+-- name: get-messages-links-by-reply-to
+select id, reply_to from message_links where reply_to  in (?);
+
+-- name: get-agent-roots
+select id from messages where messages.name = ? and role = 'system';
+
+-- name: get-paired-embeddings
+select
+    lle1.id as lle1_id,
+    lle1.namespace as lle1_namespace,
+    lle1.namespace_id as lle1_namespace_id,
+    lle1.embedding as lle1_embedding,
+    lle2.id as lle2_id,
+    lle2.namespace as lle2_namespace,
+    lle2.namespace_id as lle2_namespace_id,
+    lle2.embedding as lle2_embedding
+from llm_embeddings as lle1 left join llm_embeddings as lle2 on lle1.namespace_id = lle2.namespace_id
+where
+    lle2.namespace = 'llm-cache-generation' and
+    lle1.namespace = 'llm-cache-prompt';
+
+-- name: ddl-create-compute-node-limits
+create table if not exists compute_node_limits (
+    id integer primary key autoincrement,
+    endpoint varchar(768) not null,
+    job_type varchar(32) not null,
+    max_batch_size integer not null,
+    max_requests integer not null,
+    performance float not null,
+    measured_at datetime not null,
+    unique (endpoint, job_type)
+);
+
+-- name: save-compute-node-limits
+insert into compute_node_limits (endpoint, job_type, max_batch_size, max_requests, performance, measured_at)
+    values (?,?,?,?,?,?) on conflict (endpoint, job_type) do update set
+    max_batch_size = excluded.max_batch_size,
+    max_requests = excluded.max_requests,
+    performance = excluded.performance,
+    measured_at = excluded.measured_at;
+
+-- name: get-compute-node-limits
+select endpoint, job_type, max_batch_size, max_requests, performance, measured_at from compute_node_limits where endpoint = ? and job_type = ?;
+
+-- name: ddl-create-compute-usage
+create table if not exists compute_usage (
+    id integer primary key autoincrement,
+    period_start datetime not null,
+    period_end datetime not null,
+    process varchar(255) not null,
+    tags varchar(1024) not null,
+    endpoint varchar(768) not null,
+    model varchar(255) not null,
+    requests integer not null,
+    jobs integer not null,
+    prompt_tokens integer not null,
+    generated_tokens integer not null,
+    gpu_seconds double not null,
+    cost double not null default 0
+);
+create index if not exists compute_usage_period_end on compute_usage (period_end);
+create index if not exists compute_usage_process on compute_usage (process);
+
+-- name: migrate-compute-usage-cost
+alter table compute_usage add column cost double not null default 0;
+
+-- name: save-compute-usage
+insert into compute_usage (period_start, period_end, process, tags, endpoint, model, requests, jobs, prompt_tokens, generated_tokens, gpu_seconds, cost)
+    values (?,?,?,?,?,?,?,?,?,?,?,?);
+
+-- name: query-compute-usage
+select period_start, period_end, process, tags, endpoint, model, requests, jobs, prompt_tokens, generated_tokens, gpu_seconds, cost
+    from compute_usage where period_end > ? and period_start < ?;
+
+-- name: query-table-columns
+select name from pragma_table_info(?);
@@ -111,13 +111,18 @@ create table if not exists llm_cache (
     `generation_settings` varchar(1024) DEFAULT NULL,
     `cache_hits` int unsigned NOT NULL,
     `generation_result` mediumblob NOT NULL,
+    `cache_key` char(64) NOT NULL DEFAULT '',
     PRIMARY KEY (`id`),
-    KEY `prompt_length` (`prompt_length`,`prompt`(900))
+    KEY `prompt_length` (`prompt_length`,`prompt`(900)),
+    KEY `cache_key` (`cache_key`)
  );
 
+-- name: migrate-llm-cache-key
+alter table llm_cache add column `cache_key` char(64) NOT NULL DEFAULT '', add key `cache_key` (`cache_key`);
+
 -- name: insert-llm-cache-record
-insert into llm_cache (model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result)
-    values (?,?,?,?,?,?,?);
+insert into llm_cache (model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result, cache_key)
+    values (?,?,?,?,?,?,?,?);
 
 -- name: query-llm-cache-by-id
 select id, model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result from llm_cache where id = ?;
@@ -134,9 +139,7 @@ select id,
        generation_settings,
        cache_hits,
        generation_result
-from llm_cache where
-    prompt_length = ? and
-    prompt = ?;
+from llm_cache where cache_key = ?;
 
 -- name: make-llm-cache-hit
 update llm_cache set cache_hits = cache_hits + 1 where id = ?;
@@ -230,4 +233,61 @@ select
 from llm_embeddings as lle1 left join llm_embeddings as lle2 on lle1.namespace_id = lle2.namespace_id
 where
     lle2.namespace = "llm-cache-generation" and
-    lle1.namespace = "llm-cache-prompt";
\ No newline at end of file
+    lle1.namespace = "llm-cache-prompt";
+-- name: ddl-create-compute-node-limits
+create table if not exists compute_node_limits (
+    id bigint unsigned not null auto_increment,
+    endpoint varchar(768) not null,
+    job_type varchar(32) not null,
+    max_batch_size int unsigned not null,
+    max_requests int unsigned not null,
+    performance float not null,
+    measured_at datetime not null,
+    primary key (id),
+    unique (endpoint, job_type)
+);
+
+-- name: save-compute-node-limits
+insert into compute_node_limits (endpoint, job_type, max_batch_size, max_requests, performance, measured_at)
+    values (?,?,?,?,?,?) on duplicate key update
+    max_batch_size = values(max_batch_size),
+    max_requests = values(max_requests),
+    performance = values(performance),
+    measured_at = values(measured_at);
+
+-- name: get-compute-node-limits
+select endpoint, job_type, max_batch_size, max_requests, performance, measured_at from compute_node_limits where endpoint = ? and job_type = ?;
+
+-- name: ddl-create-compute-usage
+create table if not exists compute_usage (
+    id bigint unsigned not null auto_increment,
+    period_start datetime not null,
+    period_end datetime not null,
+    process varchar(255) not null,
+    tags varchar(1024) not null,
+    endpoint varchar(768) not null,
+    model varchar(255) not null,
+    requests bigint unsigned not null,
+    jobs bigint unsigned not null,
+    prompt_tokens bigint unsigned not null,
+    generated_tokens bigint unsigned not null,
+    gpu_seconds double not null,
+    cost double not null default 0,
+    primary key (id),
+    index (period_end),
+    index (process)
+);
+
+-- name: migrate-compute-usage-cost
+alter table compute_usage add column `cost` double NOT NULL DEFAULT 0;
+
+-- name: save-compute-usage
+insert into compute_usage (period_start, period_end, process, tags, endpoint, model, requests, jobs, prompt_tokens, generated_tokens, gpu_seconds, cost)
+    values (?,?,?,?,?,?,?,?,?,?,?,?);
+
+-- name: query-compute-usage
+select period_start, period_end, process, tags, endpoint, model, requests, jobs, prompt_tokens, generated_tokens, gpu_seconds, cost
+    from compute_usage where period_end > ? and period_start < ?;
+
+-- name: query-table-columns
+select column_name as name from information_schema.columns where table_schema = database() and table_name = ?;
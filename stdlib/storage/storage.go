@@ -2,8 +2,11 @@ package storage
 
 import (
 	"crypto/sha512"
+	"database/sql"
 	"embed"
 	"encoding/hex"
+	"fmt"
+	"github.com/d0rc/agent-os/stdlib/settings"
 	"github.com/d0rc/agent-os/stdlib/unidb"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
@@ -14,37 +17,115 @@ import (
 //go:embed queries.sql
 var queriesFs embed.FS
 
+//go:embed queries-sqlite.sql
+var sqliteQueriesFs embed.FS
+
+const (
+	TypeMySQL  = "mysql"
+	TypeSQLite = "sqlite"
+
+	DefaultMySQLDatabase = "ai_srv"
+	DefaultSQLiteFile    = "ai_srv.db"
+)
+
+// Database - named queries storage backends implement, every backend
+// defines the same set of queries in its own SQL dialect
+type Database interface {
+	Exec(name string, args ...interface{}) (sql.Result, error)
+	GetStructsSlice(name string, v interface{}, args ...interface{}) error
+	GetQueries() map[string]string
+}
+
 type Storage struct {
-	Db *unidb.UniDB
+	Db Database
 	lg zerolog.Logger
 }
 
+// NewStorageFromConfig - opens storage backend described by `database` section of config,
+// for sqlite `database` is the path of the database file
+func NewStorageFromConfig(lg zerolog.Logger, config *settings.DatabaseConfigurationSection) (*Storage, error) {
+	switch strings.ToLower(config.Type) {
+	case "", TypeMySQL:
+		return newMySQLStorage(lg, config)
+	case TypeSQLite, "sqlite3":
+		return NewSQLiteStorage(lg, config.Database)
+	default:
+		return nil, fmt.Errorf("unknown database type: %s", config.Type)
+	}
+}
+
 func NewStorage(lg zerolog.Logger, host string) (*Storage, error) {
+	return newMySQLStorage(lg, &settings.DatabaseConfigurationSection{Host: host})
+}
+
+func newMySQLStorage(lg zerolog.Logger, config *settings.DatabaseConfigurationSection) (*Storage, error) {
+	host := config.Host
 	if host == "" {
 		host = "127.0.0.1"
 	}
-	db, err := unidb.NewUniDB().
-		WithDB("ai_srv").
+	dbName := config.Database
+	if dbName == "" {
+		dbName = DefaultMySQLDatabase
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid database port: %d", config.Port)
+	}
+
+	builder := unidb.NewUniDB().
+		WithDB(dbName).
 		WithHost(host).
+		WithPort(uint(config.Port)).
+		WithUser(config.User).
+		WithPassword(config.Password).
+		WithTLS(config.TLS).
+		WithSshUser(config.SSHUser).
 		WithParseTime().
 		WithMaxConns(32).
 		WithMaxIdleConnTime(120 * time.Second).
 		WithMaxConnTime(600 * time.Second).
 		WithTCPTimeout(60 * time.Second).
-		WithQueries(&queriesFs).
+		WithQueries(&queriesFs)
+	if config.SSHKey != "" {
+		builder = builder.WithSshKeyFile(config.SSHKey)
+	}
+
+	db, err := builder.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return newStorage(lg, db), nil
+}
+
+// NewSQLiteStorage - embedded storage, which needs no external services,
+// path `:memory:` gives a database living as long as the process
+func NewSQLiteStorage(lg zerolog.Logger, path string) (*Storage, error) {
+	if path == "" {
+		path = DefaultSQLiteFile
+	}
+	db, err := unidb.NewUniDB().
+		WithSQLiteFile(path).
+		WithLogger(lg).
+		WithMaxConns(32).
+		WithQueries(&sqliteQueriesFs).
 		Connect()
 	if err != nil {
 		return nil, err
 	}
 
+	return newStorage(lg, db), nil
+}
+
+func newStorage(lg zerolog.Logger, db Database) *Storage {
 	storage := &Storage{
 		Db: db,
 		lg: lg,
 	}
+	storage.runMigrations()
 	// execute DDLs
 	storage.execDDLs()
 
-	return storage, nil
+	return storage
 }
 
 func (s *Storage) execDDLs() {
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/gchaincl/dotsql"
+	zlog "github.com/rs/zerolog/log"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func loadQueryNames(t *testing.T, path string) []string {
+	data, err := queriesFs.ReadFile(path)
+	if err != nil {
+		data, err = sqliteQueriesFs.ReadFile(path)
+	}
+	if err != nil {
+		t.Fatalf("error reading %s: %v", path, err)
+	}
+
+	queries, err := dotsql.LoadFromString(string(data))
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", path, err)
+	}
+
+	names := make([]string, 0)
+	for name := range queries.QueryMap() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestDialectsDefineSameQueries(t *testing.T) {
+	mysqlQueries := loadQueryNames(t, "queries.sql")
+	sqliteQueries := loadQueryNames(t, "queries-sqlite.sql")
+
+	if len(mysqlQueries) != len(sqliteQueries) {
+		t.Fatalf("mysql defines %d queries, sqlite defines %d:\n%v\n%v",
+			len(mysqlQueries), len(sqliteQueries), mysqlQueries, sqliteQueries)
+	}
+
+	for idx := range mysqlQueries {
+		if mysqlQueries[idx] != sqliteQueries[idx] {
+			t.Fatalf("query sets differ: mysql has `%s`, sqlite has `%s`",
+				mysqlQueries[idx], sqliteQueries[idx])
+		}
+	}
+}
+
+type testLLMCacheRecord struct {
+	Id                           int64     `db:"id"`
+	Model                        string    `db:"model"`
+	Prompt                       string    `db:"prompt"`
+	PromptLength                 int       `db:"prompt_length"`
+	CreatedAt                    time.Time `db:"created_at"`
+	SerializedGenerationSettings []byte    `db:"generation_settings"`
+	CacheHits                    uint64    `db:"cache_hits"`
+	GenerationResult             string    `db:"generation_result"`
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	storage, err := NewSQLiteStorage(zlog.Logger, ":memory:")
+	if err != nil {
+		t.Fatalf("error creating sqlite storage: %v", err)
+	}
+
+	err = storage.SaveTaskCacheResult("test", "task", []byte("result"))
+	if err != nil {
+		t.Fatalf("error saving task cache result: %v", err)
+	}
+
+	result, err := storage.GetTaskCachedResult("test", "task")
+	if err != nil || string(result) != "result" {
+		t.Fatalf("unexpected task cache result: %s, %v", result, err)
+	}
+
+	prompt := "### Instruction\nSay hello.\n### Assistant:"
+	for _, record := range []struct{ result, key string }{{"hello", "key"}, {"hi", "other-key"}} {
+		_, err = storage.Db.Exec("insert-llm-cache-record", "model", prompt, len(prompt), time.Now(), "", 0, record.result, record.key)
+		if err != nil {
+			t.Fatalf("error inserting llm cache record: %v", err)
+		}
+	}
+
+	records := make([]testLLMCacheRecord, 0)
+	err = storage.Db.GetStructsSlice("query-llm-cache", &records, "key")
+	if err != nil {
+		t.Fatalf("error querying llm cache: %v", err)
+	}
+	if len(records) != 1 || records[0].GenerationResult != "hello" || records[0].CreatedAt.IsZero() {
+		t.Fatalf("unexpected llm cache records: %+v", records)
+	}
+
+	_, err = storage.Db.Exec("make-llm-cache-hits", []int64{records[0].Id})
+	if err != nil {
+		t.Fatalf("error marking llm cache hits: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = storage.Db.Exec("save-messages-trace", "message-id", "agent", "user", "content")
+		if err != nil {
+			t.Fatalf("error saving messages trace: %v", err)
+		}
+		_, err = storage.Db.Exec("save-message-link", "message-id", "parent-id")
+		if err != nil {
+			t.Fatalf("error saving message link: %v", err)
+		}
+	}
+
+	limits, err := storage.GetComputeNodeLimits("http://127.0.0.1:8001/v1/completions", "completion")
+	if err != nil || limits != nil {
+		t.Fatalf("unexpected limits of unmeasured node: %+v, %v", limits, err)
+	}
+	for _, batchSize := range []int{16, 32} {
+		err = storage.SaveComputeNodeLimits(&ComputeNodeLimits{
+			Endpoint:     "http://127.0.0.1:8001/v1/completions",
+			JobType:      "completion",
+			MaxBatchSize: batchSize,
+			MaxRequests:  2,
+			Performance:  1000,
+			MeasuredAt:   time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("error saving compute node limits: %v", err)
+		}
+	}
+	limits, err = storage.GetComputeNodeLimits("http://127.0.0.1:8001/v1/completions", "completion")
+	if err != nil || limits == nil || limits.MaxBatchSize != 32 || limits.MaxRequests != 2 {
+		t.Fatalf("unexpected compute node limits: %+v, %v", limits, err)
+	}
+}
+
+func TestLLMCacheKeyMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("error opening sqlite database: %v", err)
+	}
+	_, err = db.Exec(`create table llm_cache (
+		id integer primary key autoincrement, model varchar(1024) default null, prompt blob not null,
+		prompt_length integer not null, created_at datetime not null, generation_settings varchar(1024) default null,
+		cache_hits integer not null, generation_result blob not null);
+		insert into llm_cache (model, prompt, prompt_length, created_at, cache_hits, generation_result)
+		values ('model', 'prompt', 6, current_timestamp, 0, 'result');`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatalf("error creating llm cache of older version: %v", err)
+	}
+
+	storage, err := NewSQLiteStorage(zlog.Logger, path)
+	if err != nil {
+		t.Fatalf("error opening sqlite storage: %v", err)
+	}
+
+	// rows cached before keys were introduced get an empty key, hashed keys of requests never match it
+	records := make([]testLLMCacheRecord, 0)
+	err = storage.Db.GetStructsSlice("query-llm-cache", &records, "")
+	if err != nil || len(records) != 1 || records[0].GenerationResult != "result" {
+		t.Fatalf("existing rows were not migrated: %+v, %v", records, err)
+	}
+}
@@ -9,6 +9,9 @@ import (
 func FlattenChoices(response []*cmds.GetCompletionResponse) []string {
 	result := make([]string, 0)
 	for _, choice := range response {
+		if choice == nil {
+			continue
+		}
 		for _, c := range choice.Choices {
 			result = append(result, c)
 		}
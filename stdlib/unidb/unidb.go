@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/d0rc/agent-os/stdlib/sshtunnel"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/ssh"
+	_ "modernc.org/sqlite"
 )
 
 type Builder struct {
@@ -24,11 +26,16 @@ type Builder struct {
 	logger                 zerolog.Logger
 	hostString             string
 	userName               string
+	dbUser                 string
+	dbPassword             string
+	tlsMode                string
+	sshUser                string
 	hostAddr               string
 	hostDirectlyAccessible bool
 	tcpTimeout             time.Duration
 	dbPort                 uint
 	dbDriverArgs           string
+	driverConfig           *mysql.Config // parsed dbDriverArgs
 	tunnel                 *sshtunnel.SSHTunnel
 	maxIdleConns           int
 	maxConns               int
@@ -39,6 +46,7 @@ type Builder struct {
 	shortHostNameSet       bool
 	shortHostName          string
 	ignoreEmptyQueriesFS   bool
+	sqliteFile             string
 }
 
 type UniDB struct {
@@ -97,6 +105,29 @@ func (builder *Builder) WithPort(port uint) *Builder {
 	return builder
 }
 
+// WithUser - database user, takes precedence over `user@host` form of the host
+func (builder *Builder) WithUser(user string) *Builder {
+	builder.dbUser = user
+	return builder
+}
+
+func (builder *Builder) WithPassword(password string) *Builder {
+	builder.dbPassword = password
+	return builder
+}
+
+// WithTLS - mysql driver's tls mode: true, false, skip-verify or preferred
+func (builder *Builder) WithTLS(mode string) *Builder {
+	builder.tlsMode = mode
+	return builder
+}
+
+// WithSshUser - user for SSH tunnel, defaults to database user
+func (builder *Builder) WithSshUser(user string) *Builder {
+	builder.sshUser = user
+	return builder
+}
+
 func (builder *Builder) WithSshKey(privateKey ssh.AuthMethod) *Builder {
 	builder.privateKey = privateKey
 	return builder
@@ -154,6 +185,13 @@ func (builder *Builder) WithParseTime() *Builder {
 	return builder
 }
 
+// WithSQLiteFile - use embedded SQLite database stored in the file instead of MySQL,
+// `:memory:` gives in-memory database, which lives as long as UniDB
+func (builder *Builder) WithSQLiteFile(path string) *Builder {
+	builder.sqliteFile = path
+	return builder
+}
+
 func (builder *Builder) WithMaxIdleConnTime(mit time.Duration) *Builder {
 	builder.maxIdleConnTime = mit
 	return builder
@@ -173,6 +211,14 @@ func (builder *Builder) ShouldConnect() *UniDB {
 }
 
 func (builder *Builder) Connect() (*UniDB, error) {
+	if builder.sqliteFile != "" {
+		// embedded database, no hosts, keys or tunnels
+		if err := builder.loadQueries(); err != nil {
+			return nil, err
+		}
+		return builder.sqliteConnect()
+	}
+
 	if builder.tcpTimeout == 0 {
 		builder.tcpTimeout = 3 * time.Second
 	}
@@ -189,6 +235,19 @@ func (builder *Builder) Connect() (*UniDB, error) {
 		builder.dbDriverArgs = fmt.Sprintf("%s&parseTime=true", builder.dbDriverArgs)
 	}
 
+	if builder.tlsMode != "" {
+		builder.dbDriverArgs = fmt.Sprintf("%s&tls=%s", builder.dbDriverArgs, builder.tlsMode)
+	}
+
+	driverConfig, err := mysql.ParseDSN("/?" + builder.dbDriverArgs)
+	if err != nil {
+		builder.logger.Error().Err(err).
+			Str("driver-args", builder.dbDriverArgs).
+			Msg("error parsing driver args")
+		return nil, err
+	}
+	builder.driverConfig = driverConfig
+
 	if builder.privateKey == nil {
 		// we
 	}
@@ -223,18 +282,12 @@ func (builder *Builder) Connect() (*UniDB, error) {
 		builder.userName = "root"
 		builder.hostAddr = builder.hostString
 	}
+	if builder.dbUser != "" {
+		builder.userName = builder.dbUser
+	}
 
-	if builder.queriesFS != nil {
-		dotSql, err := getQueries(builder.queriesFS)
-		if err != nil {
-			builder.logger.Error().Err(err).
-				Msg("failed to read queries fs")
-			return nil, err
-		}
-		builder.dotSql = dotSql
-	} else if !builder.ignoreEmptyQueriesFS {
-		builder.logger.Warn().
-			Msg("no queries defined connecting UniDB!!!")
+	if err := builder.loadQueries(); err != nil {
+		return nil, err
 	}
 
 	// now let's check if host is directly accessible
@@ -276,27 +329,67 @@ func (builder *Builder) Connect() (*UniDB, error) {
 	return db, nil
 }
 
+func (builder *Builder) loadQueries() error {
+	if builder.queriesFS != nil {
+		dotSql, err := getQueries(builder.queriesFS)
+		if err != nil {
+			builder.logger.Error().Err(err).
+				Msg("failed to read queries fs")
+			return err
+		}
+		builder.dotSql = dotSql
+	} else if !builder.ignoreEmptyQueriesFS {
+		builder.logger.Warn().
+			Msg("no queries defined connecting UniDB!!!")
+	}
+
+	return nil
+}
+
+// getConnectString - DSN is formatted by the driver, so any characters of the password are fine
+func (builder *Builder) getConnectString(addr string) string {
+	config := builder.driverConfig.Clone()
+	config.User = builder.userName
+	config.Passwd = builder.dbPassword
+	config.Net = "tcp"
+	config.Addr = addr
+	config.DBName = builder.dbName
+
+	return config.FormatDSN()
+}
+
+// redacted - connection string without password, suitable for logs
+func (builder *Builder) redacted(dbString string) string {
+	if builder.dbPassword == "" {
+		return dbString
+	}
+	config, err := mysql.ParseDSN(dbString)
+	if err != nil {
+		return "***"
+	}
+	config.Passwd = "***"
+
+	return config.FormatDSN()
+}
+
+func (builder *Builder) getSshUser() string {
+	if builder.sshUser != "" {
+		return builder.sshUser
+	}
+	return builder.userName
+}
+
 func (builder *Builder) getDirectDbConnectString() string {
-	return fmt.Sprintf("%s@tcp(%s:%d)/%s?%s",
-		builder.userName,
-		builder.hostAddr,
-		builder.dbPort,
-		builder.dbName,
-		builder.dbDriverArgs)
+	return builder.getConnectString(net.JoinHostPort(builder.hostAddr, strconv.Itoa(int(builder.dbPort))))
 }
 
 func (builder *Builder) getTunneledConnectString() string {
-	return fmt.Sprintf("%s@tcp(%s:%d)/%s?%s",
-		builder.userName,
-		"127.0.0.1",
-		builder.tunnel.Local.Port,
-		builder.dbName,
-		builder.dbDriverArgs)
+	return builder.getConnectString(net.JoinHostPort("127.0.0.1", strconv.Itoa(builder.tunnel.Local.Port)))
 }
 
 func (builder *Builder) sshConnect() (*UniDB, error) {
 	tunnel, err := sshtunnel.NewSSHTunnel(fmt.Sprintf("%s@%s",
-		builder.userName,
+		builder.getSshUser(),
 		builder.hostAddr),
 		builder.privateKey,
 		fmt.Sprintf("127.0.0.1:%v", builder.dbPort),
@@ -306,7 +399,7 @@ func (builder *Builder) sshConnect() (*UniDB, error) {
 	if err != nil {
 		builder.logger.Error().Err(err).
 			Str("ssh-host", builder.hostAddr).
-			Str("ssh-user", builder.userName).
+			Str("ssh-user", builder.getSshUser()).
 			Msg("error creating ssh tunnel")
 		return nil, err
 	}
@@ -320,15 +413,42 @@ func (builder *Builder) sshConnect() (*UniDB, error) {
 	return builder.directConnect(builder.getTunneledConnectString())
 }
 
+func (builder *Builder) sqliteConnect() (*UniDB, error) {
+	dbString := fmt.Sprintf("file:%s?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)", builder.sqliteFile)
+	if builder.sqliteFile == ":memory:" {
+		dbString = ":memory:"
+		// every connection gets its own in-memory database
+		builder.maxConns = 1
+		builder.maxIdleConns = 1
+		builder.maxIdleConnTime = -1
+		builder.maxConnTime = -1
+	}
+
+	db, err := sqlx.Open("sqlite", dbString)
+	if err != nil {
+		builder.logger.Error().Err(err).
+			Str("db-string", dbString).
+			Msg("error opening sqlite database")
+		return nil, err
+	}
+
+	// sqlx doesn't know the driver name, but it uses the same bind vars as sqlite3
+	return builder.setupConnection(sqlx.NewDb(db.DB, "sqlite3"), dbString)
+}
+
 func (builder *Builder) directConnect(dbString string) (*UniDB, error) {
 	db, err := sqlx.Open("mysql", dbString)
 	if err != nil {
 		builder.logger.Error().Err(err).
-			Str("db-string", dbString).
+			Str("db-string", builder.redacted(dbString)).
 			Msg("error opening connection")
 		return nil, err
 	}
 
+	return builder.setupConnection(db, dbString)
+}
+
+func (builder *Builder) setupConnection(db *sqlx.DB, dbString string) (*UniDB, error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
@@ -337,7 +457,7 @@ func (builder *Builder) directConnect(dbString string) (*UniDB, error) {
 
 	builder.logger.Debug().
 		Str("host", builder.hostAddr).
-		Str("db-string", dbString).
+		Str("db-string", builder.redacted(dbString)).
 		Msg("database connected")
 
 	if builder.maxIdleConns != 0 {
@@ -0,0 +1,36 @@
+package unidb
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"strings"
+	"testing"
+)
+
+func TestConnectStringKeepsPassword(t *testing.T) {
+	driverConfig, err := mysql.ParseDSN("/?timeout=30s&parseTime=true")
+	if err != nil {
+		t.Fatalf("error parsing driver args: %v", err)
+	}
+	builder := &Builder{
+		userName:     "ai",
+		dbPassword:   "p@ss/w?rd:",
+		hostAddr:     "db.local",
+		dbPort:       3307,
+		dbName:       "ai_srv",
+		driverConfig: driverConfig,
+	}
+
+	dbString := builder.getDirectDbConnectString()
+	config, err := mysql.ParseDSN(dbString)
+	if err != nil {
+		t.Fatalf("error parsing connect string %s: %v", dbString, err)
+	}
+	if config.User != "ai" || config.Passwd != builder.dbPassword || config.Addr != "db.local:3307" ||
+		config.DBName != "ai_srv" || !config.ParseTime {
+		t.Fatalf("unexpected connect string: %s", dbString)
+	}
+
+	if redacted := builder.redacted(dbString); strings.Contains(redacted, "w?rd") {
+		t.Fatalf("password isn't redacted: %s", redacted)
+	}
+}
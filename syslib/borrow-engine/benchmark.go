@@ -0,0 +1,265 @@
+package borrow_engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/syslib/utils"
+	"github.com/d0rc/agent-os/vectors"
+	"github.com/rs/zerolog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BenchmarkSettings - limits of batch size and concurrency sweep, zero values are replaced with defaults
+type BenchmarkSettings struct {
+	MaxBatchSize int
+	MaxRequests  int
+	// Rounds - batches each concurrent request runs at every step
+	Rounds int
+	// LatencyBudget - steps with 95th percentile of batch latency above it are not considered
+	LatencyBudget time.Duration
+	// MinGain - relative throughput increase, which is worth doubling batch size or concurrency
+	MinGain float64
+}
+
+type BenchmarkStep struct {
+	BatchSize       int           `json:"batch-size"`
+	Requests        int           `json:"requests"`
+	TokensPerSecond float64       `json:"tokens-per-second"`
+	LatencyP50      time.Duration `json:"latency-p50"`
+	LatencyP95      time.Duration `json:"latency-p95"`
+	Errors          int           `json:"errors"`
+}
+
+type BenchmarkResult struct {
+	Endpoint     string           `json:"endpoint"`
+	MaxBatchSize int              `json:"max-batch-size"`
+	MaxRequests  int              `json:"max-requests"`
+	Performance  float32          `json:"performance"` // tokens per second
+	Steps        []*BenchmarkStep `json:"steps"`
+}
+
+var ErrBenchmarkFailed = errors.New("no benchmark step succeeded")
+
+func (settings *BenchmarkSettings) withDefaults() *BenchmarkSettings {
+	result := &BenchmarkSettings{
+		MaxBatchSize:  256,
+		MaxRequests:   8,
+		Rounds:        3,
+		LatencyBudget: 60 * time.Second,
+		MinGain:       0.05,
+	}
+	if settings == nil {
+		return result
+	}
+	if settings.MaxBatchSize > 0 {
+		result.MaxBatchSize = settings.MaxBatchSize
+	}
+	if settings.MaxRequests > 0 {
+		result.MaxRequests = settings.MaxRequests
+	}
+	if settings.Rounds > 0 {
+		result.Rounds = settings.Rounds
+	}
+	if settings.LatencyBudget > 0 {
+		result.LatencyBudget = settings.LatencyBudget
+	}
+	if settings.MinGain > 0 {
+		result.MinGain = settings.MinGain
+	}
+
+	return result
+}
+
+// BenchmarkNode - sweeps batch sizes and concurrency on the node with synthetic prompts,
+// doubling each while throughput grows, and picks the fastest step within latency budget;
+// node keeps serving jobs meanwhile, so it's best run when the node is idle
+func BenchmarkNode(lg zerolog.Logger, node *InferenceNode, settings *BenchmarkSettings) (*BenchmarkResult, error) {
+	if node.RemoteEngine == nil || len(node.JobTypes) == 0 {
+		return nil, fmt.Errorf("compute node %s is not detected yet", node.EndpointUrl)
+	}
+	settings = settings.withDefaults()
+
+	result := &BenchmarkResult{
+		Endpoint: node.EndpointUrl,
+		Steps:    make([]*BenchmarkStep, 0),
+	}
+	var best *BenchmarkStep
+	for requests := 1; requests <= settings.MaxRequests; requests *= 2 {
+		var rowBest *BenchmarkStep
+		for batchSize := 1; batchSize <= settings.MaxBatchSize; batchSize *= 2 {
+			step := runBenchmarkStep(lg, node, batchSize, requests, settings.Rounds)
+			result.Steps = append(result.Steps, step)
+			lg.Info().Msgf("benchmark of %s: %d x %d, %.1f tokens/s, p50 %s, p95 %s, %d errors",
+				node.EndpointUrl, requests, batchSize, step.TokensPerSecond, step.LatencyP50, step.LatencyP95, step.Errors)
+
+			if step.Errors > 0 || step.LatencyP95 > settings.LatencyBudget {
+				// larger batches won't do better
+				break
+			}
+			if rowBest != nil && step.TokensPerSecond < rowBest.TokensPerSecond*(1+settings.MinGain) {
+				if step.TokensPerSecond > rowBest.TokensPerSecond {
+					rowBest = step
+				}
+				break
+			}
+			rowBest = step
+		}
+
+		if rowBest == nil {
+			break
+		}
+		if best != nil && rowBest.TokensPerSecond < best.TokensPerSecond*(1+settings.MinGain) {
+			// more concurrent requests don't pay off
+			break
+		}
+		best = rowBest
+	}
+
+	if best == nil {
+		return result, fmt.Errorf("%w on %s", ErrBenchmarkFailed, node.EndpointUrl)
+	}
+
+	result.MaxBatchSize = best.BatchSize
+	result.MaxRequests = best.Requests
+	result.Performance = float32(best.TokensPerSecond)
+
+	return result, nil
+}
+
+func runBenchmarkStep(lg zerolog.Logger, node *InferenceNode, batchSize, requests, rounds int) *BenchmarkStep {
+	step := &BenchmarkStep{
+		BatchSize: batchSize,
+		Requests:  requests,
+	}
+
+	lock := sync.Mutex{}
+	latencies := make([]time.Duration, 0, requests*rounds)
+	texts := make([]string, 0, requests*rounds*batchSize)
+	wg := sync.WaitGroup{}
+	ts := time.Now()
+	for request := 0; request < requests; request++ {
+		wg.Add(1)
+		go func(request int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				batchTs := time.Now()
+				batchTexts, err := runBenchmarkBatch(lg, node, batchSize, fmt.Sprintf("%d-%d", request, round))
+
+				lock.Lock()
+				if err != nil {
+					step.Errors++
+				} else {
+					latencies = append(latencies, time.Since(batchTs))
+					texts = append(texts, batchTexts...)
+				}
+				lock.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}(request)
+	}
+	wg.Wait()
+	elapsed := time.Since(ts)
+
+	// tokens are counted once the step is over, so tokenizer doesn't take node's time
+	step.TokensPerSecond = float64(countTokens(texts)) / elapsed.Seconds()
+	step.LatencyP50 = percentile(latencies, 0.5)
+	step.LatencyP95 = percentile(latencies, 0.95)
+
+	return step
+}
+
+// runBenchmarkBatch - returns texts generated for completions or texts embedded for embeddings
+func runBenchmarkBatch(lg zerolog.Logger, node *InferenceNode, batchSize int, nonce string) ([]string, error) {
+	tasks := make([]*engines.JobQueueTask, batchSize)
+	for idx := range tasks {
+		// prompts differ, so engines can't reuse earlier results
+		tasks[idx] = &engines.JobQueueTask{
+			Req: &engines.GenerationSettings{
+				RawPrompt: fmt.Sprintf("### Instruction\nWrite a short paragraph about the number %s-%d.\n### Assistant: ",
+					nonce, idx),
+				MaxRetries:  1,
+				Temperature: 0.7,
+				StopTokens:  []string{"###"},
+			},
+		}
+	}
+
+	texts := make([]string, 0, batchSize)
+	if node.JobTypes[0] == JT_Embeddings {
+		embeddingsResults := make([]chan *vectors.Vector, batchSize)
+		for idx, task := range tasks {
+			embeddingsResults[idx] = make(chan *vectors.Vector, 1)
+			task.ResEmbeddings = embeddingsResults[idx]
+		}
+		if _, err := engines.RunEmbeddingsRequest(node.RemoteEngine, tasks); err != nil {
+			return nil, err
+		}
+
+		for _, task := range tasks {
+			texts = append(texts, task.Req.RawPrompt)
+		}
+		return texts, nil
+	}
+
+	completionResults := make([]chan *engines.Message, batchSize)
+	for idx, task := range tasks {
+		completionResults[idx] = make(chan *engines.Message, 1)
+		task.Res = completionResults[idx]
+	}
+	if _, err := engines.RunCompletionRequest(lg, node.RemoteEngine, tasks); err != nil {
+		return nil, err
+	}
+
+	for idx := range tasks {
+		select {
+		case msg := <-completionResults[idx]:
+			if msg != nil {
+				texts = append(texts, msg.Content)
+			}
+		default:
+			// engine returned fewer results than prompts
+		}
+	}
+
+	return texts, nil
+}
+
+// countTokens - estimated with GPT-2 tokenizer, as not every engine reports usage;
+// texts are tokenized at once, as every tokenizer call loads the vocabulary
+func countTokens(texts []string) int {
+	tokens, err := utils.TokenizeGPT2(strings.Join(texts, "\n"))
+	if err != nil {
+		return 0
+	}
+
+	return len(tokens)
+}
+
+func percentile(values []time.Duration, p float64) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+// ApplyBenchmarkResult - makes the scheduler use measured limits of the node
+func (ie *InferenceEngine) ApplyBenchmarkResult(node *InferenceNode, result *BenchmarkResult) {
+	if node.RemoteEngine != nil {
+		node.RemoteEngine.Performance = result.Performance
+	}
+	ie.UpdateNodeLimits(node, result.MaxRequests, result.MaxBatchSize)
+}
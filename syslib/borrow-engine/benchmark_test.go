@@ -0,0 +1,66 @@
+package borrow_engine
+
+import (
+	"encoding/json"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkNodeStopsBeforeFailingBatchSize(t *testing.T) {
+	// engine takes the same time for any batch, but can't take more than 4 prompts
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			_, _ = w.Write([]byte(`{"data": [{"id": "test-model"}]}`))
+			return
+		}
+
+		request := struct {
+			Prompt interface{} `json:"prompt"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		prompts := 1
+		if list, ok := request.Prompt.([]interface{}); ok {
+			prompts = len(list)
+		}
+		if prompts > 4 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		choices := make([]map[string]string, prompts)
+		for idx := range choices {
+			choices[idx] = map[string]string{"text": "The number is a fine number indeed."}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"choices": choices})
+	}))
+	defer server.Close()
+
+	node := &InferenceNode{
+		EndpointUrl: server.URL + "/v1",
+		JobTypes:    []JobType{JT_Completion},
+		RemoteEngine: &engines.RemoteInferenceEngine{
+			EndpointUrl: server.URL + "/v1",
+			Protocol:    "http-openai",
+			Models:      []string{"test-model"},
+		},
+	}
+
+	result, err := BenchmarkNode(zerolog.Nop(), node, &BenchmarkSettings{
+		MaxBatchSize: 16,
+		MaxRequests:  2,
+		Rounds:       2,
+	})
+	if err != nil {
+		t.Fatalf("benchmark failed: %v", err)
+	}
+
+	if result.MaxBatchSize != 4 || result.MaxRequests != 2 || result.Performance <= 0 {
+		t.Fatalf("unexpected benchmark result: %d x %d, %f tokens/s",
+			result.MaxRequests, result.MaxBatchSize, result.Performance)
+	}
+}
@@ -0,0 +1,44 @@
+package borrow_engine
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog"
+	"testing"
+)
+
+func TestFilterCancelledJobs(t *testing.T) {
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{}, nil)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cancelledJob := &ComputeJob{
+		JobId:         "cancelled",
+		Ctx:           cancelledCtx,
+		ComputeResult: &ComputeResult{ErrorChannel: make(chan error, 1)},
+	}
+	batch := []*ComputeJob{
+		{JobId: "no-context"},
+		cancelledJob,
+		{JobId: "alive", Ctx: context.Background()},
+	}
+
+	batch = engine.filterCancelled(batch)
+	if len(batch) != 2 || batch[0].JobId != "no-context" || batch[1].JobId != "alive" {
+		t.Fatalf("unexpected batch after filtering: %v", batch)
+	}
+
+	if engine.TotalJobsCancelled != 1 {
+		t.Fatalf("expected 1 cancelled job, got %d", engine.TotalJobsCancelled)
+	}
+
+	select {
+	case err := <-cancelledJob.ComputeResult.ErrorChannel:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	default:
+		t.Fatalf("cancelled job was not notified")
+	}
+}
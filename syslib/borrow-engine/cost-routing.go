@@ -0,0 +1,107 @@
+package borrow_engine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSpillAfter - queue wait of user and background jobs, after which they go to paid nodes
+const DefaultSpillAfter = 30 * time.Second
+
+// NodeCost - price of the node's compute, in the same currency as budgets of the quotas;
+// node without cost or with zero prices is free, like local GPUs
+type NodeCost struct {
+	PromptPer1K    float64 // per 1000 prompt tokens
+	GeneratedPer1K float64 // per 1000 generated tokens
+	PerHour        float64 // per hour of node time, batch time is split evenly between its jobs
+	// SpillAfter - paid node takes user and background jobs only once they waited that long
+	// for free nodes, system and kernel jobs go to it right away; DefaultSpillAfter if not set
+	SpillAfter time.Duration
+}
+
+func (cost *NodeCost) isPaid() bool {
+	return cost != nil && (cost.PromptPer1K > 0 || cost.GeneratedPer1K > 0 || cost.PerHour > 0)
+}
+
+func (cost *NodeCost) spillAfter() time.Duration {
+	if cost.SpillAfter > 0 {
+		return cost.SpillAfter
+	}
+
+	return DefaultSpillAfter
+}
+
+// jobCost - tokens are only paid for jobs which got their results, node time is paid anyway
+func (cost *NodeCost) jobCost(job *ComputeJob, jobTime time.Duration, failed bool) float64 {
+	if !cost.isPaid() {
+		return 0
+	}
+
+	total := cost.PerHour * jobTime.Hours()
+	if !failed {
+		total += cost.PromptPer1K * float64(atomic.LoadUint64(&job.promptTokens)) / 1000
+		total += cost.GeneratedPer1K * float64(atomic.LoadUint64(&job.generatedTokens)) / 1000
+	}
+
+	return total
+}
+
+// GetCost - returns price of the node's compute, nil for free nodes
+func (n *InferenceNode) GetCost() *NodeCost {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	return n.Cost
+}
+
+// SetCost - applies new prices to the running node, starting from the next batch
+func (n *InferenceNode) SetCost(cost *NodeCost) {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	n.Cost = cost
+}
+
+// IsPaid - node's compute costs money, so it only takes jobs free nodes can't keep up with
+func (n *InferenceNode) IsPaid() bool {
+	return n.GetCost().isPaid()
+}
+
+// hasFreeNode - some free node, which isn't quarantined or removed, can run jobs of the type and model
+func (ie *InferenceEngine) hasFreeNode(jobType JobType, modelMask string) bool {
+	for _, node := range ie.GetNodes() {
+		if !nodeServesJobType(node, jobType) || node.IsPaid() || node.IsDraining() {
+			continue
+		}
+		if _, ok := matchNodeModel(node, modelMask); !ok {
+			continue
+		}
+		if health, _ := node.GetHealth(); health != NH_Quarantined {
+			return true
+		}
+	}
+
+	return false
+}
+
+// spillFilter - jobs of the shared queue the node can take, nil if it can take any of them;
+// jobs pinned to the node were routed to it since no free node serves their model, so they're all taken
+func (ie *InferenceEngine) spillFilter(node *InferenceNode) func(*ComputeJob) bool {
+	cost := node.GetCost()
+	if !cost.isPaid() || !ie.hasFreeNode(node.JobTypes[0], AnyModel) {
+		// budgets of jobs nobody else can run were checked when jobs were admitted
+		return nil
+	}
+
+	spillAfter := cost.spillAfter()
+	return func(job *ComputeJob) bool {
+		if !isAnyModelMask(job.ModelMask) {
+			return true
+		}
+		if ie.quotas.overBudget(job, time.Now()) {
+			return false
+		}
+
+		return job.Priority <= PRIO_Kernel || time.Since(job.receivedAt) >= spillAfter
+	}
+}
@@ -0,0 +1,112 @@
+package borrow_engine
+
+import (
+	"github.com/rs/zerolog"
+	"testing"
+	"time"
+)
+
+func TestPaidNodeTakesSpilledJobsWithinBudget(t *testing.T) {
+	release := make(chan struct{})
+	ran := make(chan string, 16)
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{
+		JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) ([]*ComputeJob, error) {
+			for _, job := range jobs {
+				job.AccountUsage(1000, 0)
+				ran <- job.JobId + "@" + node.EndpointUrl
+			}
+			if !node.IsPaid() {
+				<-release
+			}
+			return jobs, nil
+		},
+	}, &InferenceEngineSettings{
+		TopInterval: time.Hour,
+		Quotas:      []*Quota{{Process: "agent", SpendPerDay: 1.5}},
+	})
+	go engine.Run()
+	scheduler := &SchedulerSettings{IdlePoll: 20 * time.Millisecond}
+	engine.AddNodeChan <- &InferenceNode{EndpointUrl: "free", MaxRequests: 1, MaxBatchSize: 1,
+		JobTypes: []JobType{JT_Completion}, Scheduler: scheduler}
+	engine.AddNodeChan <- &InferenceNode{EndpointUrl: "paid", MaxRequests: 1, MaxBatchSize: 1,
+		JobTypes: []JobType{JT_Completion}, Scheduler: scheduler,
+		Cost: &NodeCost{PromptPer1K: 1, SpillAfter: 300 * time.Millisecond}}
+	for len(engine.GetNodes()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expect := func(job *ComputeJob, ranOn string) time.Duration {
+		startedAt := time.Now()
+		engine.AddJob(job)
+		select {
+		case got := <-ran:
+			if got != job.JobId+"@"+ranOn {
+				t.Fatalf("expected %s to run on %s, got %s", job.JobId, ranOn, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s was not run", job.JobId)
+		}
+		return time.Since(startedAt)
+	}
+	newJob := func(id string, priority JobPriority) *ComputeJob {
+		return &ComputeJob{JobId: id, JobType: JT_Completion, Priority: priority, Process: "agent"}
+	}
+	waitForSpend := func(spend float64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !hasQuotaSpend(engine, "process agent", spend) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected spend of %.2f, got %+v", spend, engine.GetQuotaStates())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// free node is busy with the first job, so the next one waits before it spills to the paid node
+	expect(newJob("first", PRIO_User), "free")
+	if waited := expect(newJob("spilled", PRIO_User), "paid"); waited < 300*time.Millisecond {
+		t.Fatalf("job spilled to the paid node after %s only", waited)
+	}
+	waitForSpend(1)
+	if waited := expect(newJob("system", PRIO_System), "paid"); waited >= 300*time.Millisecond {
+		t.Fatalf("system job waited for %s before going to the paid node", waited)
+	}
+	waitForSpend(2)
+
+	// the budget is spent, so the job waits for the free node
+	engine.AddJob(newJob("over-budget", PRIO_User))
+	select {
+	case got := <-ran:
+		t.Fatalf("over-budget job was run: %s", got)
+	case <-time.After(600 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case got := <-ran:
+		if got != "over-budget@free" {
+			t.Fatalf("expected over-budget job to run on the free node, got %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("over-budget job was not run")
+	}
+
+	cost := 0.0
+	for key, usage := range engine.TakeUsage() {
+		if key.Endpoint == "free" && usage.Cost != 0 {
+			t.Fatalf("free node's usage has cost: %+v", usage)
+		}
+		cost += usage.Cost
+	}
+	if cost != 2 {
+		t.Fatalf("expected usage to cost 2, got %.2f", cost)
+	}
+}
+
+func hasQuotaSpend(engine *InferenceEngine, subject string, spend float64) bool {
+	for _, state := range engine.GetQuotaStates() {
+		if state.Subject == subject {
+			return state.Spend == spend
+		}
+	}
+
+	return false
+}
@@ -6,107 +6,125 @@ import (
 	"time"
 )
 
-const InternalQueuesSize = 8192
-
 func (ie *InferenceEngine) Run() {
-	jobsBuffer := map[JobPriority][]*ComputeJob{
-		PRIO_System:     []*ComputeJob{},
-		PRIO_Kernel:     []*ComputeJob{},
-		PRIO_User:       []*ComputeJob{},
-		PRIO_Background: []*ComputeJob{},
-	}
-
 	go func() {
 		if ie.settings.TermUI {
-			ie.ui(jobsBuffer, &ie.statsLock)
-			os.Exit(0)
+			ie.ui()
+			// quitting the UI interrupts the process, as Ctrl-C does without the UI,
+			// so the shutdown handlers of the server get to run
+			if self, err := os.FindProcess(os.Getpid()); err != nil || self.Signal(os.Interrupt) != nil {
+				os.Exit(0)
+			}
 		} else {
 			for {
-				ie.PrintTop(jobsBuffer, &ie.statsLock)
+				ie.PrintTop()
 				time.Sleep(ie.settings.TopInterval)
 			}
 		}
 	}()
 
-	// first let's start our primary cycle....!
-	jobQueues := make([]chan *ComputeJob, PRIO_Background+1)
-	for i := 0; i < int(PRIO_Background)+1; i++ {
-		jobQueues[i] = make(chan *ComputeJob, InternalQueuesSize)
+	// node workers are serving the queue of the node's first job type
+	nodeQueue := func(node *InferenceNode) *jobQueue {
+		return ie.sharedJobs[node.JobTypes[0]]
 	}
 
-	embeddingJobs := make([]chan *ComputeJob, PRIO_Background+1)
-	for i := 0; i < int(PRIO_Background)+1; i++ {
-		embeddingJobs[i] = make(chan *ComputeJob, InternalQueuesSize)
+	routeJobs := func(jobs []*ComputeJob) {
+		for _, job := range jobs {
+			if !isAnyModelMask(job.ModelMask) {
+				// job needs specific model, so it goes to the node serving it
+				node, err := ie.pickNodeForModel(job)
+				if err != nil {
+					ie.failJob(job, err)
+					continue
+				}
+				node.pinnedJobs.push(job)
+				continue
+			}
+			if queue, exists := ie.sharedJobs[job.JobType]; exists {
+				queue.push(job)
+			}
+		}
 	}
 
+	deferredJobsTicker := time.NewTicker(deferredJobsCheckInterval)
+	defer deferredJobsTicker.Stop()
 	for {
 		select {
 		case jobs := <-ie.IncomingJobs:
+			alive := make([]*ComputeJob, 0, len(jobs))
 			for _, job := range jobs {
-				if job.JobType == JT_Completion {
-					jobQueues[job.Priority] <- job
+				if ie.dropIfCancelled(job) {
+					continue
 				}
-				if job.JobType == JT_Embeddings {
-					embeddingJobs[job.Priority] <- job
+				if job.receivedAt.IsZero() {
+					// job was sent to the channel directly
+					job.receivedAt = time.Now()
 				}
+				alive = append(alive, job)
 			}
+			routeJobs(ie.admitJobs(alive))
+		case <-deferredJobsTicker.C:
+			routeJobs(ie.admitDeferredJobs())
+		case <-ie.quotaReleased:
+			routeJobs(ie.admitDeferredJobs())
 		case node := <-ie.AddNodeChan:
+			if len(node.JobTypes) == 0 {
+				// config validation rejects such nodes, but they still can be added directly
+				ie.lg.Error().Msgf("compute node %s has no job types, not using it", node.EndpointUrl)
+				continue
+			}
+			if node.removed {
+				// node was removed while its models were being detected
+				continue
+			}
 			node.LastIdleAt = time.Now()
+			node.pinnedJobs = newJobQueue(ie.fairClock)
+			ie.nodesLock.Lock()
 			ie.Nodes = append(ie.Nodes, node)
-			nodeIdx := len(ie.Nodes) - 1
+			ie.nodesLock.Unlock()
 			// since we have added a new node, let's start the feeders for it
-			for idx := 0; idx < node.MaxRequests; idx++ {
-				if node.JobTypes[0] == JT_Completion {
-					go ie.singleRequestWorker(node, jobQueues, nodeIdx)
-				}
-				if node.JobTypes[0] == JT_Embeddings {
-					go ie.singleRequestWorker(node, embeddingJobs, nodeIdx)
-				}
-			}
+			ie.startNodeWorkers(node, nodeQueue(node))
+		case removal := <-ie.removeNodeChan:
+			ie.removeNode(removal)
+		case update := <-ie.updateNodeChan:
+			ie.updateNodeLimits(update, nodeQueue(update.node))
 		}
 	}
 }
 
-func (ie *InferenceEngine) singleRequestWorker(node *InferenceNode, jobQueues []chan *ComputeJob, nodeIdx int) {
-	batch := make([]*ComputeJob, 0, node.MaxBatchSize)
-	firstElementTs := time.Now()
-	batchIsReady := false
+func (ie *InferenceEngine) singleRequestWorker(node *InferenceNode, sharedJobs *jobQueue) {
 	for {
-		for _, ch := range jobQueues {
-			for {
-				gotTheJob := false
-				select {
-				case job := <-ch:
-					if job != nil {
-						// let's check if this job is compatible with our engine at all..!
-						// run the job...!
-						gotTheJob = true
-						batch = append(batch, job)
-						if len(batch) == 1 {
-							firstElementTs = time.Now()
-						}
-						if len(batch) == node.MaxBatchSize || (time.Since(firstElementTs) > 50*time.Millisecond && len(batch) > 0) {
-							batchIsReady = true
-							break
-						}
-					}
-				default:
-				}
-
-				if !gotTheJob || batchIsReady {
-					break
-				}
-			}
-
-			if batchIsReady {
-				break
+		// batch is always empty here, so exiting worker leaves no jobs behind
+		if exit, last := node.releaseWorker(); exit {
+			if last {
+				ie.finishDraining(node)
 			}
+			return
+		}
+		scheduler := node.GetScheduler()
+		if !ie.waitUntilNodeIsUsable(node) {
+			time.Sleep(scheduler.IdlePoll)
+			continue
 		}
+		_, maxBatchSize := node.GetLimits()
 
+		// paid node only takes jobs free nodes can't keep up with, its idle poll re-checks waiting jobs
+		batch := ie.collectBatch(node.pinnedJobs, sharedJobs, scheduler, maxBatchSize, node.GetMaxBatchTokens(), ie.spillFilter(node))
+
+		// jobs could have been cancelled while the batch was being collected
+		batch = ie.filterCancelled(batch)
 		if len(batch) > 0 {
 			// we have a batch of jobs to run...!
-			if atomic.AddInt32(&ie.Nodes[nodeIdx].RequestsRunning, 1) == 1 {
-				ie.Nodes[nodeIdx].TotalTimeIdle += time.Since(ie.Nodes[nodeIdx].LastIdleAt)
+			if atomic.AddInt32(&node.RequestsRunning, 1) == 1 {
+				ie.statsLock.Lock()
+				node.TotalTimeIdle += time.Since(node.LastIdleAt)
+				ie.statsLock.Unlock()
+			}
+			for _, job := range batch {
+				// record the model which is actually going to run the job
+				if job.GenerationSettings != nil {
+					job.GenerationSettings.Model, _ = matchNodeModel(node, job.ModelMask)
+				}
 			}
 			ie.statsLock.Lock()
 			for _, job := range batch {
@@ -114,43 +132,56 @@ func (ie *InferenceEngine) singleRequestWorker(node *InferenceNode, jobQueues []
 				ie.ProcessesTotalTimeWaiting[job.Process] += time.Since(job.receivedAt)
 			}
 			ie.statsLock.Unlock()
-			node.RunBatch(ie.ComputeFunction, batch, nodeIdx, func(nodeIdx int, ts time.Time) {
-				ie.Nodes[nodeIdx].TotalTimeConsumed += time.Since(ts)
+			// callbacks get the jobs which are done and the ones which failed, a batch can be split between them
+			node.RunBatch(ie.ComputeFunction, batch, func(ts time.Time, batch []*ComputeJob) {
+				cost := node.GetCost()
+				ie.statsLock.Lock()
+				node.TotalTimeConsumed += time.Since(ts)
+				node.TotalRequestsProcessed++
+				node.TotalJobsProcessed += uint64(len(batch))
 				ie.TotalRequestsProcessed++
 				ie.TotalJobsProcessed += uint64(len(batch))
 				ie.TotalTimeConsumed += time.Since(ts)
-				ie.statsLock.Lock()
 				for _, job := range batch {
 					ie.ProcessesTotalTimeConsumed[job.Process] += time.Since(ts)
+					ie.ProcessesTotalPromptTokens[job.Process] += atomic.LoadUint64(&job.promptTokens)
+					ie.ProcessesTotalGeneratedTokens[job.Process] += atomic.LoadUint64(&job.generatedTokens)
+					ie.ProcessesTotalSpend[job.Process] += cost.jobCost(job, time.Since(ts)/time.Duration(len(batch)), false)
 				}
 				ie.statsLock.Unlock()
-				//ie.Nodes[nodeIdx].RequestsRunning--
-				ie.Nodes[nodeIdx].TotalRequestsProcessed++
-				ie.Nodes[nodeIdx].TotalJobsProcessed += uint64(len(batch))
-			}, func(nodeIdx int, ts time.Time, err error) {
-				// fmt.Printf("Batch of %d jobs on node %s failed\n", len(batch[canSendJobType]), ie.Nodes[nodeIdx].EndpointUrl)
-				ie.Nodes[nodeIdx].TotalTimeWaisted += time.Since(ts)
+				//node.RequestsRunning--
+				node.health.recordSuccess(time.Since(ts) / time.Duration(len(batch)))
+				ie.quotas.accountGPUTime(batch, time.Since(ts))
+				ie.quotas.accountSpend(cost, batch, time.Since(ts), false)
+				ie.usage.accountBatch(node, batch, time.Since(ts), false)
+				for _, job := range batch {
+					ie.releaseQuota(job)
+				}
+			}, func(ts time.Time, batch []*ComputeJob, err error) {
+				cost := node.GetCost()
+				// fmt.Printf("Batch of %d jobs on node %s failed\n", len(batch[canSendJobType]), node.EndpointUrl)
+				ie.quotas.accountGPUTime(batch, time.Since(ts))
+				ie.quotas.accountSpend(cost, batch, time.Since(ts), true)
+				ie.usage.accountBatch(node, batch, time.Since(ts), true)
+				ie.statsLock.Lock()
+				node.TotalTimeWaisted += time.Since(ts)
+				node.TotalRequestsFailed++
+				node.TotalJobsFailed += uint64(len(batch))
+				node.LastFailure = time.Now()
 				ie.TotalTimeWaisted += time.Since(ts)
 				ie.TotalRequestsFailed++
-
-				ie.Nodes[nodeIdx].TotalRequestsFailed++
-				ie.Nodes[nodeIdx].TotalJobsFailed += uint64(len(batch))
-
-				ie.Nodes[nodeIdx].LastFailure = time.Now()
-				go func() {
-					ie.IncomingJobs <- batch
-				}()
+				for _, job := range batch {
+					ie.ProcessesTotalSpend[job.Process] += cost.jobCost(job, time.Since(ts)/time.Duration(len(batch)), true)
+				}
+				ie.statsLock.Unlock()
+				ie.handleBatchFailure(node, batch, err)
 			})
-			batch = []*ComputeJob{}
-			batchIsReady = false
-
-			atomic.AddInt32(&ie.Nodes[nodeIdx].RequestsRunning, -1)
 
-			if atomic.LoadInt32(&ie.Nodes[nodeIdx].RequestsRunning) == 0 {
-				ie.Nodes[nodeIdx].LastIdleAt = time.Now()
+			if atomic.AddInt32(&node.RequestsRunning, -1) == 0 {
+				ie.statsLock.Lock()
+				node.LastIdleAt = time.Now()
+				ie.statsLock.Unlock()
 			}
-		} else {
-			time.Sleep(100 * time.Millisecond)
 		}
 	}
 }
@@ -4,11 +4,14 @@ import (
 	"github.com/d0rc/agent-os/engines"
 	"github.com/rs/zerolog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type InferenceEngine struct {
-	Nodes []*InferenceNode
+	// Nodes - nodes serving jobs, use GetNodes outside of the scheduler
+	Nodes     []*InferenceNode
+	nodesLock sync.RWMutex
 
 	// statistics
 	TotalJobsProcessed         uint64
@@ -19,22 +22,77 @@ type InferenceEngine struct {
 	ProcessesTotalRequests     map[string]uint64
 	ProcessesTotalTimeConsumed map[string]time.Duration
 	ProcessesTotalTimeWaiting  map[string]time.Duration
+	// tokens reported by engines, or estimated if they don't report usage
+	ProcessesTotalPromptTokens    map[string]uint64
+	ProcessesTotalGeneratedTokens map[string]uint64
+	// spent on paid nodes, see NodeCost
+	ProcessesTotalSpend map[string]float64
 
 	// control channels
 	AddNodeChan         chan *InferenceNode
+	removeNodeChan      chan *nodeRemoval
+	updateNodeChan      chan *nodeUpdate
 	IncomingJobs        chan []*ComputeJob
 	InferenceDone       chan *InferenceNode
 	TotalTimeScheduling time.Duration
 
+	// jobs which can run on any node serving their job type
+	sharedJobs map[JobType]*jobQueue
+	fairClock  *fairClock
+
+	quotas        *quotaManager
+	deferredJobs  []*ComputeJob // over-quota jobs, waiting for their quotas
+	quotaReleased chan struct{}
+
+	usage *usageAccounting
+
 	ComputeFunction     ComputeFunction
 	TotalTimeWaisted    time.Duration
 	TotalRequestsFailed uint64
+	TotalJobsCancelled  uint64
+	TotalJobsFailed     uint64
 	settings            *InferenceEngineSettings
-	statsLock           sync.RWMutex
+	// statsLock guards stats of the processes, totals of the engine and stats of its nodes
+	statsLock sync.RWMutex
 
 	lg zerolog.Logger
 }
 
+// nodeStats - snapshot of the node's counters, which are updated by its workers
+type nodeStats struct {
+	requests, jobs                     uint64
+	requestsFailed, jobsFailed         uint64
+	timeConsumed, timeIdle, timeWasted time.Duration
+}
+
+func (ie *InferenceEngine) getNodeStats(node *InferenceNode) nodeStats {
+	ie.statsLock.RLock()
+	defer ie.statsLock.RUnlock()
+
+	return nodeStats{
+		requests:       node.TotalRequestsProcessed,
+		jobs:           node.TotalJobsProcessed,
+		requestsFailed: node.TotalRequestsFailed,
+		jobsFailed:     node.TotalJobsFailed,
+		timeConsumed:   node.TotalTimeConsumed,
+		timeIdle:       node.TotalTimeIdle,
+		timeWasted:     node.TotalTimeWaisted,
+	}
+}
+
+// queuedJobsCount - jobs waiting for a node
+func (ie *InferenceEngine) queuedJobsCount() int {
+	cnt := 0
+	for _, queue := range ie.sharedJobs {
+		cnt += queue.len()
+	}
+	for _, node := range ie.GetNodes() {
+		cnt += node.pinnedJobsCount()
+	}
+
+	return cnt
+}
+
 func (ie *InferenceEngine) AccountProcessRequest(process string) {
 	ie.statsLock.Lock()
 	defer ie.statsLock.Unlock()
@@ -45,22 +103,45 @@ type InferenceEngineSettings struct {
 	TopInterval time.Duration
 	TermUI      bool
 	LogChan     chan string
+	// MaxJobRetries - times the job is re-scheduled after failed batches, DefaultMaxJobRetries if not set
+	MaxJobRetries int
+	// Quotas - limits of compute used by processes and tags, can be changed with SetQuotas
+	Quotas []*Quota
 }
 
 func NewInferenceEngine(lg zerolog.Logger, f ComputeFunction, settings *InferenceEngineSettings) *InferenceEngine {
+	clock := newFairClock()
+	var quotas []*Quota
+	if settings != nil {
+		quotas = settings.Quotas
+	}
 	return &InferenceEngine{
-		Nodes:                      []*InferenceNode{},
-		AddNodeChan:                make(chan *InferenceNode, 16384),
-		IncomingJobs:               make(chan []*ComputeJob),
-		InferenceDone:              make(chan *InferenceNode, 16384),
-		ProcessesTotalRequests:     map[string]uint64{},
-		ProcessesTotalJobs:         make(map[string]uint64),
-		ProcessesTotalTimeWaiting:  make(map[string]time.Duration),
-		ProcessesTotalTimeConsumed: make(map[string]time.Duration),
-		ComputeFunction:            f,
-		settings:                   settings,
-		statsLock:                  sync.RWMutex{},
-		lg:                         lg,
+		Nodes:                         []*InferenceNode{},
+		AddNodeChan:                   make(chan *InferenceNode, 16384),
+		removeNodeChan:                make(chan *nodeRemoval, 1024),
+		updateNodeChan:                make(chan *nodeUpdate, 1024),
+		IncomingJobs:                  make(chan []*ComputeJob),
+		InferenceDone:                 make(chan *InferenceNode, 16384),
+		ProcessesTotalRequests:        map[string]uint64{},
+		ProcessesTotalJobs:            make(map[string]uint64),
+		ProcessesTotalTimeWaiting:     make(map[string]time.Duration),
+		ProcessesTotalTimeConsumed:    make(map[string]time.Duration),
+		ProcessesTotalPromptTokens:    make(map[string]uint64),
+		ProcessesTotalGeneratedTokens: make(map[string]uint64),
+		ProcessesTotalSpend:           make(map[string]float64),
+		sharedJobs: map[JobType]*jobQueue{
+			JT_Completion: newJobQueue(clock),
+			JT_Embeddings: newJobQueue(clock),
+		},
+		fairClock:       clock,
+		quotas:          newQuotaManager(quotas),
+		deferredJobs:    make([]*ComputeJob, 0),
+		quotaReleased:   make(chan struct{}, 1),
+		usage:           newUsageAccounting(),
+		ComputeFunction: f,
+		settings:        settings,
+		statsLock:       sync.RWMutex{},
+		lg:              lg,
 	}
 }
 
@@ -85,10 +166,9 @@ func (ie *InferenceEngine) AddNode(node *InferenceNode) chan *InferenceNode {
 		Token:                 node.Token,
 	}
 	autodetectFinished := make(chan *InferenceNode, 1)
-	go func(node *InferenceNode) {
-		engines.StartInferenceEngine(ie.lg, newRemoteEngine, doneChannel)
-		node.RemoteEngine = newRemoteEngine
-	}(node)
+	// set before detection, as it's read once detection is done
+	node.RemoteEngine = newRemoteEngine
+	go engines.StartInferenceEngine(ie.lg, newRemoteEngine, doneChannel)
 
 	go func(node *InferenceNode) {
 		<-doneChannel
@@ -110,13 +190,62 @@ func (ie *InferenceEngine) AddNode(node *InferenceNode) chan *InferenceNode {
 }
 
 func (ie *InferenceEngine) AddJob(job *ComputeJob) {
+	if job.EstimatedTokens == 0 && job.JobType == JT_Completion {
+		job.EstimatedTokens = EstimatePromptTokens(job.GenerationSettings)
+	}
 	job.receivedAt = time.Now()
 	ie.IncomingJobs <- []*ComputeJob{job}
 }
 
+// failJob - reports the job can't be run, without blocking the scheduler
+func (ie *InferenceEngine) failJob(job *ComputeJob, err error) {
+	ie.releaseQuota(job)
+	ie.lg.Error().Err(err).Msgf("job %s of process %s failed", job.JobId, job.Process)
+	if job.ComputeResult == nil || job.ComputeResult.ErrorChannel == nil {
+		return
+	}
+
+	select {
+	case job.ComputeResult.ErrorChannel <- err:
+	default:
+	}
+}
+
+// dropIfCancelled - removes cancelled job instead of scheduling it,
+// returns true if the job was dropped
+func (ie *InferenceEngine) dropIfCancelled(job *ComputeJob) bool {
+	err := job.cancelled()
+	if err == nil {
+		return false
+	}
+
+	atomic.AddUint64(&ie.TotalJobsCancelled, 1)
+	ie.releaseQuota(job)
+	if job.ComputeResult != nil && job.ComputeResult.ErrorChannel != nil {
+		select {
+		case job.ComputeResult.ErrorChannel <- err:
+		default:
+		}
+	}
+
+	return true
+}
+
+// filterCancelled - drops cancelled jobs from the batch, keeping the order
+func (ie *InferenceEngine) filterCancelled(batch []*ComputeJob) []*ComputeJob {
+	filtered := batch[:0]
+	for _, job := range batch {
+		if !ie.dropIfCancelled(job) {
+			filtered = append(filtered, job)
+		}
+	}
+
+	return filtered
+}
+
 func (ie *InferenceEngine) WaitForNodeWithEmbeddings() (string, int, error) {
 	for {
-		for _, node := range ie.Nodes {
+		for _, node := range ie.GetNodes() {
 			if node.RemoteEngine.EmbeddingsDims != nil {
 				return node.RemoteEngine.Models[0], int(*node.RemoteEngine.EmbeddingsDims), nil
 			}
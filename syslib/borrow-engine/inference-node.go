@@ -2,6 +2,7 @@ package borrow_engine
 
 import (
 	"github.com/d0rc/agent-os/engines"
+	"sync"
 	"time"
 )
 
@@ -10,7 +11,9 @@ type InferenceNode struct {
 	EmbeddingsEndpointUrl string
 	MaxRequests           int
 	MaxBatchSize          int
-	JobTypes              []JobType
+	// MaxBatchTokens - budget of estimated prompt tokens of the batch, 0 - no budget
+	MaxBatchTokens int
+	JobTypes       []JobType
 
 	TotalJobsProcessed     uint64
 	TotalRequestsProcessed uint64
@@ -26,23 +29,107 @@ type InferenceNode struct {
 	LastFailure         time.Time
 	Protocol            string
 	Token               string
+	// Scheduler - how workers of the node form batches, defaults are used if not set
+	Scheduler *SchedulerSettings
+	// Cost - price of the node's compute, nil - node is free
+	Cost *NodeCost
+
+	// jobs which can only be run on this node
+	pinnedJobs *jobQueue
+
+	health nodeHealth
+
+	// prompt tokens of jobs with usage reported by the engine, to track accuracy of estimates
+	estimatedPromptTokens uint64
+	reportedPromptTokens  uint64
+
+	// limitsLock guards limits, which can be changed on the running node, and workers state
+	limitsLock sync.Mutex
+	workers    int
+	draining   bool
+	removed    bool
+	drained    chan struct{}
+	scheduler  *SchedulerSettings // Scheduler with defaults applied
+}
+
+// GetLimits - returns max-requests and max-batch-size the node is currently running with
+func (n *InferenceNode) GetLimits() (int, int) {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	return n.MaxRequests, n.MaxBatchSize
+}
+
+// IsDraining - node was removed and finishes its in-flight batches
+func (n *InferenceNode) IsDraining() bool {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	return n.draining
+}
+
+// releaseWorker - stops workers above max-requests or all of them once node is draining,
+// returns if the calling worker should exit and if it was the last one
+func (n *InferenceNode) releaseWorker() (bool, bool) {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	if !n.draining && n.workers <= n.MaxRequests {
+		return false, false
+	}
+
+	n.workers--
+	return true, n.workers == 0
 }
 
-func (n InferenceNode) RunBatch(cf ComputeFunction, jobs []*ComputeJob, nodeIdx int,
-	f func(int, time.Time),
-	failFunc func(int, time.Time, error)) {
+func (n *InferenceNode) pinnedJobsCount() int {
+	return n.pinnedJobs.len()
+}
+
+func (n *InferenceNode) RunBatch(cf ComputeFunction, jobs []*ComputeJob,
+	f func(time.Time, []*ComputeJob),
+	failFunc func(time.Time, []*ComputeJob, error)) {
 	// fmt.Printf("Running batch of %d jobs on node %s\n", len(jobs), n.EndpointUrl)
 	// sleep for random time between 1 and 5 seconds
 	ts := time.Now()
 
-	_, err := cf[jobs[0].JobType](&n, jobs)
+	done, err := cf[jobs[0].JobType](n, jobs)
 	if err != nil {
 		// we need to retry the jobs, or send jobs back to the general queue
 		// also it's a good idea to account engine failure at this point...
-		failFunc(nodeIdx, ts, err)
+		failed := failedJobs(jobs, done)
+		if len(failed) == len(jobs) {
+			failFunc(ts, jobs, err)
+			return
+		}
+		// time of the batch is shared by the jobs, callbacks account it from the given start,
+		// so each of them gets a part proportional to the number of its jobs
+		elapsed := time.Since(ts)
+		f(time.Now().Add(-elapsed*time.Duration(len(done))/time.Duration(len(jobs))), done)
+		failFunc(time.Now().Add(-elapsed*time.Duration(len(failed))/time.Duration(len(jobs))), failed, err)
 		return
 	}
 
 	//fmt.Printf("Batch of %d jobs on node %s finished\n", len(jobs), n.EndpointUrl)
-	f(nodeIdx, ts)
+	f(ts, jobs)
+}
+
+// failedJobs - jobs of the batch which are not done
+func failedJobs(jobs, done []*ComputeJob) []*ComputeJob {
+	if len(done) == 0 {
+		return jobs
+	}
+
+	isDone := make(map[*ComputeJob]bool, len(done))
+	for _, job := range done {
+		isDone[job] = true
+	}
+	failed := make([]*ComputeJob, 0, len(jobs)-len(done))
+	for _, job := range jobs {
+		if !isDone[job] {
+			failed = append(failed, job)
+		}
+	}
+
+	return failed
 }
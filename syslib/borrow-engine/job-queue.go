@@ -0,0 +1,247 @@
+package borrow_engine
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// flowKey - jobs of the same process and priority are served in order they were received
+type flowKey struct {
+	priority JobPriority
+	process  string
+}
+
+// jobQueue - jobs waiting for a node, either shared by all nodes serving the job type
+// or pinned to a single node; workers block on wakeup channel instead of polling
+type jobQueue struct {
+	lock   sync.Mutex
+	flows  map[flowKey][]*ComputeJob
+	starts map[flowKey]float64 // virtual time head job of the flow has started waiting at
+	length int
+	notify chan struct{} // closed once jobs are added
+	clock  *fairClock
+}
+
+// fairClock - virtual time of weighted fair queueing, shared by all queues of the engine,
+// so jobs of a process are accounted the same whichever queue they wait in
+type fairClock struct {
+	lock        sync.Mutex
+	virtualTime float64
+	finish      map[flowKey]float64
+}
+
+func newFairClock() *fairClock {
+	return &fairClock{
+		finish: make(map[flowKey]float64),
+	}
+}
+
+func newJobQueue(clock *fairClock) *jobQueue {
+	return &jobQueue{
+		flows:  make(map[flowKey][]*ComputeJob),
+		starts: make(map[flowKey]float64),
+		notify: make(chan struct{}),
+		clock:  clock,
+	}
+}
+
+func (q *jobQueue) push(jobs ...*ComputeJob) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, job := range jobs {
+		key := flowKey{priority: job.Priority, process: job.Process}
+		if len(q.flows[key]) == 0 {
+			q.starts[key] = q.clock.startTag(key)
+		}
+		q.flows[key] = append(q.flows[key], job)
+	}
+	q.length += len(jobs)
+
+	// wake up every waiting worker
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// wakeup - returned channel is closed once jobs are added to the queue
+func (q *jobQueue) wakeup() chan struct{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.notify
+}
+
+func (q *jobQueue) len() int {
+	if q == nil {
+		return 0
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.length
+}
+
+// lenByPriority - number of queued jobs of each priority
+func (q *jobQueue) lenByPriority() map[JobPriority]int {
+	counts := make(map[JobPriority]int)
+	if q == nil {
+		return counts
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for key, flow := range q.flows {
+		counts[key.priority] += len(flow)
+	}
+
+	return counts
+}
+
+// drain - removes all jobs from the queue
+func (q *jobQueue) drain() []*ComputeJob {
+	if q == nil {
+		return nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	jobs := make([]*ComputeJob, 0, q.length)
+	for key, flow := range q.flows {
+		jobs = append(jobs, flow...)
+		delete(q.flows, key)
+		delete(q.starts, key)
+	}
+	q.length = 0
+
+	return jobs
+}
+
+// bestRank - rank of the job scheduler would take next, lower rank goes first
+func (q *jobQueue) bestRank(scheduler *SchedulerSettings, fit *batchFit, now time.Time) (float64, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	_, _, rank, found := q.best(scheduler, fit, now)
+	return rank, found
+}
+
+// takeBetterThan - removes the job scheduler would take next,
+// if limited it's only taken when its rank is below the limit
+func (q *jobQueue) takeBetterThan(scheduler *SchedulerSettings, fit *batchFit, limit float64, limited bool) *ComputeJob {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key, idx, rank, found := q.best(scheduler, fit, time.Now())
+	if !found || (limited && rank >= limit) {
+		return nil
+	}
+
+	flow := q.flows[key]
+	job := flow[idx]
+	if idx == 0 {
+		flow[0] = nil
+		flow = flow[1:]
+	} else {
+		flow = append(flow[:idx], flow[idx+1:]...)
+	}
+	q.length--
+	if scheduler.Policy == SP_WeightedFair {
+		q.clock.advance(key, q.starts[key], rank)
+	}
+	if len(flow) == 0 {
+		delete(q.flows, key)
+		delete(q.starts, key)
+	} else {
+		q.flows[key] = flow
+		q.starts[key] = q.clock.startTag(key)
+	}
+
+	return job
+}
+
+// best - should be called with lock held, returns the flow to take the job from, index of the job
+// in the flow and its rank, only jobs which fit into the batch are considered
+func (q *jobQueue) best(scheduler *SchedulerSettings, fit *batchFit, now time.Time) (flowKey, int, float64, bool) {
+	var bestKey flowKey
+	var bestHead *ComputeJob
+	bestIdx := 0
+	bestRank := math.Inf(1)
+	for key, flow := range q.flows {
+		idx := candidate(flow, fit)
+		if idx < 0 {
+			continue
+		}
+		head := flow[idx]
+		rank := 0.0
+		switch scheduler.Policy {
+		case SP_WeightedFair:
+			// virtual finish time of the head job, job of less weighted flow costs more virtual time
+			rank = q.starts[key] + 1/float64(scheduler.PriorityWeights[key.priority])
+		case SP_Aging:
+			promotions := int(now.Sub(head.receivedAt) / scheduler.AgingInterval)
+			rank = math.Max(float64(int(key.priority)-promotions), float64(PRIO_System))
+		default:
+			rank = float64(key.priority)
+		}
+
+		// among equally ranked flows the job of the most similar length goes first, then the oldest one
+		if bestHead == nil || rank < bestRank ||
+			(rank == bestRank && fit.distance(head) < fit.distance(bestHead)) ||
+			(rank == bestRank && fit.distance(head) == fit.distance(bestHead) && head.receivedAt.Before(bestHead.receivedAt)) {
+			bestKey, bestIdx, bestHead, bestRank = key, idx, head, rank
+		}
+	}
+
+	return bestKey, bestIdx, bestRank, bestHead != nil
+}
+
+// candidate - index of the flow's job to be batched next, or -1 if none of them fits; the head of
+// the flow goes first, unless batch has a token budget, then job of the most similar length among
+// the first tokenLookahead jobs is picked, so the flow is served roughly in order
+func candidate(flow []*ComputeJob, fit *batchFit) int {
+	if !fit.limited() || fit.empty {
+		if fit.fits(flow[0]) {
+			return 0
+		}
+		return -1
+	}
+
+	best := -1
+	for idx := 0; idx < len(flow) && idx < tokenLookahead; idx++ {
+		if fit.fits(flow[idx]) && (best < 0 || fit.distance(flow[idx]) < fit.distance(flow[best])) {
+			best = idx
+		}
+	}
+
+	return best
+}
+
+// startTag - virtual time the next job of the flow starts waiting at,
+// flows which were idle don't get credit for the time they had no jobs
+func (c *fairClock) startTag(key flowKey) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return math.Max(c.finish[key], c.virtualTime)
+}
+
+// advance - accounts the job taken from the flow
+func (c *fairClock) advance(key flowKey, start, finish float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.finish[key] = finish
+	if start > c.virtualTime {
+		c.virtualTime = start
+		// flows which are behind the virtual time start from it anyway
+		for k, f := range c.finish {
+			if f <= c.virtualTime {
+				delete(c.finish, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package borrow_engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+const AnyModel = "*"
+
+// MatchModelMask - glob match of model name against the mask, `*` matches any
+// sequence of characters (including `/`), `?` matches a single character,
+// comparison is case-insensitive; empty mask matches any model
+func MatchModelMask(mask, model string) bool {
+	if mask == "" || mask == AnyModel {
+		return true
+	}
+
+	return matchGlob([]rune(strings.ToLower(mask)), []rune(strings.ToLower(model)))
+}
+
+func matchGlob(mask, s []rune) bool {
+	// classic two-pointers wildcard matching with backtracking to the last star
+	maskIdx, sIdx := 0, 0
+	starIdx, starMatchIdx := -1, 0
+	for sIdx < len(s) {
+		switch {
+		case maskIdx < len(mask) && (mask[maskIdx] == '?' || mask[maskIdx] == s[sIdx]):
+			maskIdx++
+			sIdx++
+		case maskIdx < len(mask) && mask[maskIdx] == '*':
+			starIdx = maskIdx
+			starMatchIdx = sIdx
+			maskIdx++
+		case starIdx != -1:
+			maskIdx = starIdx + 1
+			starMatchIdx++
+			sIdx = starMatchIdx
+		default:
+			return false
+		}
+	}
+
+	for maskIdx < len(mask) && mask[maskIdx] == '*' {
+		maskIdx++
+	}
+
+	return maskIdx == len(mask)
+}
+
+func isAnyModelMask(mask string) bool {
+	return mask == "" || mask == AnyModel
+}
+
+// nodeServesJobType - node workers are started for the first job type only
+func nodeServesJobType(node *InferenceNode, jobType JobType) bool {
+	return len(node.JobTypes) > 0 && node.JobTypes[0] == jobType
+}
+
+// matchNodeModel - returns the first model of the node satisfying the mask
+func matchNodeModel(node *InferenceNode, mask string) (string, bool) {
+	if node.RemoteEngine == nil {
+		return "", isAnyModelMask(mask)
+	}
+
+	for _, model := range node.RemoteEngine.Models {
+		if MatchModelMask(mask, model) {
+			return model, true
+		}
+	}
+
+	if isAnyModelMask(mask) {
+		// node has not reported its models, but any model will do
+		return "", true
+	}
+
+	return "", false
+}
+
+// routingRank - lower rank gets jobs first: free nodes go before paid ones and the healthy
+// before degraded, quarantined nodes of any cost go last
+func routingRank(node *InferenceNode) int {
+	health, _ := node.GetHealth()
+	if health == NH_Quarantined {
+		return 4
+	}
+	if node.IsPaid() {
+		return 2 + int(health)
+	}
+
+	return int(health)
+}
+
+// pickNodeForModel - selects the node of the lowest routing rank and then the least loaded one
+// which can run the job, only used for jobs with specific model mask
+func (ie *InferenceEngine) pickNodeForModel(job *ComputeJob) (*InferenceNode, error) {
+	var selectedNode *InferenceNode
+	selectedLoad := 0
+	selectedRank := 0
+	for _, node := range ie.Nodes {
+		if !nodeServesJobType(node, job.JobType) {
+			continue
+		}
+		if _, ok := matchNodeModel(node, job.ModelMask); !ok {
+			continue
+		}
+
+		// quarantined node still takes the job, if it's the only one serving the model
+		rank := routingRank(node)
+		load := node.pinnedJobsCount()
+		if selectedNode == nil || rank < selectedRank ||
+			(rank == selectedRank && load < selectedLoad) {
+			selectedNode = node
+			selectedLoad = load
+			selectedRank = rank
+		}
+	}
+
+	if selectedNode == nil {
+		return nil, fmt.Errorf("%w: %s job for model `%s`",
+			ErrNoNodeForModel, jobTypeName(job.JobType), job.ModelMask)
+	}
+
+	return selectedNode, nil
+}
@@ -0,0 +1,28 @@
+package borrow_engine
+
+import "testing"
+
+func TestMatchModelMask(t *testing.T) {
+	cases := []struct {
+		mask  string
+		model string
+		match bool
+	}{
+		{"", "TheBloke/zephyr-7B-beta-AWQ", true},
+		{"*", "TheBloke/zephyr-7B-beta-AWQ", true},
+		{"*zephyr*", "TheBloke/zephyr-7B-beta-AWQ", true},
+		{"thebloke/*", "TheBloke/zephyr-7B-beta-AWQ", true},
+		{"*mistral*", "TheBloke/zephyr-7B-beta-AWQ", false},
+		{"mistral-?b", "mistral-7b", true},
+		{"mistral-?b", "mistral-13b", false},
+		{"mistral-large:latest", "mistral-large:latest", true},
+		{"mistral", "mistral-large:latest", false},
+		{"*-awq", "TheBloke/zephyr-7B-beta-AWQ", true},
+	}
+
+	for _, c := range cases {
+		if MatchModelMask(c.mask, c.model) != c.match {
+			t.Errorf("MatchModelMask(%q, %q) != %v", c.mask, c.model, c.match)
+		}
+	}
+}
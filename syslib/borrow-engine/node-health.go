@@ -0,0 +1,351 @@
+package borrow_engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type NodeHealth int
+
+const (
+	NH_Healthy NodeHealth = iota
+	// NH_Degraded - node fails some batches or got much slower than usual,
+	// it still runs jobs, but healthy nodes get them first
+	NH_Degraded
+	// NH_Quarantined - node failed several batches in a row, it gets no jobs
+	// until the detection prompt succeeds on it again
+	NH_Quarantined
+)
+
+const (
+	healthWindowSize            = 20
+	degradedFailureRate         = 0.25
+	degradedLatencyFactor       = 3.0
+	minLatencySamples           = 20
+	quarantineAfterFailures     = 3
+	initialQuarantineBackoff    = 5 * time.Second
+	maxQuarantineBackoff        = 5 * time.Minute
+	degradedNodeCollectionDelay = 50 * time.Millisecond
+	initialRateLimitBackoff     = time.Second
+	maxRateLimitBackoff         = time.Minute
+
+	DefaultMaxJobRetries = 3
+	// MaxRateLimitRetries - rate limits are expected from hosted engines, so jobs wait for them
+	// much longer than they're retried after failures
+	MaxRateLimitRetries = 30
+)
+
+var ErrJobRetriesExhausted = errors.New("compute job failed on all attempts")
+
+// nodeHealth - tracks outcomes of recent batches of the node
+type nodeHealth struct {
+	lock sync.Mutex
+
+	state               NodeHealth
+	outcomes            [healthWindowSize]bool // true for failed batches
+	outcomesCount       int
+	outcomesIdx         int
+	consecutiveFailures int
+
+	// per job latency, fast average reacts to slowdowns, slow one is the node's baseline
+	fastLatency    float64
+	slowLatency    float64
+	latencySamples int
+
+	backoff          time.Duration
+	quarantinedUntil time.Time
+	probing          bool
+
+	// rate limited node takes no jobs until the time engine asked for, or backoff if it didn't
+	rateLimitBackoff time.Duration
+	rateLimitedUntil time.Time
+}
+
+func (h *nodeHealth) recordOutcome(failed bool) {
+	h.outcomes[h.outcomesIdx] = failed
+	h.outcomesIdx = (h.outcomesIdx + 1) % healthWindowSize
+	if h.outcomesCount < healthWindowSize {
+		h.outcomesCount++
+	}
+}
+
+func (h *nodeHealth) failureRate() float64 {
+	if h.outcomesCount == 0 {
+		return 0
+	}
+
+	failures := 0
+	for idx := 0; idx < h.outcomesCount; idx++ {
+		if h.outcomes[idx] {
+			failures++
+		}
+	}
+
+	return float64(failures) / float64(h.outcomesCount)
+}
+
+func (h *nodeHealth) isSlow() bool {
+	return h.latencySamples >= minLatencySamples &&
+		h.fastLatency > degradedLatencyFactor*h.slowLatency
+}
+
+func (h *nodeHealth) updateState() {
+	if h.state == NH_Quarantined {
+		// only successful probe brings the node back
+		return
+	}
+
+	if h.failureRate() >= degradedFailureRate || h.isSlow() {
+		h.state = NH_Degraded
+	} else {
+		h.state = NH_Healthy
+	}
+}
+
+func (h *nodeHealth) recordSuccess(jobLatency time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.recordOutcome(false)
+	h.consecutiveFailures = 0
+	h.rateLimitBackoff = 0
+
+	latency := float64(jobLatency)
+	if h.latencySamples == 0 {
+		h.fastLatency = latency
+		h.slowLatency = latency
+	} else {
+		h.fastLatency += 0.2 * (latency - h.fastLatency)
+		h.slowLatency += 0.02 * (latency - h.slowLatency)
+	}
+	h.latencySamples++
+
+	h.updateState()
+}
+
+// recordFailure - returns true if node has just been quarantined
+func (h *nodeHealth) recordFailure() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.recordOutcome(true)
+	h.consecutiveFailures++
+	if h.state != NH_Quarantined && h.consecutiveFailures >= quarantineAfterFailures {
+		h.quarantine()
+		return true
+	}
+
+	h.updateState()
+	return false
+}
+
+// recordRateLimit - pauses the node for the time engine asked for, or for the backoff,
+// which doubles with every rate limit in a row; returns the pause
+func (h *nodeHealth) recordRateLimit(retryAfter time.Duration) time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.rateLimitBackoff == 0 {
+		h.rateLimitBackoff = initialRateLimitBackoff
+	} else {
+		h.rateLimitBackoff = min(2*h.rateLimitBackoff, maxRateLimitBackoff)
+	}
+	pause := h.rateLimitBackoff
+	if retryAfter > 0 {
+		pause = retryAfter
+	}
+	h.rateLimitedUntil = time.Now().Add(pause)
+
+	return pause
+}
+
+// quarantine - should be called with lock held, every quarantine in a row doubles the backoff
+func (h *nodeHealth) quarantine() {
+	if h.backoff == 0 {
+		h.backoff = initialQuarantineBackoff
+	} else {
+		h.backoff *= 2
+		if h.backoff > maxQuarantineBackoff {
+			h.backoff = maxQuarantineBackoff
+		}
+	}
+	h.state = NH_Quarantined
+	h.quarantinedUntil = time.Now().Add(h.backoff)
+}
+
+// tryStartProbe - returns true if caller should probe the quarantined node,
+// only one probe runs at a time and only after the backoff
+func (h *nodeHealth) tryStartProbe() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.state != NH_Quarantined || h.probing || time.Now().Before(h.quarantinedUntil) {
+		return false
+	}
+	h.probing = true
+
+	return true
+}
+
+func (h *nodeHealth) finishProbe(err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.probing = false
+	if err != nil {
+		h.quarantine()
+		return
+	}
+
+	// node starts clean, but keeps its backoff in case it fails again soon
+	h.state = NH_Healthy
+	h.consecutiveFailures = 0
+	h.outcomesCount = 0
+	h.outcomesIdx = 0
+}
+
+// GetHealth - returns health state of the node and when quarantined node is probed next
+func (n *InferenceNode) GetHealth() (NodeHealth, time.Time) {
+	n.health.lock.Lock()
+	defer n.health.lock.Unlock()
+
+	return n.health.state, n.health.quarantinedUntil
+}
+
+// RateLimitedUntil - time rate limited node takes jobs again, it's in the past if node isn't rate limited
+func (n *InferenceNode) RateLimitedUntil() time.Time {
+	n.health.lock.Lock()
+	defer n.health.lock.Unlock()
+
+	return n.health.rateLimitedUntil
+}
+
+// probeNode - runs the detection prompt on quarantined node
+func (ie *InferenceEngine) probeNode(node *InferenceNode) error {
+	if node.RemoteEngine == nil {
+		return fmt.Errorf("compute node %s has no remote engine", node.EndpointUrl)
+	}
+
+	if node.JobTypes[0] == JT_Embeddings {
+		_, err := engines.ProbeEmbeddings(node.RemoteEngine)
+		return err
+	}
+
+	return engines.ProbeCompletion(ie.lg, node.RemoteEngine)
+}
+
+// waitUntilNodeIsUsable - returns false if quarantined node can't take jobs yet,
+// probing it once the backoff has passed
+func (ie *InferenceEngine) waitUntilNodeIsUsable(node *InferenceNode) bool {
+	if time.Now().Before(node.RateLimitedUntil()) {
+		return false
+	}
+
+	state, _ := node.GetHealth()
+	switch state {
+	case NH_Quarantined:
+		if !node.health.tryStartProbe() {
+			return false
+		}
+		err := ie.probeNode(node)
+		node.health.finishProbe(err)
+		if err != nil {
+			ie.lg.Error().Err(err).Msgf("compute node %s is still failing, keeping it in quarantine", node.EndpointUrl)
+			return false
+		}
+		ie.lg.Info().Msgf("compute node %s recovered", node.EndpointUrl)
+	case NH_Degraded:
+		// let healthy nodes collect jobs from shared queues first
+		time.Sleep(degradedNodeCollectionDelay)
+	}
+
+	return true
+}
+
+func (ie *InferenceEngine) maxJobRetries() int {
+	if ie.settings == nil || ie.settings.MaxJobRetries <= 0 {
+		return DefaultMaxJobRetries
+	}
+
+	return ie.settings.MaxJobRetries
+}
+
+// handleBatchFailure - rate limited node is paused, other failures count towards quarantine,
+// jobs of the batch are retried in both cases, unless they've streamed some text already
+func (ie *InferenceEngine) handleBatchFailure(node *InferenceNode, batch []*ComputeJob, err error) {
+	batch = ie.failStreamedJobs(batch, err)
+
+	rateLimit := &engines.RateLimitError{}
+	if errors.As(err, &rateLimit) {
+		// engine is fine, it just needs a break
+		pause := node.health.recordRateLimit(rateLimit.RetryAfter)
+		ie.lg.Warn().Err(err).Msgf("compute node %s is rate limited, pausing it for %s", node.EndpointUrl, pause)
+		ie.retryRateLimitedJobs(batch, err)
+		return
+	}
+
+	if node.health.recordFailure() {
+		ie.lg.Error().Err(err).Msgf("compute node %s quarantined after repeated failures", node.EndpointUrl)
+	}
+	ie.retryJobs(batch, err)
+}
+
+// failStreamedJobs - fails jobs, which have streamed some text, returns the rest of the batch
+func (ie *InferenceEngine) failStreamedJobs(batch []*ComputeJob, err error) []*ComputeJob {
+	retry := make([]*ComputeJob, 0, len(batch))
+	for _, job := range batch {
+		if !job.isStreamed() {
+			retry = append(retry, job)
+			continue
+		}
+		atomic.AddUint64(&ie.TotalJobsFailed, 1)
+		ie.failJob(job, fmt.Errorf("%w: %v", ErrStreamInterrupted, err))
+	}
+
+	return retry
+}
+
+// retryRateLimitedJobs - same as retryJobs, but rate limits don't use up job's attempts
+func (ie *InferenceEngine) retryRateLimitedJobs(batch []*ComputeJob, err error) {
+	retry := make([]*ComputeJob, 0, len(batch))
+	for _, job := range batch {
+		job.rateLimits++
+		if job.rateLimits > MaxRateLimitRetries {
+			atomic.AddUint64(&ie.TotalJobsFailed, 1)
+			ie.failJob(job, fmt.Errorf("%w after %d rate limited attempts: %v", ErrJobRetriesExhausted, job.rateLimits, err))
+			continue
+		}
+		retry = append(retry, job)
+	}
+
+	if len(retry) > 0 {
+		go func() {
+			ie.IncomingJobs <- retry
+		}()
+	}
+}
+
+// retryJobs - sends jobs of failed batch back to the scheduler,
+// jobs which failed too many times are failed for good
+func (ie *InferenceEngine) retryJobs(batch []*ComputeJob, err error) {
+	retry := make([]*ComputeJob, 0, len(batch))
+	for _, job := range batch {
+		job.attempts++
+		if job.attempts > ie.maxJobRetries() {
+			atomic.AddUint64(&ie.TotalJobsFailed, 1)
+			ie.failJob(job, fmt.Errorf("%w after %d attempts: %v", ErrJobRetriesExhausted, job.attempts, err))
+			continue
+		}
+		retry = append(retry, job)
+	}
+
+	if len(retry) > 0 {
+		go func() {
+			ie.IncomingJobs <- retry
+		}()
+	}
+}
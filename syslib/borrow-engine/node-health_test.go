@@ -0,0 +1,115 @@
+package borrow_engine
+
+import (
+	"errors"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/rs/zerolog"
+	"testing"
+	"time"
+)
+
+func TestNodeQuarantineAndBackoff(t *testing.T) {
+	health := &nodeHealth{}
+
+	health.recordSuccess(time.Second)
+	if health.recordFailure() || health.state != NH_Degraded {
+		t.Fatalf("expected degraded node after first failure, got %d", health.state)
+	}
+	health.recordFailure()
+	if !health.recordFailure() || health.state != NH_Quarantined {
+		t.Fatalf("expected quarantine after %d failures in a row", quarantineAfterFailures)
+	}
+	if health.tryStartProbe() {
+		t.Fatalf("quarantined node probed before backoff")
+	}
+
+	health.quarantinedUntil = time.Now()
+	if !health.tryStartProbe() || health.tryStartProbe() {
+		t.Fatalf("expected exactly one probe after backoff")
+	}
+	health.finishProbe(errors.New("still down"))
+	if health.state != NH_Quarantined || health.backoff != 2*initialQuarantineBackoff {
+		t.Fatalf("expected doubled backoff after failed probe, got %s", health.backoff)
+	}
+
+	health.quarantinedUntil = time.Now()
+	health.tryStartProbe()
+	health.finishProbe(nil)
+	if health.state != NH_Healthy || health.failureRate() != 0 {
+		t.Fatalf("expected healthy node after successful probe")
+	}
+}
+
+func TestRetryJobsIsBounded(t *testing.T) {
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{}, &InferenceEngineSettings{MaxJobRetries: 1})
+	job := &ComputeJob{
+		JobId:         "poison",
+		ComputeResult: &ComputeResult{ErrorChannel: make(chan error, 1)},
+	}
+
+	engine.retryJobs([]*ComputeJob{job}, errors.New("batch failed"))
+	select {
+	case retried := <-engine.IncomingJobs:
+		if len(retried) != 1 || retried[0] != job {
+			t.Fatalf("unexpected retried jobs: %v", retried)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("job was not retried")
+	}
+
+	engine.retryJobs([]*ComputeJob{job}, errors.New("batch failed"))
+	select {
+	case err := <-job.ComputeResult.ErrorChannel:
+		if !errors.Is(err, ErrJobRetriesExhausted) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("job was retried forever")
+	}
+}
+
+func TestRunBatchRetriesFailedJobsOnly(t *testing.T) {
+	done, failed := &ComputeJob{JobId: "done"}, &ComputeJob{JobId: "failed"}
+	compute := ComputeFunction{JT_Completion: func(_ *InferenceNode, jobs []*ComputeJob) ([]*ComputeJob, error) {
+		return jobs[:1], errors.New("second job failed")
+	}}
+
+	var succeeded, retried []*ComputeJob
+	node := &InferenceNode{}
+	node.RunBatch(compute, []*ComputeJob{done, failed}, func(_ time.Time, jobs []*ComputeJob) {
+		succeeded = jobs
+	}, func(_ time.Time, jobs []*ComputeJob, _ error) {
+		retried = jobs
+	})
+	if len(succeeded) != 1 || succeeded[0] != done || len(retried) != 1 || retried[0] != failed {
+		t.Fatalf("expected only the failed job to be retried, got done %v, failed %v", succeeded, retried)
+	}
+}
+
+func TestRateLimitedNodeIsPaused(t *testing.T) {
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{}, nil)
+	node := &InferenceNode{EndpointUrl: "hosted"}
+	job := &ComputeJob{JobId: "limited"}
+
+	for attempt := 0; attempt < quarantineAfterFailures; attempt++ {
+		engine.handleBatchFailure(node, []*ComputeJob{job}, &engines.BatchError{Errors: []error{
+			&engines.RateLimitError{StatusCode: 429, RetryAfter: time.Minute},
+		}})
+		<-engine.IncomingJobs
+	}
+
+	if state, _ := node.GetHealth(); state == NH_Quarantined {
+		t.Fatalf("rate limited node is quarantined")
+	}
+	if time.Until(node.RateLimitedUntil()) < 50*time.Second || engine.waitUntilNodeIsUsable(node) {
+		t.Fatalf("expected node to be paused for the time engine asked for")
+	}
+	if job.attempts != 0 || job.rateLimits != quarantineAfterFailures {
+		t.Fatalf("rate limits used up job's attempts: %d, %d", job.attempts, job.rateLimits)
+	}
+
+	node.health.recordSuccess(time.Second)
+	if pause := node.health.recordRateLimit(0); pause != initialRateLimitBackoff {
+		t.Fatalf("expected backoff to start over after success, got %s", pause)
+	}
+}
@@ -0,0 +1,124 @@
+package borrow_engine
+
+// nodes are added, removed and re-configured by the scheduler goroutine only,
+// so worker feeders never race with the routing of pinned jobs
+
+type nodeRemoval struct {
+	node    *InferenceNode
+	drained chan struct{}
+}
+
+type nodeUpdate struct {
+	node         *InferenceNode
+	maxRequests  int
+	maxBatchSize int
+}
+
+// GetNodes - returns snapshot of nodes currently serving jobs
+func (ie *InferenceEngine) GetNodes() []*InferenceNode {
+	ie.nodesLock.RLock()
+	defer ie.nodesLock.RUnlock()
+
+	return ie.Nodes
+}
+
+// RemoveNode - stops routing jobs to the node, its in-flight batches are finished
+// and pinned jobs go back to the scheduler; returned channel is closed once node is drained
+func (ie *InferenceEngine) RemoveNode(node *InferenceNode) chan struct{} {
+	drained := make(chan struct{})
+	ie.removeNodeChan <- &nodeRemoval{
+		node:    node,
+		drained: drained,
+	}
+
+	return drained
+}
+
+// UpdateNodeLimits - applies new max-requests and max-batch-size to the running node,
+// extra workers are stopped after their current batch
+func (ie *InferenceEngine) UpdateNodeLimits(node *InferenceNode, maxRequests, maxBatchSize int) {
+	ie.updateNodeChan <- &nodeUpdate{
+		node:         node,
+		maxRequests:  maxRequests,
+		maxBatchSize: maxBatchSize,
+	}
+}
+
+func (ie *InferenceEngine) startNodeWorkers(node *InferenceNode, sharedJobs *jobQueue) {
+	node.limitsLock.Lock()
+	missing := node.MaxRequests - node.workers
+	if missing <= 0 || node.draining {
+		node.limitsLock.Unlock()
+		return
+	}
+	node.workers += missing
+	node.limitsLock.Unlock()
+
+	for idx := 0; idx < missing; idx++ {
+		go ie.singleRequestWorker(node, sharedJobs)
+	}
+}
+
+func (ie *InferenceEngine) removeNode(removal *nodeRemoval) {
+	node := removal.node
+
+	ie.nodesLock.Lock()
+	// new slice, as snapshots returned by GetNodes can still be in use
+	nodes := make([]*InferenceNode, 0, len(ie.Nodes))
+	found := false
+	for _, n := range ie.Nodes {
+		if n == node {
+			found = true
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	ie.Nodes = nodes
+	ie.nodesLock.Unlock()
+
+	node.limitsLock.Lock()
+	node.drained = removal.drained
+	node.draining = true
+	if !found {
+		// node is still being detected, it won't be added
+		node.removed = true
+	}
+	idle := node.workers == 0
+	node.limitsLock.Unlock()
+
+	ie.lg.Info().Msgf("removing compute node %s", node.EndpointUrl)
+	if idle {
+		ie.finishDraining(node)
+	}
+}
+
+// finishDraining - called once the last worker of removed node has exited
+func (ie *InferenceEngine) finishDraining(node *InferenceNode) {
+	jobs := node.pinnedJobs.drain()
+	if len(jobs) > 0 {
+		// scheduler routes them to other nodes or fails them if model is served no more
+		go func() {
+			ie.IncomingJobs <- jobs
+		}()
+	}
+
+	ie.lg.Info().Msgf("compute node %s drained, %d pinned jobs re-scheduled", node.EndpointUrl, len(jobs))
+	close(node.drained)
+}
+
+func (ie *InferenceEngine) updateNodeLimits(update *nodeUpdate, sharedJobs *jobQueue) {
+	node := update.node
+
+	node.limitsLock.Lock()
+	node.MaxRequests = update.maxRequests
+	node.MaxBatchSize = update.maxBatchSize
+	active := node.pinnedJobs != nil && !node.draining
+	node.limitsLock.Unlock()
+
+	ie.lg.Info().Msgf("compute node %s limits changed to %d/%d",
+		node.EndpointUrl, update.maxRequests, update.maxBatchSize)
+	if active {
+		// nodes still being detected get their workers once added
+		ie.startNodeWorkers(node, sharedJobs)
+	}
+}
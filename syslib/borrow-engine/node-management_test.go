@@ -0,0 +1,92 @@
+package borrow_engine
+
+import (
+	"github.com/rs/zerolog"
+	"testing"
+	"time"
+)
+
+func TestRemoveNodeFinishesInFlightBatch(t *testing.T) {
+	started := make(chan struct{}, 16)
+	release := make(chan struct{})
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{
+		JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) ([]*ComputeJob, error) {
+			started <- struct{}{}
+			<-release
+			return jobs, nil
+		},
+	}, &InferenceEngineSettings{TopInterval: time.Hour})
+	go engine.Run()
+
+	node := &InferenceNode{
+		EndpointUrl:  "http://127.0.0.1:8001/v1/completions",
+		MaxRequests:  1,
+		MaxBatchSize: 1,
+		JobTypes:     []JobType{JT_Completion},
+	}
+	engine.AddNodeChan <- node
+	engine.AddJob(&ComputeJob{JobId: "in-flight", JobType: JT_Completion, Priority: PRIO_User})
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("batch was not started")
+	}
+
+	drained := engine.RemoveNode(node)
+	select {
+	case <-drained:
+		t.Fatalf("node drained before its in-flight batch finished")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if len(engine.GetNodes()) != 0 {
+		t.Fatalf("removed node is still routed to")
+	}
+
+	close(release)
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node was not drained")
+	}
+	if node.TotalJobsProcessed != 1 {
+		t.Fatalf("expected in-flight job to be processed, got %d", node.TotalJobsProcessed)
+	}
+}
+
+func TestUpdateNodeLimitsAdjustsWorkers(t *testing.T) {
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{}, &InferenceEngineSettings{TopInterval: time.Hour})
+	go engine.Run()
+
+	node := &InferenceNode{
+		EndpointUrl:  "http://127.0.0.1:8001/v1/completions",
+		MaxRequests:  1,
+		MaxBatchSize: 1,
+		JobTypes:     []JobType{JT_Completion},
+	}
+	engine.AddNodeChan <- node
+
+	waitForWorkers := func(expected int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			node.limitsLock.Lock()
+			workers := node.workers
+			node.limitsLock.Unlock()
+			if workers == expected {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected %d workers", expected)
+	}
+
+	waitForWorkers(1)
+	engine.UpdateNodeLimits(node, 3, 16)
+	waitForWorkers(3)
+	if maxRequests, maxBatchSize := node.GetLimits(); maxRequests != 3 || maxBatchSize != 16 {
+		t.Fatalf("unexpected limits: %d/%d", maxRequests, maxBatchSize)
+	}
+
+	engine.UpdateNodeLimits(node, 2, 16)
+	waitForWorkers(2)
+}
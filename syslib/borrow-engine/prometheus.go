@@ -0,0 +1,232 @@
+package borrow_engine
+
+import (
+	"github.com/d0rc/agent-os/stdlib/metrics"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var jobPriorities = []JobPriority{PRIO_System, PRIO_Kernel, PRIO_User, PRIO_Background}
+
+func jobPriorityName(priority JobPriority) string {
+	switch priority {
+	case PRIO_System:
+		return "system"
+	case PRIO_Kernel:
+		return "kernel"
+	case PRIO_User:
+		return "user"
+	case PRIO_Background:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+func nodeHealthName(health NodeHealth) string {
+	switch health {
+	case NH_Degraded:
+		return "degraded"
+	case NH_Quarantined:
+		return "quarantined"
+	default:
+		return "healthy"
+	}
+}
+
+// WriteMetrics - exports statistics shown by the top screen in Prometheus text format
+func (ie *InferenceEngine) WriteMetrics(pw *metrics.Writer) {
+	pw.Family("compute_jobs_total", "counter", "Compute jobs processed.")
+	ie.statsLock.RLock()
+	totalJobs := ie.TotalJobsProcessed
+	ie.statsLock.RUnlock()
+	pw.Sample("compute_jobs_total", float64(totalJobs))
+	pw.Family("compute_jobs_cancelled_total", "counter", "Compute jobs cancelled before they ran.")
+	pw.Sample("compute_jobs_cancelled_total", float64(atomic.LoadUint64(&ie.TotalJobsCancelled)))
+	pw.Family("compute_jobs_failed_total", "counter", "Compute jobs failed after all retries.")
+	pw.Sample("compute_jobs_failed_total", float64(atomic.LoadUint64(&ie.TotalJobsFailed)))
+
+	ie.writeQueueMetrics(pw)
+	ie.writeNodeMetrics(pw)
+	ie.writeProcessMetrics(pw)
+}
+
+func (ie *InferenceEngine) writeQueueMetrics(pw *metrics.Writer) {
+	pw.Family("compute_queued_jobs", "gauge", "Jobs waiting for a compute node, queue is either shared or pinned to the node's endpoint.")
+	jobTypes := make([]JobType, 0, len(ie.sharedJobs))
+	for jobType := range ie.sharedJobs {
+		jobTypes = append(jobTypes, jobType)
+	}
+	sort.Slice(jobTypes, func(i, j int) bool {
+		return jobTypes[i] < jobTypes[j]
+	})
+	for _, jobType := range jobTypes {
+		counts := ie.sharedJobs[jobType].lenByPriority()
+		for _, priority := range jobPriorities {
+			pw.Sample("compute_queued_jobs", float64(counts[priority]),
+				"queue", "shared", "job_type", jobTypeName(jobType), "priority", jobPriorityName(priority))
+		}
+	}
+	for _, node := range ie.GetNodes() {
+		counts := node.pinnedJobs.lenByPriority()
+		for _, priority := range jobPriorities {
+			pw.Sample("compute_queued_jobs", float64(counts[priority]),
+				"queue", "pinned", "endpoint", node.EndpointUrl, "job_type", jobTypeName(node.JobTypes[0]),
+				"priority", jobPriorityName(priority))
+		}
+	}
+
+	pw.Family("compute_deferred_jobs", "gauge", "Over-quota jobs waiting for their quotas.")
+	deferred := 0
+	for _, state := range ie.GetQuotaStates() {
+		deferred += state.Deferred
+	}
+	pw.Sample("compute_deferred_jobs", float64(deferred))
+}
+
+func (ie *InferenceEngine) writeNodeMetrics(pw *metrics.Writer) {
+	nodes := ie.GetNodes()
+	type nodeMetric struct {
+		name, kind, help string
+		value            func(node *InferenceNode) float64
+	}
+	nodeMetrics := []nodeMetric{
+		{"compute_node_requests_total", "counter", "Batches run by the node.", func(node *InferenceNode) float64 {
+			return float64(ie.getNodeStats(node).requests)
+		}},
+		{"compute_node_jobs_total", "counter", "Jobs run by the node.", func(node *InferenceNode) float64 {
+			return float64(ie.getNodeStats(node).jobs)
+		}},
+		{"compute_node_requests_failed_total", "counter", "Batches failed by the node.", func(node *InferenceNode) float64 {
+			return float64(ie.getNodeStats(node).requestsFailed)
+		}},
+		{"compute_node_jobs_failed_total", "counter", "Jobs of the batches failed by the node.", func(node *InferenceNode) float64 {
+			return float64(ie.getNodeStats(node).jobsFailed)
+		}},
+		{"compute_node_busy_seconds_total", "counter", "Time the node spent running batches.", func(node *InferenceNode) float64 {
+			return ie.getNodeStats(node).timeConsumed.Seconds()
+		}},
+		{"compute_node_idle_seconds_total", "counter", "Time the node had no batches running.", func(node *InferenceNode) float64 {
+			return ie.getNodeStats(node).timeIdle.Seconds()
+		}},
+		{"compute_node_wasted_seconds_total", "counter", "Time the node spent running batches, which failed.", func(node *InferenceNode) float64 {
+			return ie.getNodeStats(node).timeWasted.Seconds()
+		}},
+		{"compute_node_prompt_tokens_total", "counter", "Prompt tokens processed by the node.", func(node *InferenceNode) float64 {
+			if node.RemoteEngine == nil {
+				return 0
+			}
+			return float64(atomic.LoadUint64(&node.RemoteEngine.PromptTokens))
+		}},
+		{"compute_node_generated_tokens_total", "counter", "Tokens generated by the node.", func(node *InferenceNode) float64 {
+			if node.RemoteEngine == nil {
+				return 0
+			}
+			return float64(atomic.LoadUint64(&node.RemoteEngine.TokensGenerated))
+		}},
+		{"compute_node_running_requests", "gauge", "Batches the node is running now.", func(node *InferenceNode) float64 {
+			return float64(atomic.LoadInt32(&node.RequestsRunning))
+		}},
+		{"compute_node_max_requests", "gauge", "Batches the node is allowed to run at once.", func(node *InferenceNode) float64 {
+			maxRequests, _ := node.GetLimits()
+			return float64(maxRequests)
+		}},
+		{"compute_node_max_batch_size", "gauge", "Jobs the node is allowed to run in a batch.", func(node *InferenceNode) float64 {
+			_, maxBatchSize := node.GetLimits()
+			return float64(maxBatchSize)
+		}},
+		{"compute_node_context_size_tokens", "gauge", "Context size reported by the node, 0 if unknown.", func(node *InferenceNode) float64 {
+			if node.RemoteEngine == nil {
+				return 0
+			}
+			return float64(node.RemoteEngine.ContextSize)
+		}},
+		{"compute_node_rate_limited_seconds", "gauge", "Time left until rate limited node takes jobs again.", func(node *InferenceNode) float64 {
+			return max(time.Until(node.RateLimitedUntil()).Seconds(), 0)
+		}},
+	}
+	for _, metric := range nodeMetrics {
+		pw.Family(metric.name, metric.kind, metric.help)
+		for _, node := range nodes {
+			pw.Sample(metric.name, metric.value(node), "endpoint", node.EndpointUrl)
+		}
+	}
+
+	pw.Family("compute_node_health", "gauge", "Health state of the node, 1 for the current one.")
+	for _, node := range nodes {
+		health, _ := node.GetHealth()
+		for _, state := range []NodeHealth{NH_Healthy, NH_Degraded, NH_Quarantined} {
+			value := 0.0
+			if state == health {
+				value = 1
+			}
+			pw.Sample("compute_node_health", value, "endpoint", node.EndpointUrl, "state", nodeHealthName(state))
+		}
+	}
+}
+
+func (ie *InferenceEngine) writeProcessMetrics(pw *metrics.Writer) {
+	type processStats struct {
+		name                          string
+		requests, jobs                uint64
+		promptTokens, generatedTokens uint64
+		busySeconds, waitSeconds      float64
+		spend                         float64
+	}
+
+	ie.statsLock.RLock()
+	processes := make([]processStats, 0, len(ie.ProcessesTotalRequests))
+	seen := make(map[string]bool)
+	for _, names := range []map[string]uint64{ie.ProcessesTotalRequests, ie.ProcessesTotalJobs} {
+		for name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			processes = append(processes, processStats{
+				name:            name,
+				requests:        ie.ProcessesTotalRequests[name],
+				jobs:            ie.ProcessesTotalJobs[name],
+				promptTokens:    ie.ProcessesTotalPromptTokens[name],
+				generatedTokens: ie.ProcessesTotalGeneratedTokens[name],
+				busySeconds:     ie.ProcessesTotalTimeConsumed[name].Seconds(),
+				waitSeconds:     ie.ProcessesTotalTimeWaiting[name].Seconds(),
+				spend:           ie.ProcessesTotalSpend[name],
+			})
+		}
+	}
+	ie.statsLock.RUnlock()
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].name < processes[j].name
+	})
+
+	pw.Family("process_requests_total", "counter", "Client requests of the process.")
+	for _, process := range processes {
+		pw.Sample("process_requests_total", float64(process.requests), "process", process.name)
+	}
+	pw.Family("process_jobs_total", "counter", "Compute jobs of the process, which were run.")
+	for _, process := range processes {
+		pw.Sample("process_jobs_total", float64(process.jobs), "process", process.name)
+	}
+	pw.Family("process_prompt_tokens_total", "counter", "Prompt tokens of jobs of the process.")
+	for _, process := range processes {
+		pw.Sample("process_prompt_tokens_total", float64(process.promptTokens), "process", process.name)
+	}
+	pw.Family("process_generated_tokens_total", "counter", "Tokens generated for jobs of the process.")
+	for _, process := range processes {
+		pw.Sample("process_generated_tokens_total", float64(process.generatedTokens), "process", process.name)
+	}
+	pw.Family("process_compute_seconds_total", "counter", "Time batches with jobs of the process were running.")
+	for _, process := range processes {
+		pw.Sample("process_compute_seconds_total", process.busySeconds, "process", process.name)
+	}
+	pw.Family("process_wait_seconds_total", "counter", "Time jobs of the process waited in queues.")
+	for _, process := range processes {
+		pw.Sample("process_wait_seconds_total", process.waitSeconds, "process", process.name)
+	}
+	pw.Family("process_spend_total", "counter", "Cost of jobs of the process on paid nodes.")
+	for _, process := range processes {
+		pw.Sample("process_spend_total", process.spend, "process", process.name)
+	}
+}
@@ -0,0 +1,24 @@
+package borrow_engine
+
+import (
+	"github.com/d0rc/agent-os/stdlib/metrics"
+	"github.com/rs/zerolog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueMetricsKeepJobTypesOfPinnedQueues(t *testing.T) {
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{}, &InferenceEngineSettings{TopInterval: time.Hour})
+	node := &InferenceNode{EndpointUrl: "http://node", JobTypes: []JobType{JT_Embeddings},
+		pinnedJobs: newJobQueue(engine.fairClock)}
+	node.pinnedJobs.push(&ComputeJob{JobId: "pinned", JobType: JT_Embeddings, Priority: PRIO_User})
+	engine.Nodes = append(engine.Nodes, node)
+
+	sb := &strings.Builder{}
+	engine.writeQueueMetrics(metrics.NewWriter(sb))
+	expected := metrics.Prefix + `compute_queued_jobs{queue="pinned",endpoint="http://node",job_type="embeddings",priority="user"} 1`
+	if !strings.Contains(sb.String(), expected+"\n") {
+		t.Fatalf("expected %s in:\n%s", expected, sb.String())
+	}
+}
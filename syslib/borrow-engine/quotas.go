@@ -0,0 +1,402 @@
+package borrow_engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("compute quota exceeded")
+
+// Quota - limits of compute used by processes or by jobs with a tag, every process or tag
+// matching the rule gets limits of its own; zero limit - no limit
+type Quota struct {
+	Process string // glob over process names
+	Tag     string // glob over tags of the jobs
+
+	MaxConcurrentJobs int // jobs queued or running at once
+	JobsPerMinute     int
+	GPUSecondsPerHour float64 // node time, batch time is split evenly between its jobs
+	// SpendPerDay - budget for paid nodes, in currency of their costs; over-budget jobs wait for free nodes,
+	// the limit only applies to admission of jobs, which no free node can run
+	SpendPerDay float64
+	// Defer - over-quota jobs wait until quota allows them, instead of failing
+	Defer bool
+}
+
+const (
+	quotaProcessPrefix = "process "
+	quotaTagPrefix     = "tag "
+	quotaWindowBuckets = 60
+	// deferredJobsCheckInterval - deferred jobs are also re-checked when jobs of their quota finish
+	deferredJobsCheckInterval = time.Second
+)
+
+// rollingCounter - sum of values added during the last quotaWindowBuckets buckets
+type rollingCounter struct {
+	bucketSize time.Duration
+	values     [quotaWindowBuckets]float64
+	buckets    [quotaWindowBuckets]int64
+}
+
+func (c *rollingCounter) add(now time.Time, value float64) {
+	bucket := now.UnixNano() / int64(c.bucketSize)
+	idx := bucket % quotaWindowBuckets
+	if c.buckets[idx] != bucket {
+		c.buckets[idx] = bucket
+		c.values[idx] = 0
+	}
+	c.values[idx] += value
+}
+
+func (c *rollingCounter) sum(now time.Time) float64 {
+	bucket := now.UnixNano() / int64(c.bucketSize)
+	total := 0.0
+	for idx := range c.values {
+		if bucket-c.buckets[idx] < quotaWindowBuckets {
+			total += c.values[idx]
+		}
+	}
+
+	return total
+}
+
+// quotaUsage - compute used by a single process or tag
+type quotaUsage struct {
+	running    int
+	jobs       rollingCounter // per minute
+	gpuSeconds rollingCounter // per hour
+	spend      rollingCounter // per day
+	deferred   int
+	rejected   uint64
+}
+
+// quotaSubject - process or tag quota applies to, along with the rule
+type quotaSubject struct {
+	key   string
+	quota *Quota
+}
+
+type quotaManager struct {
+	lock   sync.Mutex
+	quotas []*Quota
+	usage  map[string]*quotaUsage
+}
+
+func newQuotaManager(quotas []*Quota) *quotaManager {
+	return &quotaManager{
+		quotas: quotas,
+		usage:  make(map[string]*quotaUsage),
+	}
+}
+
+// SetQuotas - replaces quota rules, compute already used by processes and tags is kept
+func (ie *InferenceEngine) SetQuotas(quotas []*Quota) {
+	ie.quotas.lock.Lock()
+	ie.quotas.quotas = quotas
+	ie.quotas.lock.Unlock()
+
+	ie.notifyQuotaReleased()
+}
+
+// rule - should be called with lock held, the first matching rule applies
+func (m *quotaManager) rule(isProcess bool, name string) *Quota {
+	for _, quota := range m.quotas {
+		if isProcess && quota.Process != "" && MatchModelMask(quota.Process, name) {
+			return quota
+		}
+		if !isProcess && quota.Tag != "" && MatchModelMask(quota.Tag, name) {
+			return quota
+		}
+	}
+
+	return nil
+}
+
+// subjects - should be called with lock held, returns quotas of the job's process and of each of its tags
+func (m *quotaManager) subjects(job *ComputeJob) []quotaSubject {
+	subjects := make([]quotaSubject, 0)
+	if quota := m.rule(true, job.Process); quota != nil {
+		subjects = append(subjects, quotaSubject{key: quotaProcessPrefix + job.Process, quota: quota})
+	}
+	for _, tag := range job.Tags {
+		if quota := m.rule(false, tag); quota != nil {
+			subjects = append(subjects, quotaSubject{key: quotaTagPrefix + tag, quota: quota})
+		}
+	}
+
+	return subjects
+}
+
+func (m *quotaManager) getUsage(key string) *quotaUsage {
+	usage, exists := m.usage[key]
+	if !exists {
+		usage = &quotaUsage{
+			jobs:       rollingCounter{bucketSize: time.Minute / quotaWindowBuckets},
+			gpuSeconds: rollingCounter{bucketSize: time.Hour / quotaWindowBuckets},
+			spend:      rollingCounter{bucketSize: 24 * time.Hour / quotaWindowBuckets},
+		}
+		m.usage[key] = usage
+	}
+
+	return usage
+}
+
+// admit - accounts the job if all of its quotas allow it, otherwise returns
+// whether job should be deferred rather than failed and the reason; budgets only
+// limit jobs, which can only run on paid nodes
+func (m *quotaManager) admit(job *ComputeJob, now time.Time, paidOnly bool) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	subjects := m.subjects(job)
+	for _, subject := range subjects {
+		usage := m.getUsage(subject.key)
+		quota := subject.quota
+
+		var reason string
+		switch {
+		case quota.MaxConcurrentJobs > 0 && usage.running >= quota.MaxConcurrentJobs:
+			reason = fmt.Sprintf("%d concurrent jobs", quota.MaxConcurrentJobs)
+		case quota.JobsPerMinute > 0 && usage.jobs.sum(now) >= float64(quota.JobsPerMinute):
+			reason = fmt.Sprintf("%d jobs per minute", quota.JobsPerMinute)
+		case quota.GPUSecondsPerHour > 0 && usage.gpuSeconds.sum(now) >= quota.GPUSecondsPerHour:
+			reason = fmt.Sprintf("%.0f GPU-seconds per hour", quota.GPUSecondsPerHour)
+		case paidOnly && quota.SpendPerDay > 0 && usage.spend.sum(now) >= quota.SpendPerDay:
+			reason = fmt.Sprintf("%.2f spend per day", quota.SpendPerDay)
+		default:
+			continue
+		}
+
+		return quota.Defer, fmt.Errorf("%w: %s is limited to %s", ErrQuotaExceeded, subject.key, reason)
+	}
+
+	job.quotaKeys = make([]string, 0, len(subjects))
+	for _, subject := range subjects {
+		usage := m.getUsage(subject.key)
+		usage.running++
+		usage.jobs.add(now, 1)
+		job.quotaKeys = append(job.quotaKeys, subject.key)
+	}
+
+	return false, nil
+}
+
+// release - job is done, one way or another, it's safe to call it more than once
+func (m *quotaManager) release(job *ComputeJob) bool {
+	if len(job.quotaKeys) == 0 {
+		return false
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, key := range job.quotaKeys {
+		m.getUsage(key).running--
+	}
+	job.quotaKeys = nil
+
+	return true
+}
+
+func (m *quotaManager) accountGPUTime(batch []*ComputeJob, batchTime time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	jobTime := batchTime.Seconds() / float64(len(batch))
+	for _, job := range batch {
+		for _, key := range job.quotaKeys {
+			m.getUsage(key).gpuSeconds.add(now, jobTime)
+		}
+	}
+}
+
+// accountSpend - cost of the batch's jobs on the node
+func (m *quotaManager) accountSpend(cost *NodeCost, batch []*ComputeJob, batchTime time.Duration, failed bool) {
+	if !cost.isPaid() {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	jobTime := batchTime / time.Duration(len(batch))
+	for _, job := range batch {
+		jobCost := cost.jobCost(job, jobTime, failed)
+		for _, key := range job.quotaKeys {
+			m.getUsage(key).spend.add(now, jobCost)
+		}
+	}
+}
+
+// overBudget - job's process or any of its tags has spent its budget for paid nodes
+func (m *quotaManager) overBudget(job *ComputeJob, now time.Time) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, subject := range m.subjects(job) {
+		if subject.quota.SpendPerDay > 0 && m.getUsage(subject.key).spend.sum(now) >= subject.quota.SpendPerDay {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *quotaManager) setDeferred(deferred []*ComputeJob) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, usage := range m.usage {
+		usage.deferred = 0
+	}
+	for _, job := range deferred {
+		for _, subject := range m.subjects(job) {
+			m.getUsage(subject.key).deferred++
+		}
+	}
+}
+
+func (m *quotaManager) accountRejected(job *ComputeJob) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, subject := range m.subjects(job) {
+		m.getUsage(subject.key).rejected++
+	}
+}
+
+// QuotaState - usage of the quota by a process or tag
+type QuotaState struct {
+	Subject       string
+	Quota         *Quota
+	Running       int
+	JobsPerMinute int
+	GPUSeconds    float64 // during the last hour
+	Spend         float64 // during the last day
+	Deferred      int
+	Rejected      uint64
+}
+
+// GetQuotaStates - usage of quotas by processes and tags, which have used any compute recently
+func (ie *InferenceEngine) GetQuotaStates() []*QuotaState {
+	m := ie.quotas
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	states := make([]*QuotaState, 0, len(m.usage))
+	for key, usage := range m.usage {
+		state := &QuotaState{
+			Subject:       key,
+			Running:       usage.running,
+			JobsPerMinute: int(usage.jobs.sum(now)),
+			GPUSeconds:    usage.gpuSeconds.sum(now),
+			Spend:         usage.spend.sum(now),
+			Deferred:      usage.deferred,
+			Rejected:      usage.rejected,
+		}
+		if state.Running == 0 && state.JobsPerMinute == 0 && state.GPUSeconds == 0 && state.Spend == 0 && state.Deferred == 0 {
+			// subject is idle, its usage would start from scratch anyway
+			delete(m.usage, key)
+			continue
+		}
+		if strings.HasPrefix(key, quotaProcessPrefix) {
+			state.Quota = m.rule(true, strings.TrimPrefix(key, quotaProcessPrefix))
+		} else {
+			state.Quota = m.rule(false, strings.TrimPrefix(key, quotaTagPrefix))
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Subject < states[j].Subject
+	})
+
+	return states
+}
+
+// releaseQuota - should be called once the job is finished, failed or dropped
+func (ie *InferenceEngine) releaseQuota(job *ComputeJob) {
+	if ie.quotas.release(job) {
+		ie.notifyQuotaReleased()
+	}
+}
+
+func (ie *InferenceEngine) notifyQuotaReleased() {
+	select {
+	case ie.quotaReleased <- struct{}{}:
+	default:
+	}
+}
+
+// admitJobs - should be called by the scheduler goroutine only, returns jobs quotas allow to run,
+// over-quota jobs are deferred or failed
+func (ie *InferenceEngine) admitJobs(jobs []*ComputeJob) []*ComputeJob {
+	admitted := make([]*ComputeJob, 0, len(jobs))
+	now := time.Now()
+	for _, job := range jobs {
+		if job.attempts > 0 || job.rateLimits > 0 || len(job.quotaKeys) > 0 {
+			// retried job was admitted already
+			admitted = append(admitted, job)
+			continue
+		}
+
+		deferJob, err := ie.quotas.admit(job, now, !ie.hasFreeNode(job.JobType, job.ModelMask))
+		switch {
+		case err == nil:
+			admitted = append(admitted, job)
+		case deferJob:
+			ie.deferredJobs = append(ie.deferredJobs, job)
+		default:
+			ie.quotas.accountRejected(job)
+			ie.failJob(job, err)
+		}
+	}
+
+	return admitted
+}
+
+// admitDeferredJobs - should be called by the scheduler goroutine only
+func (ie *InferenceEngine) admitDeferredJobs() []*ComputeJob {
+	if len(ie.deferredJobs) == 0 {
+		return nil
+	}
+
+	deferred := ie.deferredJobs
+	ie.deferredJobs = make([]*ComputeJob, 0, len(deferred))
+	alive := make([]*ComputeJob, 0, len(deferred))
+	for _, job := range deferred {
+		if !ie.dropIfCancelled(job) {
+			alive = append(alive, job)
+		}
+	}
+
+	// jobs are checked in order they were received, those which are still over quota are deferred again
+	admitted := ie.admitJobs(alive)
+	ie.quotas.setDeferred(ie.deferredJobs)
+
+	return admitted
+}
+
+type tagsContextKey struct{}
+
+// WithTags - tags of compute jobs, which are created with returned context
+func WithTags(ctx context.Context, tags []string) context.Context {
+	return context.WithValue(ctx, tagsContextKey{}, tags)
+}
+
+// TagsFromContext - returns tags set with WithTags
+func TagsFromContext(ctx context.Context) []string {
+	if ctx == nil {
+		return nil
+	}
+
+	tags, _ := ctx.Value(tagsContextKey{}).([]string)
+	return tags
+}
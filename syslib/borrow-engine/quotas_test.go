@@ -0,0 +1,86 @@
+package borrow_engine
+
+import (
+	"errors"
+	"github.com/rs/zerolog"
+	"testing"
+	"time"
+)
+
+func TestQuotasRejectAndDeferJobs(t *testing.T) {
+	release := make(chan struct{})
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{
+		JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) ([]*ComputeJob, error) {
+			<-release
+			return jobs, nil
+		},
+	}, &InferenceEngineSettings{
+		TopInterval: time.Hour,
+		Quotas: []*Quota{
+			{Process: "voter", MaxConcurrentJobs: 1},
+			{Tag: "crawler", MaxConcurrentJobs: 1, Defer: true},
+		},
+	})
+	go engine.Run()
+	engine.AddNodeChan <- &InferenceNode{
+		EndpointUrl:  "http://127.0.0.1:8001/v1/completions",
+		MaxRequests:  4,
+		MaxBatchSize: 1,
+		JobTypes:     []JobType{JT_Completion},
+	}
+
+	newJob := func(process string, tags ...string) *ComputeJob {
+		return &ComputeJob{
+			JobType:  JT_Completion,
+			Priority: PRIO_User,
+			Process:  process,
+			Tags:     tags,
+			ComputeResult: &ComputeResult{
+				ErrorChannel: make(chan error, 1),
+			},
+		}
+	}
+
+	engine.AddJob(newJob("voter"))
+	rejected := newJob("voter")
+	engine.AddJob(rejected)
+	select {
+	case err := <-rejected.ComputeResult.ErrorChannel:
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("expected quota error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("over-quota job was not rejected")
+	}
+
+	engine.AddJob(newJob("crawler-1", "crawler"))
+	engine.AddJob(newJob("crawler-2", "crawler"))
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasQuotaState(engine, "tag crawler", 1, 1) {
+		if time.Now().After(deadline) {
+			t.Fatalf("over-quota job of the tag was not deferred: %+v", engine.GetQuotaStates())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	for !hasQuotaState(engine, "tag crawler", 0, 0) {
+		if time.Now().After(deadline) {
+			t.Fatalf("deferred job was not run: %+v", engine.GetQuotaStates())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if engine.TotalJobsProcessed != 3 {
+		t.Fatalf("expected 3 jobs to be processed, got %d", engine.TotalJobsProcessed)
+	}
+}
+
+func hasQuotaState(engine *InferenceEngine, subject string, running, deferred int) bool {
+	for _, state := range engine.GetQuotaStates() {
+		if state.Subject == subject {
+			return state.Running == running && state.Deferred == deferred
+		}
+	}
+
+	return running == 0 && deferred == 0
+}
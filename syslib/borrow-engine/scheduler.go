@@ -0,0 +1,188 @@
+package borrow_engine
+
+import (
+	"fmt"
+	"time"
+)
+
+type SchedulingPolicy string
+
+const (
+	// SP_Strict - jobs of higher priority always go first, lower priorities can starve under load
+	SP_Strict SchedulingPolicy = "strict"
+	// SP_WeightedFair - every process gets a share of the node proportional to priority weights of its jobs,
+	// so no process or priority starves
+	SP_WeightedFair SchedulingPolicy = "weighted-fair"
+	// SP_Aging - strict priorities, but waiting jobs are promoted one priority up every aging interval
+	SP_Aging SchedulingPolicy = "aging"
+)
+
+const (
+	DefaultBatchingLatency = 50 * time.Millisecond
+	DefaultIdlePoll        = time.Second
+	DefaultAgingInterval   = 30 * time.Second
+)
+
+// DefaultPriorityWeights - under weighted fair queueing system jobs get 8 times the share of background ones
+var DefaultPriorityWeights = map[JobPriority]int{
+	PRIO_System:     8,
+	PRIO_Kernel:     4,
+	PRIO_User:       2,
+	PRIO_Background: 1,
+}
+
+// SchedulerSettings - how node workers form batches, zero values are replaced with defaults
+type SchedulerSettings struct {
+	Policy SchedulingPolicy
+	// BatchingLatency - time the first job of the batch waits for more jobs to arrive
+	BatchingLatency time.Duration
+	// IdlePoll - time idle worker waits for jobs before it re-checks node state
+	IdlePoll time.Duration
+	// PriorityWeights - used by weighted fair policy, missing priorities get their default weights
+	PriorityWeights map[JobPriority]int
+	// AgingInterval - used by aging policy
+	AgingInterval time.Duration
+}
+
+func (settings *SchedulerSettings) withDefaults() *SchedulerSettings {
+	result := &SchedulerSettings{
+		Policy:          SP_Strict,
+		BatchingLatency: DefaultBatchingLatency,
+		IdlePoll:        DefaultIdlePoll,
+		PriorityWeights: make(map[JobPriority]int, len(DefaultPriorityWeights)),
+		AgingInterval:   DefaultAgingInterval,
+	}
+	for prio, weight := range DefaultPriorityWeights {
+		result.PriorityWeights[prio] = weight
+	}
+	if settings == nil {
+		return result
+	}
+
+	if settings.Policy != "" {
+		result.Policy = settings.Policy
+	}
+	if settings.BatchingLatency > 0 {
+		result.BatchingLatency = settings.BatchingLatency
+	}
+	if settings.IdlePoll > 0 {
+		result.IdlePoll = settings.IdlePoll
+	}
+	for prio, weight := range settings.PriorityWeights {
+		if weight > 0 {
+			result.PriorityWeights[prio] = weight
+		}
+	}
+	if settings.AgingInterval > 0 {
+		result.AgingInterval = settings.AgingInterval
+	}
+
+	return result
+}
+
+// ParseSchedulingPolicy - empty name stands for the default strict policy
+func ParseSchedulingPolicy(name string) (SchedulingPolicy, error) {
+	switch SchedulingPolicy(name) {
+	case "", SP_Strict:
+		return SP_Strict, nil
+	case SP_WeightedFair, SP_Aging:
+		return SchedulingPolicy(name), nil
+	}
+
+	return "", fmt.Errorf("unknown scheduling policy `%s`, expected one of: %s, %s, %s",
+		name, SP_Strict, SP_WeightedFair, SP_Aging)
+}
+
+// ParseJobPriority - accepts priority names used in configuration files
+func ParseJobPriority(name string) (JobPriority, error) {
+	switch name {
+	case "system":
+		return PRIO_System, nil
+	case "kernel":
+		return PRIO_Kernel, nil
+	case "user":
+		return PRIO_User, nil
+	case "background":
+		return PRIO_Background, nil
+	}
+
+	return 0, fmt.Errorf("unknown priority `%s`, expected one of: system, kernel, user, background", name)
+}
+
+// GetScheduler - returns scheduler settings the node is currently running with
+func (n *InferenceNode) GetScheduler() *SchedulerSettings {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	if n.scheduler == nil {
+		n.scheduler = n.Scheduler.withDefaults()
+	}
+
+	return n.scheduler
+}
+
+// SetScheduler - applies new scheduler settings to the running node, starting from the next batch
+func (n *InferenceNode) SetScheduler(settings *SchedulerSettings) {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	n.Scheduler = settings
+	n.scheduler = settings.withDefaults()
+}
+
+// collectBatch - waits up to idle poll for the first job, then up to batching latency
+// for more jobs to fill the batch, within batch's token budget, taking only eligible jobs
+// unless it's nil; returns empty batch if no jobs arrived
+func (ie *InferenceEngine) collectBatch(pinned, shared *jobQueue, scheduler *SchedulerSettings, maxBatchSize, maxBatchTokens int, eligible func(*ComputeJob) bool) []*ComputeJob {
+	batch := make([]*ComputeJob, 0, maxBatchSize)
+	fit := newBatchFit(maxBatchTokens)
+	fit.eligible = eligible
+
+	idleTimer := time.NewTimer(scheduler.IdlePoll)
+	defer idleTimer.Stop()
+	var batchTimer <-chan time.Time
+	for len(batch) < maxBatchSize {
+		// wake-up channels are taken before checking the queues, so no job added in between is missed
+		pinnedWakeup, sharedWakeup := pinned.wakeup(), shared.wakeup()
+		if job := takeJob(pinned, shared, scheduler, fit); job != nil {
+			if ie.dropIfCancelled(job) {
+				continue
+			}
+			fit.add(job)
+			batch = append(batch, job)
+			if len(batch) == 1 {
+				timer := time.NewTimer(scheduler.BatchingLatency)
+				defer timer.Stop()
+				batchTimer = timer.C
+			}
+			continue
+		}
+		if fit.limited() && len(batch) > 0 && pinned.len()+shared.len() > 0 {
+			// waiting jobs don't fit into token budget of the batch
+			return batch
+		}
+
+		select {
+		case <-pinnedWakeup:
+		case <-sharedWakeup:
+		case <-batchTimer:
+			return batch
+		case <-idleTimer.C:
+			if len(batch) == 0 {
+				return batch
+			}
+		}
+	}
+
+	return batch
+}
+
+// takeJob - jobs pinned to the node go first, unless policy prefers a job from shared queue
+func takeJob(pinned, shared *jobQueue, scheduler *SchedulerSettings, fit *batchFit) *ComputeJob {
+	pinnedRank, hasPinned := pinned.bestRank(scheduler, fit, time.Now())
+	if job := shared.takeBetterThan(scheduler, fit, pinnedRank, hasPinned); job != nil {
+		return job
+	}
+
+	return pinned.takeBetterThan(scheduler, fit, 0, false)
+}
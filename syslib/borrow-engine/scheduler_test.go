@@ -0,0 +1,118 @@
+package borrow_engine
+
+import (
+	"fmt"
+	"github.com/rs/zerolog"
+	"testing"
+	"time"
+)
+
+func takeProcesses(queue *jobQueue, scheduler *SchedulerSettings, n int) []string {
+	processes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		job := queue.takeBetterThan(scheduler, newBatchFit(0), 0, false)
+		if job == nil {
+			break
+		}
+		processes = append(processes, job.Process)
+	}
+
+	return processes
+}
+
+func TestSchedulingPolicies(t *testing.T) {
+	fill := func() *jobQueue {
+		queue := newJobQueue(newFairClock())
+		ts := time.Now()
+		for i := 0; i < 10; i++ {
+			queue.push(&ComputeJob{Process: "embeddings", Priority: PRIO_Background, receivedAt: ts.Add(-time.Minute)})
+			queue.push(&ComputeJob{Process: "agent", Priority: PRIO_System, receivedAt: ts})
+		}
+		return queue
+	}
+
+	strict := (&SchedulerSettings{Policy: SP_Strict}).withDefaults()
+	for _, process := range takeProcesses(fill(), strict, 10) {
+		if process != "agent" {
+			t.Fatalf("strict policy ran background job before system ones")
+		}
+	}
+
+	fair := (&SchedulerSettings{Policy: SP_WeightedFair}).withDefaults()
+	background := 0
+	for _, process := range takeProcesses(fill(), fair, 9) {
+		if process == "embeddings" {
+			background++
+		}
+	}
+	if background != 1 {
+		t.Fatalf("expected 1 of 9 jobs to be background with 8:1 weights, got %d", background)
+	}
+
+	aging := (&SchedulerSettings{Policy: SP_Aging, AgingInterval: 20 * time.Second}).withDefaults()
+	if processes := takeProcesses(fill(), aging, 1); processes[0] != "embeddings" {
+		t.Fatalf("background job waiting for a minute was not promoted")
+	}
+}
+
+func TestCollectBatchWaitsForJobs(t *testing.T) {
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{}, nil)
+	pinned, shared := newJobQueue(engine.fairClock), newJobQueue(engine.fairClock)
+	scheduler := (&SchedulerSettings{BatchingLatency: 100 * time.Millisecond, IdlePoll: 5 * time.Second}).withDefaults()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		shared.push(&ComputeJob{JobId: "shared", Priority: PRIO_User})
+		pinned.push(&ComputeJob{JobId: "pinned", Priority: PRIO_User})
+	}()
+
+	ts := time.Now()
+	batch := engine.collectBatch(pinned, shared, scheduler, 4, 0, nil)
+	if len(batch) != 2 {
+		t.Fatalf("expected both jobs in the batch, got %d", len(batch))
+	}
+	if waited := time.Since(ts); waited > time.Second {
+		t.Fatalf("worker was not woken up by new jobs, waited %s", waited)
+	}
+}
+
+func TestCollectBatchPacksByTokens(t *testing.T) {
+	engine := NewInferenceEngine(zerolog.Logger{}, ComputeFunction{}, nil)
+	pinned, shared := newJobQueue(engine.fairClock), newJobQueue(engine.fairClock)
+	scheduler := (&SchedulerSettings{BatchingLatency: 10 * time.Millisecond}).withDefaults()
+
+	ts := time.Now()
+	for idx, tokens := range []int{1000, 100, 3000, 900, 120} {
+		shared.push(&ComputeJob{
+			JobId:           fmt.Sprintf("job-%d", idx),
+			Priority:        PRIO_User,
+			EstimatedTokens: tokens,
+			receivedAt:      ts.Add(time.Duration(idx) * time.Millisecond),
+		})
+	}
+
+	batch := engine.collectBatch(pinned, shared, scheduler, 4, 1950, nil)
+	if len(batch) != 2 || batch[0].EstimatedTokens != 1000 || batch[1].EstimatedTokens != 900 {
+		t.Fatalf("expected jobs of similar length within the budget, got %v", batchTokens(batch))
+	}
+
+	batch = engine.collectBatch(pinned, shared, scheduler, 4, 1950, nil)
+	if len(batch) != 2 || batch[0].EstimatedTokens != 100 || batch[1].EstimatedTokens != 120 {
+		t.Fatalf("expected short jobs to be batched together, got %v", batchTokens(batch))
+	}
+
+	// job exceeding the budget runs alone
+	batch = engine.collectBatch(pinned, shared, scheduler, 4, 1950, nil)
+	if len(batch) != 1 || batch[0].EstimatedTokens != 3000 {
+		t.Fatalf("expected long job to run alone, got %v", batchTokens(batch))
+	}
+}
+
+func batchTokens(batch []*ComputeJob) []int {
+	tokens := make([]int, 0, len(batch))
+	for _, job := range batch {
+		tokens = append(tokens, job.EstimatedTokens)
+	}
+
+	return tokens
+}
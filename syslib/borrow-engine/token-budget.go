@@ -0,0 +1,145 @@
+package borrow_engine
+
+import (
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/syslib/utils"
+	"sync/atomic"
+	"time"
+)
+
+// EstimatePromptTokens - GPT-2 tokenizer estimate of the prompt length, models with
+// other tokenizers differ a bit, see GetPromptTokensEstimateError for the actual difference
+func EstimatePromptTokens(settings *engines.GenerationSettings) int {
+	if settings == nil {
+		return 0
+	}
+
+	if len(settings.Messages) == 0 {
+		return utils.CountTokensGPT2(settings.RawPrompt)
+	}
+
+	tokens := 0
+	for idx := range settings.Messages {
+		// role and separators of chat template take a few tokens per message
+		tokens += utils.CountTokensGPT2(settings.Messages[idx].Content) + 4
+	}
+
+	return tokens
+}
+
+// tokenLookahead - number of jobs of a flow, looked through for a job of similar length
+const tokenLookahead = 8
+
+// batchFit - token budget of the batch being collected
+type batchFit struct {
+	tokensLeft int // negative - node has no token budget
+	similarTo  int // tokens of the first job of the batch
+	empty      bool
+	// eligible - jobs the node can take, nil - any job, see spillFilter
+	eligible func(*ComputeJob) bool
+}
+
+func newBatchFit(maxBatchTokens int) *batchFit {
+	if maxBatchTokens <= 0 {
+		return &batchFit{tokensLeft: -1, empty: true}
+	}
+
+	return &batchFit{tokensLeft: maxBatchTokens, empty: true}
+}
+
+// fits - the first job always fits, if it's longer than the budget it runs alone,
+// jobs the node isn't eligible to take never fit
+func (fit *batchFit) fits(job *ComputeJob) bool {
+	if fit.eligible != nil && !fit.eligible(job) {
+		return false
+	}
+
+	return fit.tokensLeft < 0 || fit.empty || job.EstimatedTokens <= fit.tokensLeft
+}
+
+func (fit *batchFit) limited() bool {
+	return fit.tokensLeft >= 0
+}
+
+// distance - jobs of similar length are batched together, so shorter prompts aren't padded much
+func (fit *batchFit) distance(job *ComputeJob) int {
+	if !fit.limited() || fit.empty {
+		return 0
+	}
+
+	if job.EstimatedTokens > fit.similarTo {
+		return job.EstimatedTokens - fit.similarTo
+	}
+	return fit.similarTo - job.EstimatedTokens
+}
+
+func (fit *batchFit) add(job *ComputeJob) {
+	if fit.empty {
+		fit.similarTo = job.EstimatedTokens
+		fit.empty = false
+	}
+	if fit.limited() {
+		fit.tokensLeft -= job.EstimatedTokens
+		if fit.tokensLeft < 0 {
+			fit.tokensLeft = 0
+		}
+	}
+}
+
+// GetMaxBatchTokens - returns token budget of the batch, 0 - batches are limited by max-batch-size only
+func (n *InferenceNode) GetMaxBatchTokens() int {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	return n.MaxBatchTokens
+}
+
+// SetMaxBatchTokens - applies new token budget to the running node, starting from the next batch
+func (n *InferenceNode) SetMaxBatchTokens(maxBatchTokens int) {
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	n.MaxBatchTokens = maxBatchTokens
+}
+
+// AccountPromptTokens - compares estimate of the job's prompt tokens with the number reported by the engine
+func (n *InferenceNode) AccountPromptTokens(estimated, reported int) {
+	if reported <= 0 {
+		// engine doesn't report usage
+		return
+	}
+
+	atomic.AddUint64(&n.estimatedPromptTokens, uint64(estimated))
+	atomic.AddUint64(&n.reportedPromptTokens, uint64(reported))
+}
+
+// GetPromptTokensEstimateError - relative error of prompt token estimates, positive if they're too high,
+// false if engine hasn't reported any usage yet
+func (n *InferenceNode) GetPromptTokensEstimateError() (float64, bool) {
+	reported := atomic.LoadUint64(&n.reportedPromptTokens)
+	if reported == 0 {
+		return 0, false
+	}
+	estimated := atomic.LoadUint64(&n.estimatedPromptTokens)
+
+	return (float64(estimated) - float64(reported)) / float64(reported), true
+}
+
+// formatTokensPerSecond - generated tokens per second of the node's busy time
+func formatTokensPerSecond(node *InferenceNode, timeConsumed time.Duration) string {
+	if node.RemoteEngine == nil || timeConsumed <= 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1f", float64(atomic.LoadUint64(&node.RemoteEngine.TokensGenerated))/timeConsumed.Seconds())
+}
+
+func formatEstimateError(node *InferenceNode) string {
+	estimateError, ok := node.GetPromptTokensEstimateError()
+	if !ok {
+		return "-"
+	}
+
+	return fmt.Sprintf("%+.1f%%", estimateError*100)
+}
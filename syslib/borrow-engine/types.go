@@ -1,8 +1,11 @@
 package borrow_engine
 
 import (
+	"context"
+	"errors"
 	"github.com/d0rc/agent-os/engines"
 	"github.com/d0rc/agent-os/vectors"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,16 +29,59 @@ const (
 type ComputeResult struct {
 	CompletionChannel chan *engines.Message
 	EmbeddingChannel  chan *vectors.Vector
+	// StreamChannel is only set for streaming completion jobs, it receives
+	// generated text as it arrives, before the final message on CompletionChannel
+	StreamChannel chan string
+	// ErrorChannel receives an error if the job can't be executed at all
+	ErrorChannel chan error
 }
 
+var ErrNoNodeForModel = errors.New("no compute node serves requested model")
+
+// ErrStreamInterrupted - job failed after some of its text was streamed, retry would stream it again
+var ErrStreamInterrupted = errors.New("compute job failed after its text was streamed")
+
 type ComputeJob struct {
-	JobId              string
-	JobType            JobType
-	Priority           JobPriority
-	Process            string
+	JobId     string
+	JobType   JobType
+	Priority  JobPriority
+	Process   string
+	ModelMask string   // glob over node models, empty or `*` - any model
+	Tags      []string // tags of the client request, used for quotas
+	// EstimatedTokens - prompt length, set by AddJob if not known, used to fit batches into node's token budget
+	EstimatedTokens int
+	// Ctx - job is dropped from the queues once it's done, nil - job can't be cancelled
+	Ctx                context.Context
 	receivedAt         time.Time
+	attempts           int      // failed batches the job was part of
+	rateLimits         int      // batches the job was part of, which engine refused due to rate limits
+	streamed           int32    // set by MarkStreamed
+	quotaKeys          []string // quotas the job is accounted in, set once job is admitted
+	promptTokens       uint64   // reported by the engine, see AccountUsage
+	generatedTokens    uint64
 	GenerationSettings *engines.GenerationSettings
 	ComputeResult      *ComputeResult
 }
 
+// MarkStreamed - should be called by compute function before the job's text goes out on the stream,
+// such job is failed rather than retried, since its client would get the text twice
+func (job *ComputeJob) MarkStreamed() {
+	atomic.StoreInt32(&job.streamed, 1)
+}
+
+func (job *ComputeJob) isStreamed() bool {
+	return atomic.LoadInt32(&job.streamed) != 0
+}
+
+// cancelled - returns the reason if job is no longer needed
+func (job *ComputeJob) cancelled() error {
+	if job.Ctx == nil {
+		return nil
+	}
+
+	return job.Ctx.Err()
+}
+
+// ComputeFunction - runs batch of jobs on the node, if it fails, jobs it returns are the ones
+// which got their results anyway, the rest of the batch is retried
 type ComputeFunction map[JobType]func(*InferenceNode, []*ComputeJob) ([]*ComputeJob, error)
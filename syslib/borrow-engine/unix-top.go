@@ -9,15 +9,14 @@ import (
 	"os"
 	"sort"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 )
 
-func (ie *InferenceEngine) PrintTop(jobsBuffer map[JobPriority][]*ComputeJob, lock *sync.RWMutex) {
+func (ie *InferenceEngine) PrintTop() {
 	if ie.settings.TermUI == false {
 		// let's create a string builder
-		topInfo := ie.buildTopString(jobsBuffer, lock, false)
+		topInfo := ie.buildTopString(false)
 
 		fmt.Printf("%s", topInfo.topString)
 	}
@@ -28,9 +27,10 @@ type topDataInfo struct {
 	computeEngines [][]string
 	topLines       string
 	processesLines [][]string
+	quotasLines    [][]string
 }
 
-func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJob, lock *sync.RWMutex, termUi bool) *topDataInfo {
+func (ie *InferenceEngine) buildTopString(termUi bool) *topDataInfo {
 	result := &topDataInfo{
 		computeEngines: make([][]string, 0),
 	}
@@ -38,13 +38,17 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 	// let's write to it
 	// clear screen
 	fmt.Fprintf(stringBuilder, "\033[2J")
-	topLines := fmt.Sprintf("Total jobs: %s, Total requests: %d, Total time consumed: %s, Total time idle: %s\n",
+	ie.statsLock.RLock()
+	topLines := fmt.Sprintf("Total jobs: %s, Cancelled: %d, Failed: %d, Total requests: %d, Total time consumed: %s, Total time idle: %s\n",
 		makeBrightCyan(termUi, humanize.SIWithDigits(float64(ie.TotalJobsProcessed), 2, "j")),
+		atomic.LoadUint64(&ie.TotalJobsCancelled),
+		atomic.LoadUint64(&ie.TotalJobsFailed),
 		ie.TotalRequestsProcessed,
 		ie.TotalTimeConsumed,
 		ie.TotalTimeIdle)
+	ie.statsLock.RUnlock()
 	topLines = topLines + fmt.Sprintf("Total jobs in buffer: %d(+%d), Total time in scheduler: %s, Uptime: %s\n",
-		countMapValueLens(jobsBuffer, lock),
+		ie.queuedJobsCount(),
 		len(ie.IncomingJobs),
 		ie.TotalTimeScheduling,
 		getUptime())
@@ -52,20 +56,24 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 	result.topLines = topLines
 	tw := tablewriter.NewWriter(stringBuilder)
 
-	computeEnginesHeaders := []string{"Endpoint", "Compute State", "Max (reqs/batch)", "Reqs/Jobs", "TimeConsumed", "TimeIdle", "T.Waisted", "Failed(R/J)"}
+	computeEnginesHeaders := []string{"Endpoint", "Compute State", "Max (reqs/batch)", "Reqs/Jobs", "TimeConsumed", "TimeIdle", "T.Waisted", "Failed(R/J)", "Tokens/s", "Tokens est."}
 	tw.SetHeader(computeEnginesHeaders)
 	result.computeEngines = append(result.computeEngines, computeEnginesHeaders)
 
-	for _, node := range ie.Nodes {
+	for _, node := range ie.GetNodes() {
+		maxRequests, maxBatchSize := node.GetLimits()
+		stats := ie.getNodeStats(node)
 		computeEnginesLine := []string{
 			shoLastNRunes(node.EndpointUrl, 35),
-			fmt.Sprintf("%v", getNodeState(termUi, int(atomic.LoadInt32(&node.RequestsRunning)))),
-			fmt.Sprintf("%d/%d", node.MaxRequests, node.MaxBatchSize),
-			fmt.Sprintf("%d/%d", node.TotalRequestsProcessed, node.TotalJobsProcessed),
-			fmt.Sprintf("%s", node.TotalTimeConsumed),
-			fmt.Sprintf("%s", node.TotalTimeIdle),
-			fmt.Sprintf("%s", node.TotalTimeWaisted),
-			fmt.Sprintf("%d/%d", node.TotalRequestsFailed, node.TotalJobsFailed),
+			fmt.Sprintf("%v", getNodeState(termUi, node)),
+			fmt.Sprintf("%d/%d", maxRequests, maxBatchSize),
+			fmt.Sprintf("%d/%d", stats.requests, stats.jobs),
+			fmt.Sprintf("%s", stats.timeConsumed),
+			fmt.Sprintf("%s", stats.timeIdle),
+			fmt.Sprintf("%s", stats.timeWasted),
+			fmt.Sprintf("%d/%d", stats.requestsFailed, stats.jobsFailed),
+			formatTokensPerSecond(node, stats.timeConsumed),
+			formatEstimateError(node),
 		}
 		tw.Append(computeEnginesLine)
 		result.computeEngines = append(result.computeEngines, computeEnginesLine)
@@ -74,10 +82,10 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 
 	tw = tablewriter.NewWriter(stringBuilder)
 	processesHeadersLines := make([][]string, 0)
-	processesHeaders := []string{"Process", "TotalRequestsProcessed", "TotalJobsProcessed", "TotalTimeConsumed", "AvgWait"}
+	processesHeaders := []string{"Process", "TotalRequestsProcessed", "TotalJobsProcessed", "TotalTimeConsumed", "AvgWait", "Tokens (p/g)"}
 	tw.SetHeader(processesHeaders)
 	processesHeadersLines = append(processesHeadersLines, processesHeaders)
-	lock.RLock()
+	ie.statsLock.RLock()
 
 	type ProcessInfo struct {
 		TotalRequests uint64
@@ -109,20 +117,71 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 			fmt.Sprintf("%d", ie.ProcessesTotalJobs[processData.Name]),
 			fmt.Sprintf("%s", ie.ProcessesTotalTimeConsumed[processData.Name]),
 			fmt.Sprintf("%s", fmt.Sprintf("%4.4f", float64(ie.ProcessesTotalTimeWaiting[processData.Name]/time.Millisecond)/float64(ie.ProcessesTotalJobs[processData.Name]))),
+			fmt.Sprintf("%s/%s",
+				humanize.SIWithDigits(float64(ie.ProcessesTotalPromptTokens[processData.Name]), 1, ""),
+				humanize.SIWithDigits(float64(ie.ProcessesTotalGeneratedTokens[processData.Name]), 1, "")),
 		}
 		if idx < 7 {
 			tw.Append(processesHeadersLine)
 			processesHeadersLines = append(processesHeadersLines, processesHeadersLine)
 		}
 	}
-	lock.RUnlock()
+	ie.statsLock.RUnlock()
 	tw.Render()
 
+	result.quotasLines = ie.buildQuotasLines()
+	if len(result.quotasLines) > 1 {
+		tw = tablewriter.NewWriter(stringBuilder)
+		tw.SetHeader(result.quotasLines[0])
+		tw.AppendBulk(result.quotasLines[1:])
+		tw.Render()
+	}
+
 	result.topString = stringBuilder.String()
 	result.processesLines = processesHeadersLines
 	return result
 }
 
+// buildQuotasLines - header and usage of quotas, limits are shown after the slash
+func (ie *InferenceEngine) buildQuotasLines() [][]string {
+	lines := [][]string{{"Quota", "Running", "Jobs/min", "GPU-s/hour", "Spend/day", "Deferred", "Rejected"}}
+	for _, state := range ie.GetQuotaStates() {
+		quota := state.Quota
+		if quota == nil {
+			// rule was removed by configuration reload
+			quota = &Quota{}
+		}
+		lines = append(lines, []string{
+			state.Subject,
+			formatQuotaUsage(float64(state.Running), float64(quota.MaxConcurrentJobs)),
+			formatQuotaUsage(float64(state.JobsPerMinute), float64(quota.JobsPerMinute)),
+			formatQuotaUsage(state.GPUSeconds, quota.GPUSecondsPerHour),
+			formatQuotaSpend(state.Spend, quota.SpendPerDay),
+			fmt.Sprintf("%d", state.Deferred),
+			fmt.Sprintf("%d", state.Rejected),
+		})
+	}
+
+	return lines
+}
+
+func formatQuotaUsage(used, limit float64) string {
+	if limit <= 0 {
+		return fmt.Sprintf("%.0f", used)
+	}
+
+	return fmt.Sprintf("%.0f/%.0f", used, limit)
+}
+
+// formatQuotaSpend - budgets are usually small sums, so cents are shown
+func formatQuotaSpend(spent, budget float64) string {
+	if budget <= 0 {
+		return fmt.Sprintf("%.2f", spent)
+	}
+
+	return fmt.Sprintf("%.2f/%.2f", spent, budget)
+}
+
 func makeBrightCyan(ui bool, digits string) string {
 	if !ui {
 		return aurora.BrightCyan(digits).String()
@@ -141,7 +200,29 @@ func shoLastNRunes(url string, i int) string {
 	return fmt.Sprintf("...%s", url[len(url)-i:])
 }
 
-func getNodeState(ui bool, running int) interface{} {
+func getNodeState(ui bool, node *InferenceNode) interface{} {
+	if resumeIn := time.Until(node.RateLimitedUntil()).Truncate(time.Second); resumeIn > 0 {
+		return fmt.Sprintf("%s - resume in %s", makeBrightYellow(ui, "rate limited"), resumeIn)
+	}
+
+	health, probeAt := node.GetHealth()
+	switch health {
+	case NH_Quarantined:
+		probeIn := time.Until(probeAt).Truncate(time.Second)
+		if probeIn < 0 {
+			probeIn = 0
+		}
+		return fmt.Sprintf("%s - probe in %s", makeBrightRed(ui, "quarantined"), probeIn)
+	case NH_Degraded:
+		return fmt.Sprintf("%s - %s",
+			makeBrightYellow(ui, "degraded"),
+			getNodeLoad(ui, int(atomic.LoadInt32(&node.RequestsRunning))))
+	}
+
+	return getNodeLoad(ui, int(atomic.LoadInt32(&node.RequestsRunning)))
+}
+
+func getNodeLoad(ui bool, running int) string {
 	if running == 0 {
 		return makeBrightWhite(ui, "idle")
 	}
@@ -151,6 +232,22 @@ func getNodeState(ui bool, running int) interface{} {
 		makeBrightCyan(ui, fmt.Sprintf("%d", running)))
 }
 
+func makeBrightRed(ui bool, s string) string {
+	if !ui {
+		return aurora.BrightRed(s).String()
+	}
+
+	return fmt.Sprintf("[%s](fg:red,mod:bold)", s)
+}
+
+func makeBrightYellow(ui bool, s string) string {
+	if !ui {
+		return aurora.BrightYellow(s).String()
+	}
+
+	return fmt.Sprintf("[%s](fg:yellow,mod:bold)", s)
+}
+
 func makeBrightGreen(ui bool, s string) string {
 	if !ui {
 		return aurora.BrightGreen(s).String()
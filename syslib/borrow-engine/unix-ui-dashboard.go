@@ -6,12 +6,11 @@ import (
 	"github.com/d0rc/agent-os/stdlib/metrics"
 	ui "github.com/gizak/termui/v3"
 	"log"
-	"sync"
 	"time"
 )
 import "github.com/gizak/termui/v3/widgets"
 
-func (ie *InferenceEngine) ui(jobsBuffer map[JobPriority][]*ComputeJob, lock *sync.RWMutex) {
+func (ie *InferenceEngine) ui() {
 	if err := ui.Init(); err != nil {
 		log.Fatalf("failed to initialize termui: %v", err)
 	}
@@ -30,7 +29,7 @@ func (ie *InferenceEngine) ui(jobsBuffer map[JobPriority][]*ComputeJob, lock *sy
 	selectedComputeNode := 0
 	for {
 		//rounds++
-		topInfo := ie.buildTopString(jobsBuffer, lock, true)
+		topInfo := ie.buildTopString(true)
 		p0.Text = topInfo.topLines
 
 		// p0.Text = topInfo.topString
@@ -86,10 +85,24 @@ func (ie *InferenceEngine) ui(jobsBuffer map[JobPriority][]*ComputeJob, lock *sy
 		computeEnds := 4 + len(topInfo.computeEngines) + 2
 		computeTable.SetRect(0, 4, x2, computeEnds)
 
-		processesTable.SetRect(0, computeEnds, x2, computeEnds+2+min(len(topInfo.processesLines), max(5, len(topInfo.processesLines))))
-		logPane.SetRect(0, computeEnds+2+min(len(topInfo.processesLines), max(5, len(topInfo.processesLines))), x2, y2)
+		processesEnds := computeEnds + 2 + min(len(topInfo.processesLines), max(5, len(topInfo.processesLines)))
+		processesTable.SetRect(0, computeEnds, x2, processesEnds)
+		logPaneStarts := processesEnds
+		widgetsToRender := []ui.Drawable{p0, computeTable, processesTable}
+		if len(topInfo.quotasLines) > 1 {
+			quotasTable := widgets.NewTable()
+			quotasTable.Title = "[ Quotas ]"
+			quotasTable.RowSeparator = false
+			quotasTable.Rows = topInfo.quotasLines
+			quotasTable.FillRow = true
+			quotasTable.RowStyles[0] = ui.NewStyle(ui.ColorWhite, ui.ColorBlack, ui.ModifierBold)
+			logPaneStarts = processesEnds + 2 + len(topInfo.quotasLines)
+			quotasTable.SetRect(0, processesEnds, x2, logPaneStarts)
+			widgetsToRender = append(widgetsToRender, quotasTable)
+		}
+		logPane.SetRect(0, logPaneStarts, x2, y2)
 
-		ui.Render(p0, computeTable, logPane, processesTable)
+		ui.Render(append(widgetsToRender, logPane)...)
 
 		timer := time.NewTimer(100 * time.Millisecond)
 		select {
@@ -116,11 +129,12 @@ func (ie *InferenceEngine) ui(jobsBuffer map[JobPriority][]*ComputeJob, lock *sy
 				}
 			case "e":
 				// disable embeddings processing on current node
-				if selectedComputeNode > 0 && selectedComputeNode <= len(ie.Nodes) {
-					if len(ie.Nodes[selectedComputeNode-1].JobTypes) == 1 {
-						ie.Nodes[selectedComputeNode-1].JobTypes = []JobType{JT_Completion, JT_Embeddings}
+				nodes := ie.GetNodes()
+				if selectedComputeNode > 0 && selectedComputeNode <= len(nodes) {
+					if len(nodes[selectedComputeNode-1].JobTypes) == 1 {
+						nodes[selectedComputeNode-1].JobTypes = []JobType{JT_Completion, JT_Embeddings}
 					} else {
-						ie.Nodes[selectedComputeNode-1].JobTypes = []JobType{JT_Completion}
+						nodes[selectedComputeNode-1].JobTypes = []JobType{JT_Completion}
 					}
 				}
 			case "q", "<C-c>":
@@ -0,0 +1,125 @@
+package borrow_engine
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UsageKey - dimensions compute usage is accounted by
+type UsageKey struct {
+	Process  string
+	Tags     string // sorted tags of the jobs, comma separated
+	Endpoint string
+	Model    string
+}
+
+// Usage - compute used since the last TakeUsage, requests are batches jobs were part of
+type Usage struct {
+	Requests        uint64
+	Jobs            uint64
+	PromptTokens    uint64
+	GeneratedTokens uint64
+	GPUSeconds      float64
+	Cost            float64 // spent on paid nodes, see NodeCost
+}
+
+func (u *Usage) add(other *Usage) {
+	u.Requests += other.Requests
+	u.Jobs += other.Jobs
+	u.PromptTokens += other.PromptTokens
+	u.GeneratedTokens += other.GeneratedTokens
+	u.GPUSeconds += other.GPUSeconds
+	u.Cost += other.Cost
+}
+
+type usageAccounting struct {
+	lock  sync.Mutex
+	usage map[UsageKey]*Usage
+}
+
+func newUsageAccounting() *usageAccounting {
+	return &usageAccounting{
+		usage: make(map[UsageKey]*Usage),
+	}
+}
+
+// AccountUsage - tokens reported by the engine for the job, safe to call from any goroutine
+func (job *ComputeJob) AccountUsage(promptTokens, generatedTokens int) {
+	atomic.AddUint64(&job.promptTokens, uint64(promptTokens))
+	atomic.AddUint64(&job.generatedTokens, uint64(generatedTokens))
+}
+
+func usageKey(node *InferenceNode, job *ComputeJob) UsageKey {
+	tags := append([]string(nil), job.Tags...)
+	sort.Strings(tags)
+	key := UsageKey{
+		Process:  job.Process,
+		Tags:     strings.Join(tags, ","),
+		Endpoint: node.EndpointUrl,
+	}
+	if job.GenerationSettings != nil {
+		key.Model = job.GenerationSettings.Model
+	}
+
+	return key
+}
+
+// accountBatch - batch time is split evenly between its jobs, same as for quotas,
+// failed batches are accounted with GPU time and its cost only
+func (a *usageAccounting) accountBatch(node *InferenceNode, batch []*ComputeJob, batchTime time.Duration, failed bool) {
+	cost := node.GetCost()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	jobTime := batchTime.Seconds() / float64(len(batch))
+	requests := make(map[UsageKey]bool)
+	for _, job := range batch {
+		key := usageKey(node, job)
+		usage, exists := a.usage[key]
+		if !exists {
+			usage = &Usage{}
+			a.usage[key] = usage
+		}
+		usage.GPUSeconds += jobTime
+		usage.Cost += cost.jobCost(job, batchTime/time.Duration(len(batch)), failed)
+		if failed {
+			continue
+		}
+		if !requests[key] {
+			requests[key] = true
+			usage.Requests++
+		}
+		usage.Jobs++
+		usage.PromptTokens += atomic.LoadUint64(&job.promptTokens)
+		usage.GeneratedTokens += atomic.LoadUint64(&job.generatedTokens)
+	}
+}
+
+// TakeUsage - returns compute used since the previous call
+func (ie *InferenceEngine) TakeUsage() map[UsageKey]*Usage {
+	ie.usage.lock.Lock()
+	defer ie.usage.lock.Unlock()
+
+	usage := ie.usage.usage
+	ie.usage.usage = make(map[UsageKey]*Usage)
+
+	return usage
+}
+
+// ReturnUsage - puts usage taken with TakeUsage back, e.g. when it couldn't be saved
+func (ie *InferenceEngine) ReturnUsage(usage map[UsageKey]*Usage) {
+	ie.usage.lock.Lock()
+	defer ie.usage.lock.Unlock()
+
+	for key, u := range usage {
+		if existing, exists := ie.usage.usage[key]; exists {
+			existing.add(u)
+		} else {
+			ie.usage.usage[key] = u
+		}
+	}
+}
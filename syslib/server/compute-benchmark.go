@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"github.com/d0rc/agent-os/stdlib/settings"
+	"github.com/d0rc/agent-os/stdlib/storage"
+	be "github.com/d0rc/agent-os/syslib/borrow-engine"
+	"time"
+)
+
+// BenchmarkCompute - measures batching limits of compute nodes with the endpoint,
+// or of all nodes if it's empty; measured limits are applied and persisted
+func (ctx *Context) BenchmarkCompute(endpoint string) ([]*be.BenchmarkResult, error) {
+	ctx.computeLock.Lock()
+	nodes := make([]*computeNode, 0, len(ctx.computeNodes))
+	for _, running := range ctx.computeNodes {
+		if endpoint == "" || running.node.EndpointUrl == endpoint || running.config.EmbeddingsEndpoint == endpoint {
+			nodes = append(nodes, running)
+		}
+	}
+	ctx.computeLock.Unlock()
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no compute node with endpoint %s", endpoint)
+	}
+
+	results := make([]*be.BenchmarkResult, 0, len(nodes))
+	for _, running := range nodes {
+		result, err := ctx.benchmarkComputeNode(running)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (ctx *Context) benchmarkComputeNode(running *computeNode) (*be.BenchmarkResult, error) {
+	ctx.Log.Info().Msgf("benchmarking compute node: %s", running.node.EndpointUrl)
+	result, err := be.BenchmarkNode(ctx.Log, running.node, nil)
+	if err != nil {
+		return result, err
+	}
+
+	ctx.ComputeRouter.ApplyBenchmarkResult(running.node, result)
+	ctx.Log.Info().Msgf("compute node %s benchmarked: max-requests %d, max-batch-size %d, %.1f tokens/s",
+		running.node.EndpointUrl, result.MaxRequests, result.MaxBatchSize, result.Performance)
+
+	err = ctx.Storage.SaveComputeNodeLimits(&storage.ComputeNodeLimits{
+		Endpoint:     computeNodeEndpoint(running.config),
+		JobType:      running.config.JobTypes[0],
+		MaxBatchSize: result.MaxBatchSize,
+		MaxRequests:  result.MaxRequests,
+		Performance:  result.Performance,
+		MeasuredAt:   time.Now(),
+	})
+	if err != nil {
+		return result, fmt.Errorf("error saving compute node limits: %w", err)
+	}
+
+	return result, nil
+}
+
+// applyStoredComputeNodeLimits - sets limits measured earlier to the node before it's added,
+// returns nil if node was never measured
+func (ctx *Context) applyStoredComputeNodeLimits(nodeConfig settings.ComputeConfigurationSection, node *be.InferenceNode) *storage.ComputeNodeLimits {
+	limits, err := ctx.Storage.GetComputeNodeLimits(computeNodeEndpoint(nodeConfig), nodeConfig.JobTypes[0])
+	if err != nil {
+		ctx.Log.Error().Err(err).Msgf("error loading limits of compute node %s", node.EndpointUrl)
+		return nil
+	}
+	if limits == nil {
+		return nil
+	}
+
+	ctx.Log.Info().Msgf("compute node %s uses limits measured at %s: max-requests %d, max-batch-size %d",
+		node.EndpointUrl, limits.MeasuredAt.Format(time.RFC3339), limits.MaxRequests, limits.MaxBatchSize)
+	node.MaxRequests = limits.MaxRequests
+	node.MaxBatchSize = limits.MaxBatchSize
+
+	return limits
+}
+
+func computeNodeEndpoint(nodeConfig settings.ComputeConfigurationSection) string {
+	if nodeConfig.Endpoint == "" {
+		return nodeConfig.EmbeddingsEndpoint
+	}
+
+	return nodeConfig.Endpoint
+}
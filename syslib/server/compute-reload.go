@@ -0,0 +1,213 @@
+package server
+
+import (
+	"fmt"
+	"github.com/d0rc/agent-os/stdlib/settings"
+	"github.com/d0rc/agent-os/stdlib/storage"
+	be "github.com/d0rc/agent-os/syslib/borrow-engine"
+	"reflect"
+)
+
+type computeNode struct {
+	config settings.ComputeConfigurationSection
+	node   *be.InferenceNode
+}
+
+// ComputeReloadResult - endpoints of compute nodes changed by configuration reload
+type ComputeReloadResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+}
+
+// ReloadCompute - re-reads configuration file and applies its `compute` and `quotas` sections
+// to the running compute router, other sections need a restart
+func (ctx *Context) ReloadCompute() (*ComputeReloadResult, error) {
+	config, err := settings.ProcessConfigurationFile(ctx.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.computeLock.Lock()
+	ctx.Config.Quotas = config.Quotas
+	ctx.ComputeRouter.SetQuotas(translateQuotas(config.Quotas))
+	ctx.computeLock.Unlock()
+
+	return ctx.ApplyComputeConfig(config.Compute), nil
+}
+
+// ApplyComputeConfig - diffs compute nodes against the running ones: new nodes are added,
+// missing nodes are drained, nodes with changed limits, scheduler or cost are updated in place
+// and nodes with changed protocol or token are replaced
+func (ctx *Context) ApplyComputeConfig(compute []settings.ComputeConfigurationSection) *ComputeReloadResult {
+	ctx.computeLock.Lock()
+	defer ctx.computeLock.Unlock()
+
+	result := &ComputeReloadResult{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Updated: make([]string, 0),
+	}
+
+	wanted := make(map[string]settings.ComputeConfigurationSection, len(compute))
+	for _, nodeConfig := range compute {
+		wanted[nodeConfig.Key()] = nodeConfig
+	}
+
+	replaced := make(map[string]struct{})
+	for key, running := range ctx.computeNodes {
+		nodeConfig, exists := wanted[key]
+		if exists && nodeConfig.Type == running.config.Type && nodeConfig.Token == running.config.Token {
+			continue
+		}
+		ctx.removeComputeNode(key)
+		if exists {
+			replaced[key] = struct{}{}
+			result.Updated = append(result.Updated, describeComputeNode(nodeConfig))
+		} else {
+			result.Removed = append(result.Removed, describeComputeNode(running.config))
+		}
+	}
+
+	for key, nodeConfig := range wanted {
+		running, exists := ctx.computeNodes[key]
+		if !exists {
+			detected := ctx.addComputeNode(nodeConfig)
+			go func() {
+				gotNode := <-detected
+				ctx.Log.Info().Msgf("compute node auto-detected: %s", gotNode.EndpointUrl)
+			}()
+			if _, isReplaced := replaced[key]; !isReplaced {
+				result.Added = append(result.Added, describeComputeNode(nodeConfig))
+			}
+			continue
+		}
+
+		updated := false
+		// benchmarked nodes keep measured limits
+		if nodeConfig.Benchmark == "" &&
+			(nodeConfig.MaxRequests != running.config.MaxRequests || nodeConfig.MaxBatchSize != running.config.MaxBatchSize) {
+			ctx.ComputeRouter.UpdateNodeLimits(running.node, nodeConfig.MaxRequests, nodeConfig.MaxBatchSize)
+			updated = true
+		}
+		if nodeConfig.MaxBatchTokens != running.config.MaxBatchTokens {
+			running.node.SetMaxBatchTokens(nodeConfig.MaxBatchTokens)
+			updated = true
+		}
+		if !reflect.DeepEqual(nodeConfig.Scheduler, running.config.Scheduler) {
+			running.node.SetScheduler(translateScheduler(nodeConfig.Scheduler))
+			updated = true
+		}
+		if nodeConfig.Cost != running.config.Cost {
+			running.node.SetCost(translateCost(nodeConfig.Cost))
+			updated = true
+		}
+		if updated {
+			result.Updated = append(result.Updated, describeComputeNode(nodeConfig))
+		}
+		running.config = nodeConfig
+	}
+
+	ctx.Config.Compute = compute
+
+	return result
+}
+
+// addComputeNode - should be called with computeLock held
+func (ctx *Context) addComputeNode(nodeConfig settings.ComputeConfigurationSection) chan *be.InferenceNode {
+	ctx.Log.Info().Msgf("adding compute node: %s", nodeConfig.Endpoint)
+	node := &be.InferenceNode{
+		EndpointUrl:           nodeConfig.Endpoint,
+		EmbeddingsEndpointUrl: nodeConfig.EmbeddingsEndpoint,
+		MaxRequests:           nodeConfig.MaxRequests,
+		MaxBatchSize:          nodeConfig.MaxBatchSize,
+		MaxBatchTokens:        nodeConfig.MaxBatchTokens,
+		JobTypes:              translateJobTypes(nodeConfig.JobTypes),
+		Protocol:              nodeConfig.Type,
+		Token:                 nodeConfig.Token,
+		Scheduler:             translateScheduler(nodeConfig.Scheduler),
+		Cost:                  translateCost(nodeConfig.Cost),
+	}
+	running := &computeNode{
+		config: nodeConfig,
+		node:   node,
+	}
+	ctx.computeNodes[nodeConfig.Key()] = running
+
+	var measured *storage.ComputeNodeLimits
+	if nodeConfig.Benchmark == settings.BenchmarkAuto {
+		measured = ctx.applyStoredComputeNodeLimits(nodeConfig, node)
+	}
+	detected := ctx.ComputeRouter.AddNode(node)
+	if measured != nil {
+		node.RemoteEngine.Performance = measured.Performance
+	}
+	if nodeConfig.Benchmark == "" || measured != nil {
+		return detected
+	}
+
+	// benchmark needs detected models, and it shouldn't delay the start
+	forwarded := make(chan *be.InferenceNode, 1)
+	go func() {
+		gotNode := <-detected
+		forwarded <- gotNode
+		if gotNode.RemoteEngine.CompletionFailed && gotNode.RemoteEngine.EmbeddingsFailed {
+			return
+		}
+		if _, err := ctx.benchmarkComputeNode(running); err != nil {
+			ctx.Log.Error().Err(err).Msgf("error benchmarking compute node %s", node.EndpointUrl)
+		}
+	}()
+
+	return forwarded
+}
+
+// removeComputeNode - should be called with computeLock held
+func (ctx *Context) removeComputeNode(key string) {
+	running := ctx.computeNodes[key]
+	delete(ctx.computeNodes, key)
+
+	ctx.Log.Info().Msgf("draining compute node: %s", running.node.EndpointUrl)
+	drained := ctx.ComputeRouter.RemoveNode(running.node)
+	go func() {
+		<-drained
+		ctx.Log.Info().Msgf("compute node removed: %s", running.node.EndpointUrl)
+	}()
+}
+
+func describeComputeNode(nodeConfig settings.ComputeConfigurationSection) string {
+	return fmt.Sprintf("%s (%s)", computeNodeEndpoint(nodeConfig), nodeConfig.Type)
+}
+
+// translateScheduler - settings are validated, so unknown names are left for borrow-engine defaults
+func translateScheduler(config settings.SchedulerConfigurationSection) *be.SchedulerSettings {
+	policy, _ := be.ParseSchedulingPolicy(config.Policy)
+	scheduler := &be.SchedulerSettings{
+		Policy:          policy,
+		BatchingLatency: config.BatchingLatency,
+		IdlePoll:        config.IdlePoll,
+		PriorityWeights: make(map[be.JobPriority]int, len(config.PriorityWeights)),
+		AgingInterval:   config.AgingInterval,
+	}
+	for name, weight := range config.PriorityWeights {
+		if priority, err := be.ParseJobPriority(name); err == nil {
+			scheduler.PriorityWeights[priority] = weight
+		}
+	}
+
+	return scheduler
+}
+
+// translateCost - node without prices is free
+func translateCost(config settings.CostConfigurationSection) *be.NodeCost {
+	if config.PromptPer1K == 0 && config.GeneratedPer1K == 0 && config.PerHour == 0 {
+		return nil
+	}
+
+	return &be.NodeCost{
+		PromptPer1K:    config.PromptPer1K,
+		GeneratedPer1K: config.GeneratedPer1K,
+		PerHour:        config.PerHour,
+		SpillAfter:     config.SpillAfter,
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"github.com/d0rc/agent-os/stdlib/settings"
+	"github.com/d0rc/agent-os/stdlib/storage"
+	be "github.com/d0rc/agent-os/syslib/borrow-engine"
+	"time"
+)
+
+// SaveComputeUsage - writes compute used since the previous snapshot to the database,
+// usage which can't be written is kept for the next snapshot, along with the start of its period,
+// so periods of the records of every key never overlap
+func (ctx *Context) SaveComputeUsage() error {
+	ctx.usageLock.Lock()
+	defer ctx.usageLock.Unlock()
+
+	now := time.Now()
+	if ctx.usageSnapshotAt.IsZero() {
+		ctx.usageSnapshotAt = now
+	}
+
+	var saveErr error
+	unsavedSince := make(map[be.UsageKey]time.Time)
+	usage := ctx.ComputeRouter.TakeUsage()
+	for key, u := range usage {
+		periodStart := ctx.usageSnapshotAt
+		if since, exists := ctx.usageUnsavedSince[key]; exists {
+			periodStart = since
+		}
+		err := ctx.Storage.SaveComputeUsage(&storage.ComputeUsageRecord{
+			PeriodStart:     periodStart,
+			PeriodEnd:       now,
+			Process:         key.Process,
+			Tags:            key.Tags,
+			Endpoint:        key.Endpoint,
+			Model:           key.Model,
+			Requests:        u.Requests,
+			Jobs:            u.Jobs,
+			PromptTokens:    u.PromptTokens,
+			GeneratedTokens: u.GeneratedTokens,
+			GPUSeconds:      u.GPUSeconds,
+			Cost:            u.Cost,
+		})
+		if err != nil {
+			saveErr = err
+			unsavedSince[key] = periodStart
+			continue
+		}
+		delete(usage, key)
+	}
+
+	ctx.usageSnapshotAt = now
+	ctx.usageUnsavedSince = unsavedSince
+	if saveErr != nil {
+		ctx.ComputeRouter.ReturnUsage(usage)
+	}
+
+	return saveErr
+}
+
+// snapshotComputeUsage - saves compute usage every accounting snapshot-interval
+func (ctx *Context) snapshotComputeUsage() {
+	interval := ctx.Config.Accounting.SnapshotInterval
+	if interval <= 0 {
+		interval = settings.DefaultAccountingSnapshotInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ctx.SaveComputeUsage(); err != nil {
+			ctx.Log.Error().Err(err).Msg("error saving compute usage, will retry with the next snapshot")
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/logrusorgru/aurora"
 	"github.com/rs/zerolog"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -20,6 +21,18 @@ type Context struct {
 	VectorDBs            []vectors.VectorDB
 	ComputeRouter        *be.InferenceEngine
 	DefaultEmbeddingsDim int
+	// Pages and Search are used by get-page and google-search, crawlbase and serpapi if not set
+	Pages  PageProvider
+	Search SearchProvider
+
+	configPath   string
+	computeLock  sync.Mutex
+	computeNodes map[string]*computeNode
+
+	usageLock       sync.Mutex
+	usageSnapshotAt time.Time // end of the last saved compute usage period
+	// usageUnsavedSince - start of the period of usage which failed to be saved, by its key
+	usageUnsavedSince map[be.UsageKey]time.Time
 }
 
 type Settings struct {
@@ -34,14 +47,35 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 		return nil, err
 	}
 
-	db, err := storage.NewStorage(lg, "")
+	db, err := storage.NewStorageFromConfig(lg, &config.Database)
 	if err != nil {
 		fmt.Printf("error creating storage: %v\n", aurora.BrightRed(err))
 		fmt.Printf("confgiration file used: %s\n", configPath)
 		os.Exit(1)
 	}
 
-	computeRouter := be.NewInferenceEngine(lg, be.ComputeFunction{
+	computeRouter := be.NewInferenceEngine(lg, NewComputeFunction(lg), &be.InferenceEngineSettings{
+		TopInterval: srvSettings.TopInterval,
+		TermUI:      srvSettings.TermUI,
+		LogChan:     srvSettings.LogChan,
+		Quotas:      translateQuotas(config.Quotas),
+	})
+
+	return &Context{
+		Config:        config,
+		Log:           lg.With().Str("cfg-file", configPath).Logger(),
+		Storage:       db,
+		ComputeRouter: computeRouter,
+		configPath:    configPath,
+		computeNodes:  make(map[string]*computeNode),
+		// usage starts being accounted along with the compute router
+		usageSnapshotAt: time.Now(),
+	}, nil
+}
+
+// NewComputeFunction - runs jobs of the compute router with engines of its nodes
+func NewComputeFunction(lg zerolog.Logger) be.ComputeFunction {
+	return be.ComputeFunction{
 		be.JT_Completion: func(n *be.InferenceNode, jobs []*be.ComputeJob) ([]*be.ComputeJob, error) {
 			lg.Warn().Msg("completion job received")
 			if len(jobs) == 0 {
@@ -49,31 +83,43 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 			}
 			tasks := make([]*engines.JobQueueTask, len(jobs))
 			resChan := make([]chan *engines.Message, len(jobs))
+			streamsDone := make([]func(), len(jobs))
 			for idx, job := range jobs {
 				resChan[idx] = make(chan *engines.Message, 1)
 				tasks[idx] = &engines.JobQueueTask{
-					Req: job.GenerationSettings,
+					Req: withUsageAccounting(n, job),
 					Res: resChan[idx],
 				}
+				tasks[idx].ResStream, streamsDone[idx] = streamTo(job)
 			}
 
 			_, err := engines.RunCompletionRequest(lg, n.RemoteEngine, tasks)
+			for _, streamDone := range streamsDone {
+				// all the text is sent to the clients before final messages
+				streamDone()
+			}
 			if err != nil {
 				lg.Error().Err(err).Msgf("error running completion request: %v", err)
-				return nil, err
 			}
 
+			done := make([]*be.ComputeJob, 0, len(jobs))
 			for idx, job := range jobs {
+				if engines.Failed(err, idx) {
+					// failed jobs got no result, they're retried by the router
+					continue
+				}
 				failureTimeout := time.NewTimer(120 * time.Second)
 				select {
 				case <-failureTimeout.C:
 					lg.Error().Msg("completion request timed out")
-					return nil, err
+					// reported as failure, so the node's health accounts for it
+					return done, fmt.Errorf("completion request timed out on %s", n.EndpointUrl)
 				case tmpResult := <-resChan[idx]:
 					job.ComputeResult.CompletionChannel <- tmpResult
 				}
+				done = append(done, job)
 			}
-			return jobs, nil
+			return done, err
 		},
 		be.JT_Embeddings: func(n *be.InferenceNode, jobs []*be.ComputeJob) ([]*be.ComputeJob, error) {
 			//			lg.Warn().Msg("embedding job received")
@@ -104,7 +150,7 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 				select {
 				case <-failureTimeout.C:
 					lg.Error().Msg("embedding request timed out")
-					return nil, err
+					return nil, fmt.Errorf("embedding request timed out on %s", n.EndpointUrl)
 				case tmpResult := <-resChan[idx]:
 					job.ComputeResult.EmbeddingChannel <- tmpResult
 				}
@@ -112,22 +158,11 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 			}
 			return jobs, nil
 		},
-	}, &be.InferenceEngineSettings{
-		TopInterval: srvSettings.TopInterval,
-		TermUI:      srvSettings.TermUI,
-		LogChan:     srvSettings.LogChan,
-	})
-
-	return &Context{
-		Config:        config,
-		Log:           lg.With().Str("cfg-file", configPath).Logger(),
-		Storage:       db,
-		ComputeRouter: computeRouter,
-	}, nil
+	}
 }
 
 func (ctx *Context) GetDefaultEmbeddingDims() uint64 {
-	for _, node := range ctx.ComputeRouter.Nodes {
+	for _, node := range ctx.ComputeRouter.GetNodes() {
 		if node.RemoteEngine.EmbeddingsDims != nil {
 			return *node.RemoteEngine.EmbeddingsDims
 		}
@@ -137,21 +172,16 @@ func (ctx *Context) GetDefaultEmbeddingDims() uint64 {
 }
 
 func (ctx *Context) Start(onStart func(ctx *Context)) {
+	// router runs even without compute nodes, so they can be added by reloading config
+	go ctx.ComputeRouter.Run()
+	go ctx.snapshotComputeUsage()
 	if len(ctx.Config.Compute) > 0 {
-		go ctx.ComputeRouter.Run()
 		detectedComputes := make([]chan *be.InferenceNode, 0, len(ctx.Config.Compute))
+		ctx.computeLock.Lock()
 		for _, node := range ctx.Config.Compute {
-			ctx.Log.Info().Msgf("adding compute node: %s", node.Endpoint)
-			detectedComputes = append(detectedComputes, ctx.ComputeRouter.AddNode(&be.InferenceNode{
-				EndpointUrl:           node.Endpoint,
-				EmbeddingsEndpointUrl: node.EmbeddingsEndpoint,
-				MaxRequests:           node.MaxRequests,
-				MaxBatchSize:          node.MaxBatchSize,
-				JobTypes:              translateJobTypes(node.JobTypes),
-				Protocol:              node.Type,
-				Token:                 node.Token,
-			}))
+			detectedComputes = append(detectedComputes, ctx.addComputeNode(node))
 		}
+		ctx.computeLock.Unlock()
 		for _, ch := range detectedComputes {
 			gotNode := <-ch
 			ctx.Log.Info().Msgf("compute node auto-detected: %s", gotNode.EndpointUrl)
@@ -171,15 +201,71 @@ func translateJobTypes(types []string) []be.JobType {
 	jobTypes := make([]be.JobType, len(types))
 	for i, t := range types {
 		switch t {
-		case "embeddings":
+		case settings.JobTypeEmbeddings:
 			jobTypes[i] = be.JT_Embeddings
-		case "completion":
+		case settings.JobTypeCompletion:
 			jobTypes[i] = be.JT_Completion
 		}
 	}
 	return jobTypes
 }
 
+// withUsageAccounting - copy of job's generation settings, which also accounts tokens reported
+// by the engine and compares prompt tokens with the estimate the job was batched by
+func withUsageAccounting(n *be.InferenceNode, job *be.ComputeJob) *engines.GenerationSettings {
+	req := *job.GenerationSettings
+	req.StatisticsCallback = func(info *engines.StatisticsInfo) {
+		if !info.Estimated {
+			n.AccountPromptTokens(job.EstimatedTokens, info.PromptTokens)
+		}
+		job.AccountUsage(info.PromptTokens, info.TokensGenerated)
+		if job.GenerationSettings.StatisticsCallback != nil {
+			job.GenerationSettings.StatisticsCallback(info)
+		}
+	}
+
+	return &req
+}
+
+func translateQuotas(quotas []settings.QuotaConfigurationSection) []*be.Quota {
+	result := make([]*be.Quota, 0, len(quotas))
+	for _, quota := range quotas {
+		result = append(result, &be.Quota{
+			Process:           quota.Process,
+			Tag:               quota.Tag,
+			MaxConcurrentJobs: quota.MaxConcurrentJobs,
+			JobsPerMinute:     quota.JobsPerMinute,
+			GPUSecondsPerHour: quota.GPUSecondsPerHour,
+			SpendPerDay:       quota.SpendPerDay,
+			Defer:             quota.OverQuota == settings.OverQuotaDefer,
+		})
+	}
+	return result
+}
+
 func (ctx *Context) LaunchAgent() {
 
 }
+
+// streamTo - forwards text engine streams to the job's client, marking the job as streamed,
+// so it isn't retried; returned func should be called once engine is done with the job
+func streamTo(job *be.ComputeJob) (chan string, func()) {
+	if job.ComputeResult.StreamChannel == nil {
+		return nil, func() {}
+	}
+
+	stream := make(chan string)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for delta := range stream {
+			job.MarkStreamed()
+			job.ComputeResult.StreamChannel <- delta
+		}
+	}()
+
+	return stream, func() {
+		close(stream)
+		<-forwarded
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PageProvider - downloads pages for get-page requests, status code is the one of the page
+type PageProvider interface {
+	GetPage(url string, timeout time.Duration) (statusCode int, body []byte, err error)
+}
+
+// SearchResult - organic result of the search
+type SearchResult struct {
+	URL     string
+	Title   string
+	Snippet string
+}
+
+// SearchProvider - runs google-search requests
+type SearchProvider interface {
+	Search(keywords, lang, country, location string) (results []SearchResult, answerBox string, err error)
+}
+
+// StaticPages - pages served from memory, unknown urls are not found
+type StaticPages struct {
+	Pages map[string]string
+	Err   error // if set, every download fails with it
+
+	lock      sync.Mutex
+	downloads map[string]int
+}
+
+func (p *StaticPages) GetPage(url string, _ time.Duration) (int, []byte, error) {
+	p.lock.Lock()
+	if p.downloads == nil {
+		p.downloads = make(map[string]int)
+	}
+	p.downloads[url]++
+	p.lock.Unlock()
+
+	if p.Err != nil {
+		return 0, nil, p.Err
+	}
+	page, exists := p.Pages[url]
+	if !exists {
+		return http.StatusNotFound, []byte(fmt.Sprintf("<html><body>%s is not found</body></html>", url)), nil
+	}
+
+	return http.StatusOK, []byte(page), nil
+}
+
+// Downloads - how many times the url was downloaded
+func (p *StaticPages) Downloads(url string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.downloads[url]
+}
+
+// StaticSearch - search results served from memory by keywords, unknown keywords find nothing
+type StaticSearch struct {
+	Results     map[string][]SearchResult
+	AnswerBoxes map[string]string
+	Err         error // if set, every search fails with it
+
+	lock     sync.Mutex
+	searches map[string]int
+}
+
+func (s *StaticSearch) Search(keywords, _, _, _ string) ([]SearchResult, string, error) {
+	s.lock.Lock()
+	if s.searches == nil {
+		s.searches = make(map[string]int)
+	}
+	s.searches[keywords]++
+	s.lock.Unlock()
+
+	if s.Err != nil {
+		return nil, "", s.Err
+	}
+
+	return s.Results[keywords], s.AnswerBoxes[keywords], nil
+}
+
+// Searches - how many times the keywords were searched for
+func (s *StaticSearch) Searches(keywords string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.searches[keywords]
+}
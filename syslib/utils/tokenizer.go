@@ -1,10 +1,28 @@
 package utils
 
-import "github.com/wbrown/gpt_bpe"
+import (
+	"github.com/wbrown/gpt_bpe"
+	"sync"
+)
+
+// loading the vocabulary takes about 100ms, so encoder is loaded once and shared
+var gpt2Encoder gpt_bpe.GPTEncoder
+var gpt2EncoderOnce sync.Once
+var gpt2EncoderLock sync.Mutex
+
+func getGPT2Encoder() *gpt_bpe.GPTEncoder {
+	gpt2EncoderOnce.Do(func() {
+		gpt2Encoder = gpt_bpe.NewGPT2Encoder()
+	})
+
+	return &gpt2Encoder
+}
 
 func TokenizeGPT2(s string) ([]interface{}, error) {
-	tokenizer := gpt_bpe.NewGPT2Encoder()
+	tokenizer := getGPT2Encoder()
+	gpt2EncoderLock.Lock()
 	tokens := tokenizer.Encode(&s)
+	gpt2EncoderLock.Unlock()
 	resultingTokens := make([]interface{}, 0, len(*tokens))
 	for _, token := range *tokens {
 		resultingTokens = append(resultingTokens, token)
@@ -13,14 +31,25 @@ func TokenizeGPT2(s string) ([]interface{}, error) {
 	return resultingTokens, nil
 }
 
+// CountTokensGPT2 - same as len of TokenizeGPT2 result, without copying the tokens
+func CountTokensGPT2(s string) int {
+	tokenizer := getGPT2Encoder()
+	gpt2EncoderLock.Lock()
+	defer gpt2EncoderLock.Unlock()
+
+	return len(*tokenizer.Encode(&s))
+}
+
 func TokensToStringGPT2(iTokens []interface{}) string {
 	tokens := make([]gpt_bpe.Token, 0, len(iTokens))
 	for _, iTok := range iTokens {
 		tokens = append(tokens, iTok.(gpt_bpe.Token))
 	}
-	tokenizer := gpt_bpe.NewGPT2Encoder()
+	tokenizer := getGPT2Encoder()
 	convertedTokens := gpt_bpe.Tokens(tokens)
+	gpt2EncoderLock.Lock()
 	recoveredString := tokenizer.Decode(&convertedTokens)
+	gpt2EncoderLock.Unlock()
 
 	return recoveredString
 }